@@ -22,11 +22,19 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
+	"io/ioutil"
 	"log"
+	"net"
 	"os"
+	"os/signal"
+	"sort"
 	"strings"
+	"syscall"
 	"time"
 
 	"github.com/individuwill/mcast/multicast"
@@ -34,12 +42,19 @@ import (
 
 const (
 	// subcommand keywords
-	sendWord    = "send"
-	receiveWord = "receive"
-	queryWord   = "query"
-	joinWord    = "join"
-	leaveWord   = "leave"
-	helpWord    = "help"
+	sendWord       = "send"
+	receiveWord    = "receive"
+	queryWord      = "query"
+	joinWord       = "join"
+	leaveWord      = "leave"
+	pingWord       = "ping"
+	responderWord  = "responder"
+	traceWord      = "trace"
+	stateWord      = "state"
+	interfacesWord = "interfaces"
+	addrWord       = "addr"
+	runWord        = "run"
+	helpWord       = "help"
 
 	// shared default values for subcommands
 	defaultSendRecvAddress = "239.1.1.50"
@@ -48,8 +63,8 @@ const (
 )
 
 func showHelpMessage() {
-	fmt.Printf("Specify a sub command of: %s, %s, %s, %s, %s, %s\n\n",
-		sendWord, receiveWord, queryWord, joinWord, leaveWord, helpWord)
+	fmt.Printf("Specify a sub command of: %s, %s, %s, %s, %s, %s, %s, %s, %s, %s, %s, %s, %s\n\n",
+		sendWord, receiveWord, queryWord, joinWord, leaveWord, pingWord, responderWord, traceWord, stateWord, interfacesWord, addrWord, runWord, helpWord)
 	fmt.Println("This program will allow you to test multicast and IGMP functionality.")
 	fmt.Println("For help on a specific command, use 'help' followed by that command")
 	fmt.Printf("Ex: mcast help %s\n\n", joinWord)
@@ -57,20 +72,421 @@ func showHelpMessage() {
 	os.Exit(3)
 }
 
-func processSendCommand(sendGroup *string, sendPort *int, sendInterfaceIP, sendText *string, sendTTL, sendTOS, sendPadding, sendInterval, sendStart, sendMax *int) {
-	if strings.Contains(*sendGroup, "/") { // is really a many sender
-		network, mask, err := multicast.SplitCIDR(*sendGroup)
+// interruptContext returns a context that is cancelled when the program is
+// interrupted, so traffic can be stopped and sockets closed cleanly.
+func interruptContext() (context.Context, context.CancelFunc) {
+	return signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+}
+
+// stopped reports whether err is only the result of the program being
+// interrupted.
+func stopped(err error) bool {
+	return err == context.Canceled
+}
+
+// planner is implemented by the senders that can describe the traffic they
+// would send.
+type planner interface {
+	Plan(message string, interval int, startValue int, numberOfMessages int) (*multicast.SendPlan, error)
+}
+
+// showPlan prints the traffic the sender plans to send and exits if this is
+// a dry run. Otherwise it exits if the limits refuse the traffic, and warns
+// when they hold the sender below the rate asked for.
+func showPlan(s planner, dryRun bool, text string, interval, start, max int) {
+	plan, err := s.Plan(text, interval, start, max)
+	if err != nil {
+		fmt.Printf("There was a problem with the message\n%v\n", err)
+		os.Exit(1)
+	}
+	if dryRun {
+		fmt.Printf("Dry run, nothing is sent\n%v\n", plan)
+		if plan.Refused != nil {
+			explainLimit(plan.Refused)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+	if plan.Refused != nil {
+		fmt.Printf("%v\n", plan.Refused)
+		explainLimit(plan.Refused)
+		os.Exit(1)
+	}
+	if plan.PPS < plan.RequestedPPS {
+		fmt.Printf("Warning: sending is held to %.1f pps by -max-pps and -max-bps\n", plan.PPS)
+	}
+}
+
+// setLimits sets the guardrails every sender and join is held to.
+func setLimits(maxPPS, maxBPS float64, maxGroups uint64, allowReserved bool) {
+	multicast.DefaultLimits = multicast.Limits{MaxPPS: maxPPS, MaxBPS: maxBPS, MaxGroups: maxGroups, AllowReserved: allowReserved}
+}
+
+// explainLimit prints how to get past a guardrail if err is one.
+func explainLimit(err error) {
+	var limitErr *multicast.LimitError
+	if !errors.As(err, &limitErr) {
+		return
+	}
+	if limitErr.Reserved {
+		fmt.Printf("Use -i-know-what-im-doing to send to it anyway\n")
+	} else {
+		fmt.Printf("Use -max-groups to raise the limit\n")
+	}
+}
+
+// statsReporter is implemented by the senders that keep statistics.
+type statsReporter interface {
+	Stats() multicast.SendStats
+}
+
+func printStats(stats multicast.SendStats, format string, final bool) {
+	if format == "json" {
+		out, err := json.Marshal(struct {
+			Final bool `json:"final"`
+			multicast.SendStats
+		}{final, stats})
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		fmt.Println(string(out))
+		return
+	}
+	if final {
+		fmt.Printf("Sent: %v\n", stats)
+	} else {
+		fmt.Printf("Sending: %v\n", stats)
+	}
+}
+
+// maxMissingShown limits how many missing ranges are printed per stream.
+const maxMissingShown = 20
+
+// printStream prints the summary of a stream of test packets, how many
+// packets of each size arrived and which sequence numbers are missing.
+func printStream(stream multicast.StreamStats) {
+	fmt.Println(stream)
+	if len(stream.Sizes) > 1 || len(stream.Missing) > 0 {
+		sizes := make([]int, 0, len(stream.Sizes))
+		for size := range stream.Sizes {
+			sizes = append(sizes, size)
+		}
+		sort.Ints(sizes)
+		for _, size := range sizes {
+			fmt.Printf("  %d bytes: %d packets\n", size, stream.Sizes[size])
+		}
+	}
+	if len(stream.ReceivedTOS) > 0 {
+		received := map[int]uint64{}
+		for tos, count := range stream.ReceivedTOS {
+			received[tos>>2] += count
+		}
+		dscps := make([]int, 0, len(received))
+		for dscp := range received {
+			dscps = append(dscps, dscp)
+		}
+		sort.Ints(dscps)
+		parts := make([]string, len(dscps))
+		for i, dscp := range dscps {
+			parts[i] = fmt.Sprintf("%v: %d packets", multicast.DSCPName(dscp), received[dscp])
+		}
+		remarked := ""
+		if stream.Remarked > 0 {
+			remarked = " REMARKED"
+		}
+		fmt.Printf("  DSCP sent %v, received %v%v\n", multicast.DSCPName(stream.SentTOS>>2), strings.Join(parts, ", "), remarked)
+	}
+	if len(stream.TTLs) > 1 || stream.SweepTTL > 0 {
+		hops := "unknown"
+		if stream.Hops >= 0 {
+			hops = fmt.Sprint(stream.Hops)
+		}
+		fmt.Printf("  TTL: lowest sent TTL received %d, hops %v\n", stream.LowestTTL, hops)
+		if stream.TTLThreshold() {
+			from, to := stream.ThresholdTTLs()
+			fmt.Printf("  TTL threshold: packets sent with TTL %d-%d had enough TTL for %d hops but didn't arrive\n",
+				from, to, stream.Hops)
+		}
+	}
+	for i, missing := range stream.Missing {
+		if i == maxMissingShown {
+			fmt.Printf("  ... %d more missing ranges\n", len(stream.Missing)-i)
+			break
+		}
+		fmt.Printf("  %v\n", missing)
+	}
+}
+
+// reportStats prints the sender's statistics every interval seconds until
+// the returned function is called, which prints the final summary.
+func reportStats(sender statsReporter, interval int, format string) func() {
+	done := make(chan struct{})
+	finished := make(chan struct{})
+	go func() {
+		defer close(finished)
+		if interval <= 0 {
+			<-done
+			return
+		}
+		ticker := time.NewTicker(time.Duration(interval) * time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				printStats(sender.Stats(), format, false)
+			case <-done:
+				return
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		<-finished
+		printStats(sender.Stats(), format, true)
+	}
+}
+
+// loadPayload returns the binary payload from whichever of the payload
+// options was given, or nil if none were.
+func loadPayload(file, hexString string, stdin bool) ([]byte, error) {
+	given := 0
+	for _, set := range []bool{file != "", hexString != "", stdin} {
+		if set {
+			given++
+		}
+	}
+	if given > 1 {
+		return nil, errors.New("Only one of payload-file, payload-hex and payload-stdin can be used")
+	}
+	switch {
+	case file != "":
+		return ioutil.ReadFile(file)
+	case hexString != "":
+		return multicast.ParseHexPayload(hexString)
+	case stdin:
+		return ioutil.ReadAll(os.Stdin)
+	}
+	return nil, nil
+}
+
+// parseSources parses the forged source addresses to send from, given in any
+// of the forms ParseAddressSet takes as long as they are all IPv4.
+func parseSources(source string) (*multicast.AddressSet, error) {
+	sources, err := multicast.ParseAddressSet(source)
+	if err != nil {
+		return nil, err
+	}
+	if sources.Count() == 0 {
+		return nil, fmt.Errorf("%q gives no source addresses", source)
+	}
+	if !sources.IsIPv4() {
+		return nil, fmt.Errorf("%q has an address that isn't IPv4", source)
+	}
+	return sources, nil
+}
+
+// sendTOSByte works out the TOS byte to send with, replacing the DSCP and
+// ECN bits of tos with those named by dscp and ecn if they are given.
+func sendTOSByte(tos int, dscp string, ecn string) (int, error) {
+	if tos < 0 || tos > 255 {
+		return 0, fmt.Errorf("TOS must be from 0 to 255")
+	}
+	if dscp != "" {
+		d, err := multicast.ParseDSCP(dscp)
+		if err != nil {
+			return 0, err
+		}
+		tos = multicast.TOSByte(d, tos&3)
+	}
+	if ecn != "" {
+		e, err := multicast.ParseECN(ecn)
+		if err != nil {
+			return 0, err
+		}
+		tos = multicast.TOSByte(tos>>2, e)
+	}
+	return tos, nil
+}
+
+// warnGroups prints the warnings for the groups, sent with the TTL or
+// received if the TTL is negative. Only the first group of each kind is
+// checked, so a large range gives a warning once. Of a very large set only
+// the start and the last group are looked at.
+func warnGroups(set *multicast.AddressSet, ttl int) {
+	checked := map[string]bool{}
+	var groups []net.IP
+	it := set.Iter()
+	for i := 0; i < 4096; i++ {
+		group, ok := it.Next()
+		if !ok {
+			break
+		}
+		groups = append(groups, group)
+	}
+	if count := set.Count(); count > 0 {
+		groups = append(groups, set.At(count-1))
+	}
+	for _, group := range groups {
+		kind := multicast.ClassifyAddress(group).String()
+		if !group.IsMulticast() || checked[kind] {
+			continue
+		}
+		checked[kind] = true
+		for _, warning := range multicast.GroupWarnings(group, ttl) {
+			fmt.Printf("Warning: %v\n", warning)
+		}
+	}
+}
+
+// parseGroupsAndPorts parses the -group and -port flags, narrowed by
+// -skip-edges, -shard and -sample, exiting if they can't be parsed or give
+// no groups.
+func parseGroupsAndPorts(groupSpec, portSpec string, skipEdges bool, sample int, seed int64, shard string) (*multicast.AddressSet, []int) {
+	groups, err := multicast.ParseAddressSet(groupSpec)
+	if err != nil {
+		fmt.Printf("There was a problem with the group\n%v\n", err)
+		os.Exit(1)
+	}
+	if skipEdges {
+		groups = groups.SkipEdges()
+	}
+	if shard != "" {
+		index, total, err := multicast.ParseShard(shard)
+		if err == nil {
+			groups, err = groups.Shard(index, total)
+		}
+		if err != nil {
+			fmt.Printf("There was a problem with the shard\n%v\n", err)
+			os.Exit(1)
+		}
+	}
+	if sample > 0 {
+		groups, err = groups.Sample(uint64(sample), seed)
+		if err != nil {
+			fmt.Printf("There was a problem with the sample\n%v\n", err)
+			os.Exit(1)
+		}
+	}
+	if groups.Count() == 0 {
+		fmt.Printf("There was a problem with the group\n%q gives no groups\n", groupSpec)
+		os.Exit(1)
+	}
+	ports, err := multicast.ParsePorts(portSpec)
+	if err != nil {
+		fmt.Printf("There was a problem with the port\n%v\n", err)
+		os.Exit(1)
+	}
+	return groups, ports
+}
+
+func processSendCommand(sendGroup, sendPort *string, sendSkipEdges *bool, sendSample *int, sendSeed *int64, sendShard *string, sendInterfaceIP, sendText *string, sendTTL, sendTOS, sendPadding, sendInterval, sendStart, sendMax *int, sendProfile *string, sendBatch, sendSockets *int, sendRate, sendGroupRate *float64, sendStatsInterval *int, sendStatsFormat *string, sendPayloadFile, sendPayloadHex *string, sendPayloadStdin *bool, sendPattern *string, sendHeader, sendCRC *bool, sendSize, sendDF *string, sendInterface *string, sendLoopback *bool, sendDSCP, sendECN *string, sendTTLSweep *string, sendSource *string, sendSourcePort *int, sendDryRun *bool, sendMaxPPS, sendMaxBPS *float64, sendMaxGroups *uint64, sendUnsafe *bool) {
+	setLimits(*sendMaxPPS, *sendMaxBPS, *sendMaxGroups, *sendUnsafe)
+	payload, err := loadPayload(*sendPayloadFile, *sendPayloadHex, *sendPayloadStdin)
+	if err != nil {
+		fmt.Printf("There was a problem with the payload\n%v\n", err)
+		os.Exit(1)
+	}
+	var sizes *multicast.Sizes
+	if *sendSize != "" {
+		if *sendPadding > 0 {
+			fmt.Printf("Use either -size or -padding, not both\n")
+			os.Exit(1)
+		}
+		sizes, err = multicast.ParseSizes(*sendSize)
 		if err != nil {
-			fmt.Printf("Couldn't parse the mask")
+			fmt.Printf("There was a problem with the size\n%v\n", err)
+			os.Exit(1)
+		}
+	}
+	tos, err := sendTOSByte(*sendTOS, *sendDSCP, *sendECN)
+	if err != nil {
+		fmt.Printf("%v\n", err)
+		os.Exit(1)
+	}
+	var ttlSweep []int
+	if *sendTTLSweep != "" {
+		ttlSweep, err = multicast.ParseTTLSweep(*sendTTLSweep)
+		if err != nil {
+			fmt.Printf("There was a problem with the TTL sweep\n%v\n", err)
+			os.Exit(1)
+		}
+		// the receiver needs the header to tell which TTL each packet was sent with
+		*sendHeader = true
+	}
+	warnTTL := *sendTTL
+	if len(ttlSweep) > 0 {
+		// a sweep only stays on the local network if its largest TTL does
+		warnTTL = 0
+		for _, ttl := range ttlSweep {
+			if ttl > warnTTL {
+				warnTTL = ttl
+			}
+		}
+	}
+	groups, ports := parseGroupsAndPorts(*sendGroup, *sendPort, *sendSkipEdges, *sendSample, *sendSeed, *sendShard)
+	warnGroups(groups, warnTTL)
+	var sources *multicast.AddressSet
+	if *sendSource != "" {
+		if groups.Count()*uint64(len(ports)) > 1 {
+			fmt.Printf("A forged -source can only be used with a single group and port\n")
 			os.Exit(1)
 		}
-		s, err := multicast.NewManySender(network, int(mask), *sendPort, *sendTTL)
+		sources, err = parseSources(*sendSource)
 		if err != nil {
-			fmt.Printf("Couldn't create many sender")
+			fmt.Printf("There was a problem with the source address\n%v\n", err)
 			os.Exit(1)
 		}
-		s.SetTOS(*sendTOS)
+	}
+	fragmentation, err := multicast.ParseFragmentation(*sendDF)
+	if err != nil {
+		fmt.Printf("%v\n", err)
+		os.Exit(1)
+	}
+	var pattern *multicast.Pattern
+	if *sendPattern != "" {
+		if *sendPadding <= 0 && sizes == nil {
+			fmt.Printf("A pattern needs -padding or -size to set the packet size it fills up to\n")
+			os.Exit(1)
+		}
+		pattern, err = multicast.ParsePattern(*sendPattern)
+		if err != nil {
+			fmt.Printf("There was a problem with the pattern\n%v\n", err)
+			os.Exit(1)
+		}
+		// the receiver only checks the pattern of packets with a header
+		*sendHeader = true
+	}
+	if *sendStatsFormat != "text" && *sendStatsFormat != "json" {
+		fmt.Printf("Stats format must be text or json\n")
+		os.Exit(1)
+	}
+	var profile multicast.Profile
+	if *sendProfile != "" {
+		profile, err = multicast.ParseProfile(*sendProfile)
+		if err != nil {
+			fmt.Printf("There was a problem with the profile\n%v\n", err)
+			os.Exit(1)
+		}
+	}
+	if groups.Count()*uint64(len(ports)) > 1 { // is really a many sender
+		s := multicast.NewManySenderSet(groups, ports[0], *sendTTL)
+		if len(ports) > 1 {
+			s.SetPorts(ports)
+		}
+		s.SetTOS(tos)
 		s.SetMessagePadding(*sendPadding)
+		if sizes != nil {
+			s.SetSizes(sizes)
+		}
+		s.SetFragmentation(fragmentation)
+		s.SetTTLSweep(ttlSweep)
+		s.SetProfile(profile)
+		s.SetBatchSize(*sendBatch)
+		s.SetPayload(payload)
+		s.SetPattern(pattern)
+		s.SetTestHeader(*sendHeader)
+		s.SetCRC(*sendCRC)
 		sourceAddress := "host-chosen-address"
 		if sendInterfaceIP != nil && *sendInterfaceIP != "" {
 			err := s.SetLocalAddress(*sendInterfaceIP)
@@ -80,12 +496,44 @@ func processSendCommand(sendGroup *string, sendPort *int, sendInterfaceIP, sendT
 			}
 			sourceAddress = *sendInterfaceIP
 		}
-		fmt.Printf("Sending from %v to %v:%d\n", sourceAddress, *sendGroup, *sendPort)
-		s.Start(*sendText, *sendInterval, *sendStart, *sendMax)
+		if err := s.SetInterface(*sendInterface); err != nil {
+			fmt.Printf("There was a problem with the interface\n%v\n", err)
+			os.Exit(1)
+		}
+		s.SetLoopback(*sendLoopback)
+		if *sendInterface != "" {
+			sourceAddress = fmt.Sprintf("%v on %v", sourceAddress, *sendInterface)
+		}
+		s.SetSockets(*sendSockets)
+		s.SetRate(*sendRate)
+		s.SetGroupRate(*sendGroupRate)
+		showPlan(s, *sendDryRun, *sendText, *sendInterval, *sendStart, *sendMax)
+		fmt.Printf("Sending from %v to %v:%v\n", sourceAddress, *sendGroup, *sendPort)
+		ctx, stop := interruptContext()
+		defer stop()
+		finishStats := reportStats(s, *sendStatsInterval, *sendStatsFormat)
+		err = s.StartContext(ctx, *sendText, *sendInterval, *sendStart, *sendMax)
+		finishStats()
+		if err != nil && !stopped(err) {
+			fmt.Printf("%v\n", err)
+			explainLimit(err)
+			os.Exit(1)
+		}
 	} else {
-		s := multicast.NewSender(*sendGroup, *sendPort, *sendTTL)
-		s.SetTOS(*sendTOS)
+		s := multicast.NewSender(groups.At(0).String(), ports[0], *sendTTL)
+		s.SetTOS(tos)
 		s.SetMessagePadding(*sendPadding)
+		if sizes != nil {
+			s.SetSizes(sizes)
+		}
+		s.SetFragmentation(fragmentation)
+		s.SetTTLSweep(ttlSweep)
+		s.SetProfile(profile)
+		s.SetBatchSize(*sendBatch)
+		s.SetPayload(payload)
+		s.SetPattern(pattern)
+		s.SetTestHeader(*sendHeader)
+		s.SetCRC(*sendCRC)
 		sourceAddress := "host-chosen-address"
 		if sendInterfaceIP != nil && *sendInterfaceIP != "" {
 			err := s.SetLocalAddress(*sendInterfaceIP)
@@ -95,36 +543,60 @@ func processSendCommand(sendGroup *string, sendPort *int, sendInterfaceIP, sendT
 			}
 			sourceAddress = *sendInterfaceIP
 		}
-		fmt.Printf("Sending from %v to %v:%d\n", sourceAddress, *sendGroup, *sendPort)
-		if *sendMax == 1 {
-			err := s.One(*sendText)
-			if err != nil {
-				fmt.Printf("%v\n", err)
-				os.Exit(1)
-			}
-		} else if *sendMax > 0 {
-			err := s.Max(*sendText, *sendInterval, *sendStart, *sendMax)
-			if err != nil {
-				fmt.Printf("%v\n", err)
-				os.Exit(1)
-			}
+		if err := s.SetInterface(*sendInterface); err != nil {
+			fmt.Printf("There was a problem with the interface\n%v\n", err)
+			os.Exit(1)
+		}
+		s.SetLoopback(*sendLoopback)
+		if *sendInterface != "" {
+			sourceAddress = fmt.Sprintf("%v on %v", sourceAddress, *sendInterface)
+		}
+		if sources != nil {
+			s.SetSources(sources, *sendSourcePort)
+			sourceAddress = fmt.Sprintf("forged %v", *sendSource)
+		}
+		showPlan(s, *sendDryRun, *sendText, *sendInterval, *sendStart, *sendMax)
+		fmt.Printf("Sending from %v to %v:%v\n", sourceAddress, *sendGroup, *sendPort)
+		ctx, stop := interruptContext()
+		defer stop()
+		finishStats := reportStats(s, *sendStatsInterval, *sendStatsFormat)
+		if *sendMax > 0 {
+			err = s.MaxContext(ctx, *sendText, *sendInterval, *sendStart, *sendMax)
 		} else {
-			err := s.Forever(*sendText, *sendInterval, *sendStart)
-			if err != nil {
-				fmt.Printf("%v\n", err)
-				os.Exit(1)
-			}
+			err = s.ForeverContext(ctx, *sendText, *sendInterval, *sendStart)
+		}
+		closeErr := s.Close()
+		finishStats()
+		if err == nil {
+			err = closeErr
+		}
+		if err != nil && !stopped(err) {
+			fmt.Printf("%v\n", err)
+			explainLimit(err)
+			os.Exit(1)
 		}
 	}
 }
 
-func processReceiveCommand(receiveGroup *string, receivePort *int, receiveInterface *string, receiveShowData *bool) {
+func processReceiveCommand(receiveGroup, receivePort *string, receiveSkipEdges *bool, receiveSample *int, receiveSeed *int64, receiveShard *string, receiveInterface *string, receiveShowData *bool) {
+	groups, ports := parseGroupsAndPorts(*receiveGroup, *receivePort, *receiveSkipEdges, *receiveSample, *receiveSeed, *receiveShard)
 	visibleInterface := "host-chosen"
 	if *receiveInterface != "" {
 		visibleInterface = *receiveInterface
 	}
-	fmt.Printf("Listening on %v:%d interface: %v\n", *receiveGroup, *receivePort, visibleInterface)
-	err := multicast.Receive(*receiveGroup, *receivePort, *receiveInterface, *receiveShowData)
+	fmt.Printf("Listening on %v:%v interface: %v\n", *receiveGroup, *receivePort, visibleInterface)
+	warnGroups(groups, -1)
+	ctx, stop := interruptContext()
+	defer stop()
+	r := multicast.NewReceiver(*receiveGroup, ports[0], *receiveInterface, *receiveShowData)
+	r.SetGroups(groups)
+	r.SetPorts(ports)
+	err := r.Start(ctx)
+	packets, bytes := r.Received()
+	fmt.Printf("Received %d packets (%d bytes)\n", packets, bytes)
+	for _, stream := range r.Streams() {
+		printStream(stream)
+	}
 	if err != nil {
 		fmt.Println("Problem receiving")
 		fmt.Println(err)
@@ -136,12 +608,294 @@ func processQueryCommand(queryInterface, queryInterfaceIP *string, queryInterval
 	panic("Not implemented")
 }
 
-func processJoinCommand(joinGroup *string, joinPort *int, joinInterface *string, joinRaw *bool, joinInterval *int, joinRouterAlert *bool, joinIGMPVersion *int) {
+func processPingCommand(pingResponder, pingGroup, pingInterface *string, pingCount, pingInterval, pingTimeout *int) {
+	if *pingResponder == "" {
+		fmt.Println("The responder to ping must be given with -responder")
+		os.Exit(1)
+	}
+	p, err := multicast.NewPinger(*pingResponder, *pingGroup)
+	if err != nil {
+		fmt.Printf("There was a problem with the ping options\n%v\n", err)
+		os.Exit(1)
+	}
+	p.InterfaceName = *pingInterface
+	p.Count = *pingCount
+	p.Interval = time.Duration(*pingInterval) * time.Millisecond
+	p.Timeout = time.Duration(*pingTimeout) * time.Millisecond
+	fmt.Printf("Pinging %v with multicast replies to %v\n", p.Responder, p.Group)
+	ctx, stop := interruptContext()
+	defer stop()
+	stats, err := p.Run(ctx, func(reply multicast.PingReply) {
+		fmt.Println(reply)
+	})
+	fmt.Printf("%d requests sent\n", stats.Sent)
+	fmt.Printf("  unicast:   %v\n", stats.Unicast)
+	fmt.Printf("  multicast: %v\n", stats.Multicast)
+	if stats.Unicast.Received > 0 && stats.Multicast.Received == 0 {
+		fmt.Printf("Multicast from %v to this host isn't working, though unicast is\n", p.Responder.IP)
+	}
+	if err != nil {
+		fmt.Printf("Problem pinging\n%v\n", err)
+		os.Exit(1)
+	}
+}
+
+func processResponderCommand(responderPort, responderTTL *int, responderInterface *string) {
+	r := multicast.NewResponder(*responderPort, *responderTTL, *responderInterface)
+	fmt.Printf("Answering pings on port %d\n", *responderPort)
+	ctx, stop := interruptContext()
+	defer stop()
+	if err := r.Start(ctx); err != nil {
+		fmt.Printf("Problem answering pings\n%v\n", err)
+		os.Exit(1)
+	}
+}
+
+func processTraceCommand(traceSource, traceGroup, traceRouter, traceInterface, traceClient *string, traceHops, traceTimeout *int) {
+	if *traceSource == "" {
+		fmt.Println("The source to trace from must be given with -source")
+		os.Exit(1)
+	}
+	t, err := multicast.NewTracer(*traceSource, *traceGroup)
+	if err == nil {
+		err = t.SetRouter(*traceRouter)
+	}
+	if err != nil {
+		fmt.Printf("There was a problem with the trace options\n%v\n", err)
+		os.Exit(1)
+	}
+	if *traceClient != "" {
+		t.Client = net.ParseIP(*traceClient).To4()
+		if t.Client == nil {
+			fmt.Printf("There was a problem with the trace options\n%q is not an IPv4 address\n", *traceClient)
+			os.Exit(1)
+		}
+	}
+	if *traceHops < 1 || *traceHops > 255 {
+		fmt.Println("Hops must be from 1 to 255")
+		os.Exit(1)
+	}
+	t.InterfaceName = *traceInterface
+	t.MaxHops = *traceHops
+	t.Timeout = time.Duration(*traceTimeout) * time.Millisecond
+	fmt.Printf("Tracing the path from %v to this host for group %v via %v\n", t.Source, t.Group, t.Router)
+	ctx, stop := interruptContext()
+	defer stop()
+	reply, err := t.Run(ctx)
+	if err != nil {
+		fmt.Printf("Problem tracing\n%v\n", err)
+		os.Exit(1)
+	}
+	fmt.Print(multicast.FormatTracePath(reply))
+	fmt.Printf("%d hops traced\n", len(reply.Path))
+}
+
+func printInterfaceState(ifs *multicast.InterfaceState) {
+	version := ""
+	if ifs.IGMPVersion > 0 {
+		version = fmt.Sprintf(", IGMPv%d", ifs.IGMPVersion)
+	}
+	fmt.Printf("%v (index %d)%v\n", ifs.Name, ifs.Index, version)
+	for _, g := range ifs.Groups {
+		details := ""
+		if g.TimerRunning {
+			details += fmt.Sprintf("  report timer %d", g.Timer)
+		}
+		if g.Reporter {
+			details += "  last reporter"
+		}
+		fmt.Printf("  group %v  users %d%v\n", g.Group, g.Users, details)
+		for _, f := range g.Sources {
+			fmt.Printf("    source %v  include %d  exclude %d\n", f.Source, f.Include, f.Exclude)
+		}
+	}
+	for _, a := range ifs.LinkAddresses {
+		fmt.Printf("  link address %v  users %d\n", a.Address, a.Users)
+	}
+}
+
+func processStateCommand(stateInterface, stateGroup *string) {
+	state, err := multicast.ReadMulticastState()
+	if err != nil {
+		fmt.Printf("There was a problem reading the multicast state\n%v\n", err)
+		os.Exit(1)
+	}
+	interfaces := state.Interfaces
+	if *stateInterface != "" {
+		ifs := state.Interface(*stateInterface)
+		if ifs == nil {
+			fmt.Printf("Interface %v has no multicast state\n", *stateInterface)
+			os.Exit(1)
+		}
+		interfaces = []*multicast.InterfaceState{ifs}
+	}
+	if *stateGroup == "" {
+		for _, ifs := range interfaces {
+			printInterfaceState(ifs)
+		}
+		return
+	}
+
+	group := net.ParseIP(*stateGroup)
+	if group == nil || !group.IsMulticast() {
+		fmt.Printf("There was a problem with the group\n%q is not a multicast group\n", *stateGroup)
+		os.Exit(1)
+	}
+	joined := false
+	for _, ifs := range interfaces {
+		g := ifs.Group(group)
+		if g == nil {
+			continue
+		}
+		joined = true
+		fmt.Printf("%v is joined on %v by %d users\n", group, ifs.Name, g.Users)
+		for _, f := range g.Sources {
+			fmt.Printf("  source %v  include %d  exclude %d\n", f.Source, f.Include, f.Exclude)
+		}
+	}
+	if !joined {
+		fmt.Printf("%v is not joined\n", group)
+		os.Exit(1)
+	}
+}
+
+// checkInterfaceFlag exits with a helpful message if an -interface flag names
+// an interface that can't be used for multicast.
+func checkInterfaceFlag(name string) {
+	if name == "" {
+		return
+	}
+	if _, err := multicast.LookupMulticastInterface(name); err != nil {
+		fmt.Printf("There was a problem with the interface\n%v\nUse 'mcast %s' to list them\n", err, interfacesWord)
+		os.Exit(1)
+	}
+}
+
+func processInterfacesCommand(interfacesAll *bool) {
+	infos, err := multicast.ListInterfaces()
+	if err != nil {
+		fmt.Printf("There was a problem listing the interfaces\n%v\n", err)
+		os.Exit(1)
+	}
+	defaultName := ""
+	if ifi, err := multicast.DefaultMulticastInterface(nil); err == nil {
+		defaultName = ifi.Name
+	}
+	for _, info := range infos {
+		if !*interfacesAll && !info.Multicast() {
+			continue
+		}
+		isDefault := ""
+		if info.Name == defaultName {
+			isDefault = "  (default for multicast)"
+		}
+		fmt.Printf("%d: %v  mtu %d  <%v>%v\n", info.Index, info.Name, info.MTU, info.Flags, isDefault)
+		if len(info.HardwareAddr) > 0 {
+			fmt.Printf("    link %v\n", info.HardwareAddr)
+		}
+		for _, addr := range info.Addrs {
+			family := "inet6"
+			if addr.IP.To4() != nil {
+				family = "inet"
+			}
+			fmt.Printf("    %v %v\n", family, addr)
+		}
+		for _, group := range info.Groups {
+			fmt.Printf("    group %v\n", group)
+		}
+	}
+}
+
+func processRunCommand(runFile string, runFormat *string) {
+	if runFile == "" {
+		fmt.Printf("The scenario file must be given, as in: mcast %v scenario.json or scenario.yaml\n", runWord)
+		os.Exit(1)
+	}
+	if *runFormat != "text" && *runFormat != "json" {
+		fmt.Printf("Report format must be text or json\n")
+		os.Exit(1)
+	}
+	scenario, err := multicast.ReadScenario(runFile)
+	if err != nil {
+		fmt.Printf("There was a problem with the scenario\n%v\n", err)
+		os.Exit(1)
+	}
+	ctx, stop := interruptContext()
+	defer stop()
+	report := scenario.Run(ctx)
+	if *runFormat == "json" {
+		out, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			fmt.Printf("There was a problem with the report\n%v\n", err)
+			os.Exit(1)
+		}
+		fmt.Println(string(out))
+	} else {
+		fmt.Println(report)
+	}
+	if !report.Passed {
+		os.Exit(1)
+	}
+}
+
+func processAddrCommand(addrGroup, addrFile *string, addrCollisions *bool, addrGLOP *int) {
+	if *addrGLOP != 0 {
+		network, err := multicast.GLOPNetwork(*addrGLOP)
+		if err != nil {
+			fmt.Printf("There was a problem with the AS number\n%v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("AS %d has the GLOP groups %v\n", *addrGLOP, network)
+		if *addrGroup == "" && *addrFile == "" {
+			return
+		}
+	}
+	groups, err := multicast.ParseGroups(*addrGroup)
+	if err == nil && *addrFile != "" {
+		var f *os.File
+		if f, err = os.Open(*addrFile); err == nil {
+			var more []net.IP
+			more, err = multicast.ReadGroups(f)
+			f.Close()
+			groups = append(groups, more...)
+		}
+	}
+	if err == nil && len(groups) == 0 {
+		err = errors.New("no groups given, use -group or -file")
+	}
+	if err != nil {
+		fmt.Printf("There was a problem with the groups\n%v\n", err)
+		os.Exit(1)
+	}
+
+	macs := map[string]bool{}
+	for _, group := range groups {
+		mac, err := multicast.MulticastMAC(group)
+		if err != nil {
+			fmt.Printf("There was a problem with the groups\n%v\n", err)
+			os.Exit(1)
+		}
+		macs[string(mac)] = true
+		if !*addrCollisions {
+			fmt.Printf("%-16v %v  %v\n", group, mac, multicast.ClassifyAddress(group))
+		}
+	}
+	collisions, _ := multicast.FindMACCollisions(groups)
+	fmt.Printf("%d groups use %d MAC addresses, %d of them shared by more than one group\n", len(groups), len(macs), len(collisions))
+	for _, c := range collisions {
+		fmt.Printf("  %v\n", c)
+	}
+}
+
+func processJoinCommand(joinGroup *string, joinPort *int, joinInterface *string, joinRaw *bool, joinInterval *int, joinRouterAlert *bool, joinIGMPVersion *int, joinMaxGroups *uint64) {
 	if *joinRaw {
-		err := multicast.JoinRaw(*joinGroup, *joinPort, *joinInterface, *joinInterval, *joinRouterAlert, *joinIGMPVersion)
+		limits := multicast.DefaultLimits
+		limits.MaxGroups = *joinMaxGroups
+		err := multicast.JoinRaw(*joinGroup, *joinPort, *joinInterface, *joinInterval, *joinRouterAlert, *joinIGMPVersion, limits)
 		if err != nil {
 			fmt.Println("Problem with raw join of the group")
 			fmt.Println(err)
+			explainLimit(err)
 			os.Exit(1)
 		}
 	} else {
@@ -174,22 +928,64 @@ func processCommands() {
 	queryCommand := flag.NewFlagSet(queryWord, flag.ExitOnError)
 	joinCommand := flag.NewFlagSet(joinWord, flag.ExitOnError)
 	leaveCommand := flag.NewFlagSet(leaveWord, flag.ExitOnError)
+	pingCommand := flag.NewFlagSet(pingWord, flag.ExitOnError)
+	responderCommand := flag.NewFlagSet(responderWord, flag.ExitOnError)
+	traceCommand := flag.NewFlagSet(traceWord, flag.ExitOnError)
+	stateCommand := flag.NewFlagSet(stateWord, flag.ExitOnError)
+	interfacesCommand := flag.NewFlagSet(interfacesWord, flag.ExitOnError)
+	addrCommand := flag.NewFlagSet(addrWord, flag.ExitOnError)
+	runCommand := flag.NewFlagSet(runWord, flag.ExitOnError)
 
 	// send subcommand
-	sendGroup := sendCommand.String("group", defaultSendRecvAddress, "destination multicast group address. Can use CIDR notation to send on multiple addresses.")
-	sendPort := sendCommand.Int("port", defaultSendRecvPort, "destination port")
-	sendInterfaceIP := sendCommand.String("interface-ip", "", "interface to use defined by IP addrress. default allows system to decide. must be in 0.0.0.0:0000 format")
+	sendGroup := sendCommand.String("group", defaultSendRecvAddress, "destination multicast group address. Can be a comma separated list of addresses, CIDR networks, ranges such as 239.1.1.10-90, @file of groups and !exclusions to send on multiple addresses.")
+	sendPort := sendCommand.String("port", fmt.Sprint(defaultSendRecvPort), "destination port. Can be a comma separated list of ports and ranges such as 5000-5010, sent to for every group")
+	sendSkipEdges := sendCommand.Bool("skip-edges", false, "leave out groups ending in .0 or .255")
+	sendSample := sendCommand.Int("sample", 0, "send to this many groups picked at random from -group instead of all of them. '0' for all")
+	sendSeed := sendCommand.Int64("seed", 1, "seed for -sample. The same seed picks the same groups")
+	sendShard := sendCommand.String("shard", "", "send to one part of -group, written as index/total such as 0/4, to split a large range across hosts")
+	sendInterfaceIP := sendCommand.String("interface-ip", "", "local IP address to send from, with an optional source port as 0.0.0.0:0000. default allows system to decide")
+	sendInterface := sendCommand.String("interface", "", "interface name to send multicast out of. default allows system to decide")
+	sendLoopback := sendCommand.Bool("loopback", true, "deliver the multicast sent to listeners on this host too")
 	sendTTL := sendCommand.Int("ttl", defaultSendTTL, "IP ttl (time to live)")
-	sendTOS := sendCommand.Int("tos", 0, "TOS / DSCP to be set. Only works on unicast addresses. 0xB8 for voice")
-	sendText := sendCommand.String("text", "This is test number: {c}", "text to send to the receiver. Use '{c}' to access counter")
+	sendTOS := sendCommand.Int("tos", 0, "TOS byte to be set. 0xB8 for voice")
+	sendDSCP := sendCommand.String("dscp", "", "DSCP to be set by name (EF, AF41, CS5, ...) or number. Replaces the DSCP bits of -tos")
+	sendECN := sendCommand.String("ecn", "", "ECN bits to be set: not-ect, ect0, ect1 or ce. Replaces the ECN bits of -tos")
+	sendText := sendCommand.String("text", "This is test number: {c}", "text to send to the receiver. Placeholders: {c} counter, {seq:hex} counter in hex, {ts} unix nanoseconds, {iso} RFC 3339 time, {host}, {group}, {port}, {src}, {rand:N} N random characters")
 	sendPadding := sendCommand.Int("padding", 0, "Length to pad the message")
 	sendInterval := sendCommand.Int("interval", 1000, "interval between sending messages (milliseconds).")
 	sendStart := sendCommand.Int("start-value", 1, "non-negative start value message incrementer")
 	sendMax := sendCommand.Int("max", 0, "number of packets to send. '0' for unlimited")
+	sendBatch := sendCommand.Int("batch", 1, "number of packets handed to the kernel per system call (sendmmsg on Linux). The interval is applied between batches. Ignored with -profile.")
+	sendSockets := sendCommand.Int("sockets", 1, "number of shared sockets used when sending to a CIDR range")
+	sendRate := sendCommand.Float64("rate", 0, "cap on packets per second across all groups when sending to a CIDR range. '0' for no cap")
+	sendGroupRate := sendCommand.Float64("group-rate", 0, "packets per second sent to each group when sending to a CIDR range. Overrides interval. '0' to use interval")
+	sendStatsInterval := sendCommand.Int("stats-interval", 0, "print send statistics every this many seconds. '0' to only print the final summary")
+	sendStatsFormat := sendCommand.String("stats-format", "text", "format of the send statistics: text or json")
+	sendPayloadFile := sendCommand.String("payload-file", "", "file whose contents, which may be binary, are sent instead of the text")
+	sendPayloadHex := sendCommand.String("payload-hex", "", "hexadecimal bytes sent instead of the text. Ex: 'de ad be ef' or 00:01:02")
+	sendPayloadStdin := sendCommand.Bool("payload-stdin", false, "send everything read from standard input instead of the text")
+	sendPattern := sendCommand.String("pattern", "", "fill the padding after the message with a pattern generated from the counter: zeros, increment, prbs7, prbs15, prbs31 (prbs), random or random:seed=N. Turns on -header")
+	sendSize := sendCommand.String("size", "", "vary the packet size instead of padding: a size, a sweep as 64..9000 step 64, a list as 64,512,1500, or a weighted distribution as 7:64,4:576,1:1500 (imix)")
+	sendSource := sendCommand.String("source", "", "forged source address, or network in CIDR notation to send every packet from each address in, written as raw UDP. Needs root")
+	sendSourcePort := sendCommand.Int("source-port", 0, "source port for -source. default is the destination port")
+	sendTTLSweep := sendCommand.String("ttl-sweep", "", "send each packet with the next TTL from a range, 1..64, or list, 1,2,4, to find how many hops away receivers are and any TTL thresholds. Turns on -header")
+	sendDF := sendCommand.String("df", "default", "don't fragment bit: set (datagrams larger than the MTU fail to send), clear (they are sent as IP fragments) or default (kernel path MTU discovery). Linux only")
+	sendHeader := sendCommand.Bool("header", false, "start every packet with a test header so the receiver can count lost packets and verify the pattern")
+	sendCRC := sendCommand.Bool("crc", false, "end every packet with a CRC32 trailer the receiver verifies. Implies -header")
+	sendDryRun := sendCommand.Bool("dry-run", false, "print the streams and rates that would be sent, then exit without sending")
+	sendMaxPPS := sendCommand.Float64("max-pps", multicast.DefaultLimits.MaxPPS, "ceiling on packets per second across all groups, whatever the interval, rate or profile. '0' for no ceiling")
+	sendMaxBPS := sendCommand.Float64("max-bps", multicast.DefaultLimits.MaxBPS, "ceiling on bits per second of UDP payload across all groups. '0' for no ceiling")
+	sendMaxGroups := sendCommand.Uint64("max-groups", multicast.DefaultLimits.MaxGroups, "most groups sent to at once. '0' for no limit")
+	sendUnsafe := sendCommand.Bool("i-know-what-im-doing", false, "allow sending to 224.0.0.0/24, which routing protocols use, the IPv6 all-nodes groups and broadcast addresses")
+	sendProfile := sendCommand.String("profile", "", "traffic profile to send with instead of a fixed interval. Ex: burst:count=10,every=100ms, ramp:from=10,to=1000,over=30s, step:rates=100/500,every=5s, sine:mean=500,amplitude=400,period=10s, poisson:rate=1000")
 
 	// recieve subcommand
-	receiveGroup := receiveCommand.String("group", defaultSendRecvAddress, "multicast group address to listen on. Can use CIDR notation to listen on multiple addresses.")
-	receivePort := receiveCommand.Int("port", defaultSendRecvPort, "port to listen on")
+	receiveGroup := receiveCommand.String("group", defaultSendRecvAddress, "multicast group address to listen on. Can be a comma separated list of addresses, CIDR networks, ranges such as 239.1.1.10-90, @file of groups and !exclusions to listen on multiple addresses.")
+	receivePort := receiveCommand.String("port", fmt.Sprint(defaultSendRecvPort), "port to listen on. Can be a comma separated list of ports and ranges such as 5000-5010, listened on for every group")
+	receiveSkipEdges := receiveCommand.Bool("skip-edges", false, "leave out groups ending in .0 or .255")
+	receiveSample := receiveCommand.Int("sample", 0, "listen on this many groups picked at random from -group instead of all of them. '0' for all")
+	receiveSeed := receiveCommand.Int64("seed", 1, "seed for -sample. The same seed picks the same groups")
+	receiveShard := receiveCommand.String("shard", "", "listen on one part of -group, written as index/total such as 0/4")
 	receiveInterface := receiveCommand.String("interface", "", "interface name use. default allows system to decide")
 	receiveShowData := receiveCommand.Bool("show", true, "Print the data received to the console.")
 
@@ -208,6 +1004,7 @@ func processCommands() {
 	joinInterval := joinCommand.Int("interval", 10000, "interval between sending IGMP report 'join' (milliseconds). Can only be used if raw option is enabled.")
 	joinRouterAlert := joinCommand.Bool("router-alert", false, "set router alert flag in IP packet. Can only be used if raw option is enabled.")
 	joinIGMPVersion := joinCommand.Int("igmp-version", 2, "igmp version to use for join. Can only be used if raw option is enabled.")
+	joinMaxGroups := joinCommand.Uint64("max-groups", multicast.DefaultLimits.MaxGroups, "most groups joined at once. '0' for no limit")
 
 	// leave subcommand
 	leaveGroup := leaveCommand.String("group", defaultSendRecvAddress, "multicast group to send leave for")
@@ -216,6 +1013,44 @@ func processCommands() {
 	leaveInterval := leaveCommand.Int("interval", 5, "interval between sending IGMP leave (milliseconds)")
 	leaveMax := leaveCommand.Int("max", 1, "maximum IGMP leaves to send. 0 for infinite")
 
+	// ping subcommand
+	pingResponder := pingCommand.String("responder", "", "address of the host running 'mcast responder', with an optional port")
+	pingGroup := pingCommand.String("group", defaultSendRecvAddress, "multicast group the responder sends its multicast replies to")
+	pingInterface := pingCommand.String("interface", "", "interface name to join the group on. default allows system to decide")
+	pingCount := pingCommand.Int("count", 5, "number of requests to send. '0' to keep sending")
+	pingInterval := pingCommand.Int("interval", 1000, "interval between requests (milliseconds)")
+	pingTimeout := pingCommand.Int("timeout", 2000, "time to wait for replies after the last request (milliseconds)")
+
+	// responder subcommand
+	responderPort := responderCommand.Int("port", multicast.DefaultPingPort, "port to answer pings on")
+	responderTTL := responderCommand.Int("ttl", 64, "TTL to send replies with")
+	responderInterface := responderCommand.String("interface", "", "interface name to send multicast replies out of. default allows system to decide")
+
+	// trace subcommand
+	traceSource := traceCommand.String("source", "", "source of the multicast traffic to trace the path from")
+	traceGroup := traceCommand.String("group", defaultSendRecvAddress, "multicast group to trace")
+	traceRouter := traceCommand.String("router", multicast.DefaultTraceRouter, "last hop router to send the query to, with an optional port. 224.0.0.2 asks every router on the local network")
+	traceInterface := traceCommand.String("interface", "", "interface name to send the query out of. default allows system to decide")
+	traceClient := traceCommand.String("client", "", "address the reply is sent to. default is this host's address toward the router")
+	traceHops := traceCommand.Int("hops", 32, "most routers to trace back through")
+	traceTimeout := traceCommand.Int("timeout", 3000, "time to wait for the reply (milliseconds)")
+
+	// state subcommand
+	stateInterface := stateCommand.String("interface", "", "only show the interface with this name")
+	stateGroup := stateCommand.String("group", "", "only show whether this group is joined, exiting with status 1 if it isn't")
+
+	// interfaces subcommand
+	interfacesAll := interfacesCommand.Bool("all", false, "list every interface, not only those that can carry multicast")
+
+	// addr subcommand
+	addrGroup := addrCommand.String("group", "", "comma separated groups to map to MAC addresses, each an address, CIDR network, range such as 239.1.1.10-90, @file of groups or !exclusion")
+	addrFile := addrCommand.String("file", "", "file of groups to map, in the same form as -group, one or more to a line. '#' starts a comment")
+	addrCollisions := addrCommand.Bool("collisions", false, "only show the MAC addresses shared by more than one group")
+	addrGLOP := addrCommand.Int("glop", 0, "show the GLOP groups for this 16 bit AS number")
+
+	// run subcommand
+	runFormat := runCommand.String("format", "text", "format of the report: text or json")
+
 	// ensure at least 1 subcommand was specified
 	if len(os.Args) < 2 {
 		showHelpMessage()
@@ -225,19 +1060,53 @@ func processCommands() {
 	switch os.Args[1] {
 	case sendWord:
 		sendCommand.Parse(args)
-		processSendCommand(sendGroup, sendPort, sendInterfaceIP, sendText, sendTTL, sendTOS, sendPadding, sendInterval, sendStart, sendMax)
+		checkInterfaceFlag(*sendInterface)
+		processSendCommand(sendGroup, sendPort, sendSkipEdges, sendSample, sendSeed, sendShard, sendInterfaceIP, sendText, sendTTL, sendTOS, sendPadding, sendInterval, sendStart, sendMax, sendProfile, sendBatch, sendSockets, sendRate, sendGroupRate, sendStatsInterval, sendStatsFormat, sendPayloadFile, sendPayloadHex, sendPayloadStdin, sendPattern, sendHeader, sendCRC, sendSize, sendDF, sendInterface, sendLoopback, sendDSCP, sendECN, sendTTLSweep, sendSource, sendSourcePort, sendDryRun, sendMaxPPS, sendMaxBPS, sendMaxGroups, sendUnsafe)
 	case receiveWord:
 		receiveCommand.Parse(args)
-		processReceiveCommand(receiveGroup, receivePort, receiveInterface, receiveShowData)
+		checkInterfaceFlag(*receiveInterface)
+		processReceiveCommand(receiveGroup, receivePort, receiveSkipEdges, receiveSample, receiveSeed, receiveShard, receiveInterface, receiveShowData)
 	case queryWord:
 		queryCommand.Parse(args)
+		checkInterfaceFlag(*queryInterface)
 		processQueryCommand(queryInterface, queryInterfaceIP, queryInterval, queryMaxResponseTime, queryPlayNice)
 	case joinWord:
 		joinCommand.Parse(args)
-		processJoinCommand(joinGroup, joinPort, joinInterface, joinRaw, joinInterval, joinRouterAlert, joinIGMPVersion)
+		checkInterfaceFlag(*joinInterface)
+		processJoinCommand(joinGroup, joinPort, joinInterface, joinRaw, joinInterval, joinRouterAlert, joinIGMPVersion, joinMaxGroups)
 	case leaveWord:
 		leaveCommand.Parse(args)
+		checkInterfaceFlag(*leaveInterface)
 		processLeaveCommand(leaveGroup, leaveInterface, leaveInterfaceIP, leaveInterval, leaveMax)
+	case pingWord:
+		pingCommand.Parse(args)
+		checkInterfaceFlag(*pingInterface)
+		processPingCommand(pingResponder, pingGroup, pingInterface, pingCount, pingInterval, pingTimeout)
+	case responderWord:
+		responderCommand.Parse(args)
+		checkInterfaceFlag(*responderInterface)
+		processResponderCommand(responderPort, responderTTL, responderInterface)
+	case traceWord:
+		traceCommand.Parse(args)
+		checkInterfaceFlag(*traceInterface)
+		processTraceCommand(traceSource, traceGroup, traceRouter, traceInterface, traceClient, traceHops, traceTimeout)
+	case stateWord:
+		stateCommand.Parse(args)
+		processStateCommand(stateInterface, stateGroup)
+	case interfacesWord:
+		interfacesCommand.Parse(args)
+		processInterfacesCommand(interfacesAll)
+	case addrWord:
+		addrCommand.Parse(args)
+		processAddrCommand(addrGroup, addrFile, addrCollisions, addrGLOP)
+	case runWord:
+		runCommand.Parse(args)
+		runFile := runCommand.Arg(0)
+		if runCommand.NArg() > 1 {
+			// flags can follow the scenario file too
+			runCommand.Parse(runCommand.Args()[1:])
+		}
+		processRunCommand(runFile, runFormat)
 	case helpWord:
 		if len(args) == 1 {
 			switch args[0] {
@@ -256,6 +1125,28 @@ func processCommands() {
 			case leaveWord:
 				leaveCommand.PrintDefaults()
 				os.Exit(0)
+			case pingWord:
+				pingCommand.PrintDefaults()
+				os.Exit(0)
+			case responderWord:
+				responderCommand.PrintDefaults()
+				os.Exit(0)
+			case traceWord:
+				traceCommand.PrintDefaults()
+				os.Exit(0)
+			case stateWord:
+				stateCommand.PrintDefaults()
+				os.Exit(0)
+			case interfacesWord:
+				interfacesCommand.PrintDefaults()
+				os.Exit(0)
+			case addrWord:
+				addrCommand.PrintDefaults()
+				os.Exit(0)
+			case runWord:
+				fmt.Printf("Usage: mcast %v [flags] scenario.json|scenario.yaml\n", runWord)
+				runCommand.PrintDefaults()
+				os.Exit(0)
 			default:
 				fmt.Printf("Use subcommand '%s' followed by a valid subcommand\n", helpWord)
 				os.Exit(4)
@@ -2,4 +2,9 @@ module github.com/individuwill/mcast
 
 go 1.17
 
-require golang.org/x/net v0.0.0-20180320002117-6078986fec03
+require (
+	golang.org/x/net v0.0.0-20180320002117-6078986fec03
+	sigs.k8s.io/yaml v1.3.0
+)
+
+require gopkg.in/yaml.v2 v2.4.0 // indirect
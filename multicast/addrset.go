@@ -0,0 +1,327 @@
+/*
+*    mcast - Command line tool and library for testing multicast traffic
+*    flows and stress testing networks and devices.
+*    Copyright (C) 2018 Will Smith
+*
+*    This program is free software: you can redistribute it and/or modify
+*    it under the terms of the GNU General Public License as published by
+*    the Free Software Foundation, either version 3 of the License, or
+*    (at your option) any later version.
+*
+*    This program is distributed in the hope that it will be useful,
+*    but WITHOUT ANY WARRANTY; without even the implied warranty of
+*    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+*    GNU General Public License for more details.
+*
+*    You should have received a copy of the GNU General Public License
+*    along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package multicast
+
+import (
+	"fmt"
+	"math/rand"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// MaxListAddresses is the most addresses that are built into a list, by
+// IPList or ParseGroups. Larger sets of addresses have to be walked with an
+// AddressSet.
+const MaxListAddresses = 1 << 20
+
+// AddressSet is a set of addresses, such as a network in CIDR notation or
+// the result of ParseAddressSet, that is walked lazily rather than built
+// into a list, so it can be as large as the whole IPv4 address space. The
+// addresses are kept in the order given, as disjoint IPv4 ranges and single
+// IPv6 addresses, and are numbered from 0 to Count()-1.
+type AddressSet struct {
+	items     []groupItem
+	skipEdges bool
+}
+
+// addressSetOf returns a set of the addresses in the list, in its order.
+func addressSetOf(ips []net.IP) *AddressSet {
+	s := &AddressSet{items: make([]groupItem, len(ips))}
+	for i, ip := range ips {
+		if ip4 := ip.To4(); ip4 != nil {
+			s.items[i] = groupItem{from: IP4ToInt(ip4), to: IP4ToInt(ip4)}
+		} else {
+			s.items[i] = groupItem{ip: ip}
+		}
+	}
+	return s
+}
+
+// edgesBelow returns how many addresses below a don't end in .0 or .255.
+func edgesBelow(a uint64) uint64 {
+	low := a & 0xff
+	if low > 0 {
+		low--
+	}
+	return a>>8*254 + low
+}
+
+// nonEdge returns the address that is the k'th not ending in .0 or .255.
+func nonEdge(k uint64) uint32 {
+	return uint32(k/254<<8 | (k%254 + 1))
+}
+
+// itemCount returns the number of addresses in the item.
+func (s *AddressSet) itemCount(item groupItem) uint64 {
+	switch {
+	case item.ip != nil:
+		return 1
+	case s.skipEdges:
+		return edgesBelow(uint64(item.to)+1) - edgesBelow(uint64(item.from))
+	}
+	return uint64(item.to) - uint64(item.from) + 1
+}
+
+// itemAt returns the k'th address of the item.
+func (s *AddressSet) itemAt(item groupItem, k uint64) net.IP {
+	switch {
+	case item.ip != nil:
+		return item.ip
+	case s.skipEdges:
+		return IntToIP4(nonEdge(edgesBelow(uint64(item.from)) + k))
+	}
+	return IntToIP4(item.from + uint32(k))
+}
+
+// IsIPv4 reports whether every address in the set is an IPv4 address.
+func (s *AddressSet) IsIPv4() bool {
+	for _, item := range s.items {
+		if item.ip != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// Count returns the number of addresses in the set.
+func (s *AddressSet) Count() uint64 {
+	var count uint64
+	for _, item := range s.items {
+		count += s.itemCount(item)
+	}
+	return count
+}
+
+// At returns the address numbered i, or nil if i is past the end of the set.
+func (s *AddressSet) At(i uint64) net.IP {
+	for _, item := range s.items {
+		n := s.itemCount(item)
+		if i < n {
+			return s.itemAt(item, i)
+		}
+		i -= n
+	}
+	return nil
+}
+
+// SkipEdges returns the set without the IPv4 addresses ending in .0 or .255,
+// which some devices treat as network or broadcast addresses.
+func (s *AddressSet) SkipEdges() *AddressSet {
+	edges := &AddressSet{items: s.items, skipEdges: true}
+	if s.skipEdges {
+		return edges
+	}
+	// a single address that is an edge leaves nothing
+	items := make([]groupItem, 0, len(s.items))
+	for _, item := range s.items {
+		if edges.itemCount(item) > 0 {
+			items = append(items, item)
+		}
+	}
+	edges.items = items
+	return edges
+}
+
+// slice returns the addresses numbered from lo up to but not including hi.
+func (s *AddressSet) slice(lo, hi uint64) *AddressSet {
+	sliced := &AddressSet{skipEdges: s.skipEdges}
+	var start uint64
+	for _, item := range s.items {
+		n := s.itemCount(item)
+		from, to := lo, hi
+		if from < start {
+			from = start
+		}
+		if to > start+n {
+			to = start + n
+		}
+		if from < to {
+			if item.ip != nil {
+				sliced.items = append(sliced.items, item)
+			} else {
+				first := IP4ToInt(s.itemAt(item, from-start))
+				last := IP4ToInt(s.itemAt(item, to-start-1))
+				sliced.items = append(sliced.items, groupItem{from: first, to: last})
+			}
+		}
+		start += n
+	}
+	return sliced
+}
+
+// Shard splits the set into total parts of as near equal size as possible and
+// returns part index, counting from 0, so the work can be spread across
+// several hosts or processes.
+func (s *AddressSet) Shard(index, total int) (*AddressSet, error) {
+	if total < 1 || index < 0 || index >= total {
+		return nil, fmt.Errorf("shard %d of %d must be from 0 to %d", index, total, total-1)
+	}
+	count := s.Count()
+	// count*index/total, without overflowing
+	lo := count / uint64(total) * uint64(index)
+	lo += count % uint64(total) * uint64(index) / uint64(total)
+	hi := count / uint64(total) * uint64(index+1)
+	hi += count % uint64(total) * uint64(index+1) / uint64(total)
+	return s.slice(lo, hi), nil
+}
+
+// Sample returns n addresses chosen at random from the set, in the set's
+// order. The seed makes the choice repeatable. If n is at least the size of
+// the set the whole set is returned.
+func (s *AddressSet) Sample(n uint64, seed int64) (*AddressSet, error) {
+	count := s.Count()
+	if n >= count {
+		return s, nil
+	}
+	if n > MaxListAddresses {
+		return nil, fmt.Errorf("a sample of %d addresses is more than the limit of %d", n, MaxListAddresses)
+	}
+	// Floyd's algorithm picks n distinct indexes in n steps
+	random := rand.New(rand.NewSource(seed))
+	picked := make(map[uint64]bool, n)
+	for j := count - n; j < count; j++ {
+		i := uint64(random.Int63n(int64(j + 1)))
+		if picked[i] {
+			i = j
+		}
+		picked[i] = true
+	}
+	indexes := make([]uint64, 0, n)
+	for i := range picked {
+		indexes = append(indexes, i)
+	}
+	sort.Slice(indexes, func(a, b int) bool { return indexes[a] < indexes[b] })
+	ips := make([]net.IP, len(indexes))
+	it := s.Iter()
+	var at uint64
+	for k, i := range indexes {
+		it.skip(i - at)
+		ips[k], _ = it.Next()
+		at = i + 1
+	}
+	return addressSetOf(ips), nil
+}
+
+// List returns the addresses as a list, or an error if there are more than
+// max of them.
+func (s *AddressSet) List(max uint64) ([]net.IP, error) {
+	count := s.Count()
+	if count > max {
+		return nil, fmt.Errorf("%d addresses are more than the limit of %d", count, max)
+	}
+	ips := make([]net.IP, 0, count)
+	for it := s.Iter(); ; {
+		ip, ok := it.Next()
+		if !ok {
+			return ips, nil
+		}
+		ips = append(ips, ip)
+	}
+}
+
+// Iter returns an iterator over the set, starting at its first address.
+func (s *AddressSet) Iter() *AddressIterator {
+	return &AddressIterator{set: s}
+}
+
+// AddressIterator walks the addresses of an AddressSet in order.
+type AddressIterator struct {
+	set    *AddressSet
+	item   int
+	offset uint64
+}
+
+// Next returns the next address, or false once every address has been
+// returned.
+func (it *AddressIterator) Next() (net.IP, bool) {
+	for it.item < len(it.set.items) {
+		item := it.set.items[it.item]
+		if it.offset < it.set.itemCount(item) {
+			ip := it.set.itemAt(item, it.offset)
+			it.offset++
+			return ip, true
+		}
+		it.item++
+		it.offset = 0
+	}
+	return nil, false
+}
+
+// skip moves past the next n addresses.
+func (it *AddressIterator) skip(n uint64) {
+	for n > 0 && it.item < len(it.set.items) {
+		left := it.set.itemCount(it.set.items[it.item]) - it.offset
+		if n < left {
+			it.offset += n
+			return
+		}
+		n -= left
+		it.item++
+		it.offset = 0
+	}
+}
+
+// ParseShard parses a shard written as index/total, such as 0/4 for the first
+// of four parts.
+func ParseShard(spec string) (int, int, error) {
+	parts := strings.Split(spec, "/")
+	if len(parts) != 2 {
+		return 0, 0, fmt.Errorf("shard %q must be written as index/total, such as 0/4", spec)
+	}
+	index, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return 0, 0, fmt.Errorf("shard %q has a bad index: %v", spec, err)
+	}
+	total, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return 0, 0, fmt.Errorf("shard %q has a bad total: %v", spec, err)
+	}
+	if total < 1 || index < 0 || index >= total {
+		return 0, 0, fmt.Errorf("shard %d of %d must be from 0 to %d", index, total, total-1)
+	}
+	return index, total, nil
+}
+
+// firstIn returns the first address of the set from lo to hi, an IPv4 range
+// within one /24, or false if the set has none of them.
+func (s *AddressSet) firstIn(lo, hi uint32) (net.IP, bool) {
+	for _, item := range s.items {
+		if item.ip != nil || item.to < lo || item.from > hi {
+			continue
+		}
+		from, to := item.from, item.to
+		if from < lo {
+			from = lo
+		}
+		if to > hi {
+			to = hi
+		}
+		// with the edges skipped, the first address may be a .0 or .255
+		// followed by one that isn't
+		for n := uint64(from); n <= uint64(to) && n <= uint64(from)+1; n++ {
+			if !s.skipEdges || (n&0xff != 0 && n&0xff != 0xff) {
+				return IntToIP4(uint32(n)), true
+			}
+		}
+	}
+	return nil, false
+}
@@ -0,0 +1,159 @@
+/*
+*    mcast - Command line tool and library for testing multicast traffic
+*    flows and stress testing networks and devices.
+*    Copyright (C) 2018 Will Smith
+*
+*    This program is free software: you can redistribute it and/or modify
+*    it under the terms of the GNU General Public License as published by
+*    the Free Software Foundation, either version 3 of the License, or
+*    (at your option) any later version.
+*
+*    This program is distributed in the hope that it will be useful,
+*    but WITHOUT ANY WARRANTY; without even the implied warranty of
+*    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+*    GNU General Public License for more details.
+*
+*    You should have received a copy of the GNU General Public License
+*    along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package multicast
+
+import (
+	"testing"
+)
+
+func TestAddressSetCount(t *testing.T) {
+	set, err := ParseAddressSet("0.0.0.0/0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if set.Count() != 1<<32 {
+		t.Errorf("Expected %d addresses, instead got %d", uint64(1)<<32, set.Count())
+	}
+	if ip := set.At(1<<32 - 1).String(); ip != "255.255.255.255" {
+		t.Errorf("Expected last address 255.255.255.255, instead got %v", ip)
+	}
+	if _, err := set.List(MaxListAddresses); err == nil {
+		t.Error("Expected listing 0.0.0.0/0 to be refused")
+	}
+}
+
+func TestAddressSetAt(t *testing.T) {
+	set, err := ParseAddressSet("239.1.1.10-12,239.2.0.0/30,!239.2.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"239.1.1.10", "239.1.1.11", "239.1.1.12", "239.2.0.0", "239.2.0.2", "239.2.0.3"}
+	if set.Count() != uint64(len(expected)) {
+		t.Fatalf("Expected %d addresses, instead got %d", len(expected), set.Count())
+	}
+	it := set.Iter()
+	for i, want := range expected {
+		if got := set.At(uint64(i)).String(); got != want {
+			t.Errorf("Expected address %d to be %v, instead got %v", i, want, got)
+		}
+		if got, _ := it.Next(); got.String() != want {
+			t.Errorf("Expected iterator address %d to be %v, instead got %v", i, want, got)
+		}
+	}
+	if _, ok := it.Next(); ok {
+		t.Error("Expected the iterator to be done")
+	}
+}
+
+func TestAddressSetSkipEdges(t *testing.T) {
+	set, err := ParseAddressSet("239.0.0.0/8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	skipped := set.SkipEdges()
+	if skipped.Count() != 1<<24-2*(1<<16) {
+		t.Errorf("Expected %d addresses, instead got %d", 1<<24-2*(1<<16), skipped.Count())
+	}
+	if ip := skipped.At(254).String(); ip != "239.0.1.1" {
+		t.Errorf("Expected 239.0.1.1, instead got %v", ip)
+	}
+}
+
+func TestAddressSetShard(t *testing.T) {
+	set, err := ParseAddressSet("239.1.1.0/24,!239.1.1.100-109")
+	if err != nil {
+		t.Fatal(err)
+	}
+	seen := map[string]bool{}
+	var total uint64
+	for i := 0; i < 3; i++ {
+		shard, err := set.Shard(i, 3)
+		if err != nil {
+			t.Fatal(err)
+		}
+		total += shard.Count()
+		for it := shard.Iter(); ; {
+			ip, ok := it.Next()
+			if !ok {
+				break
+			}
+			if seen[ip.String()] {
+				t.Errorf("Expected %v in only one shard", ip)
+			}
+			seen[ip.String()] = true
+		}
+	}
+	if total != set.Count() || len(seen) != int(set.Count()) {
+		t.Errorf("Expected shards to cover %d addresses, instead got %d", set.Count(), len(seen))
+	}
+	if _, err := set.Shard(3, 3); err == nil {
+		t.Error("Expected shard 3 of 3 to be refused")
+	}
+}
+
+func TestAddressSetSample(t *testing.T) {
+	set, err := ParseAddressSet("239.0.0.0/8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, err := set.Sample(100, 7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := set.Sample(100, 7)
+	if a.Count() != 100 {
+		t.Errorf("Expected 100 addresses, instead got %d", a.Count())
+	}
+	for i := uint64(0); i < a.Count(); i++ {
+		if !a.At(i).Equal(b.At(i)) {
+			t.Errorf("Expected the same seed to pick the same addresses, instead got %v and %v", a.At(i), b.At(i))
+		}
+		if i > 0 && IP4ToInt(a.At(i)) <= IP4ToInt(a.At(i-1)) {
+			t.Errorf("Expected the sample in order, instead got %v after %v", a.At(i), a.At(i-1))
+		}
+	}
+}
+
+func TestParseShard(t *testing.T) {
+	index, total, err := ParseShard("2/5")
+	if err != nil || index != 2 || total != 5 {
+		t.Errorf("Expected shard 2 of 5, instead got %d of %d (%v)", index, total, err)
+	}
+	for _, spec := range []string{"5/5", "1", "a/2", "0/0"} {
+		if _, _, err := ParseShard(spec); err == nil {
+			t.Errorf("Expected %q to be refused", spec)
+		}
+	}
+}
+
+func TestAddressSetIsIPv4(t *testing.T) {
+	for spec, expected := range map[string]bool{
+		"10.0.0.0/8,10.1.1.1-3": true,
+		"239.1.1.1,ff3e::1":     false,
+	} {
+		set, err := ParseAddressSet(spec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if set.IsIPv4() != expected {
+			t.Errorf("Expected IsIPv4 %v for %v, instead got %v", expected, spec, !expected)
+		}
+	}
+}
@@ -0,0 +1,188 @@
+/*
+*    mcast - Command line tool and library for testing multicast traffic
+*    flows and stress testing networks and devices.
+*    Copyright (C) 2018 Will Smith
+*
+*    This program is free software: you can redistribute it and/or modify
+*    it under the terms of the GNU General Public License as published by
+*    the Free Software Foundation, either version 3 of the License, or
+*    (at your option) any later version.
+*
+*    This program is distributed in the hope that it will be useful,
+*    but WITHOUT ANY WARRANTY; without even the implied warranty of
+*    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+*    GNU General Public License for more details.
+*
+*    You should have received a copy of the GNU General Public License
+*    along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package multicast
+
+import (
+	"fmt"
+	"net"
+)
+
+// AddressClass is the kind of address an IP address is, by the IANA IPv4
+// multicast address registry (RFC 5771).
+type AddressClass int
+
+const (
+	ClassUnicast           AddressClass = iota
+	ClassBroadcast                      // 255.255.255.255
+	ClassLinkLocal                      // 224.0.0.0/24, local network control
+	ClassInternetwork                   // 224.0.1.0/24, internetwork control
+	ClassAdHoc                          // AD-HOC blocks I, II and III
+	ClassSDP                            // 224.2.0.0/16, SDP/SAP
+	ClassSSM                            // 232.0.0.0/8, source specific multicast
+	ClassGLOP                           // 233.0.0.0 to 233.251.255.255
+	ClassUnicastPrefix                  // 234.0.0.0/8, unicast prefix based
+	ClassAdminScoped                    // 239.0.0.0/8
+	ClassOrganizationLocal              // 239.192.0.0/14
+	ClassLocalScope                     // 239.255.0.0/16
+	ClassReserved                       // multicast blocks IANA reserves
+	ClassIPv6Multicast                  // ff00::/8
+)
+
+var addressClassNames = map[AddressClass]string{
+	ClassUnicast:           "unicast",
+	ClassBroadcast:         "broadcast",
+	ClassLinkLocal:         "link-local control",
+	ClassInternetwork:      "internetwork control",
+	ClassAdHoc:             "ad-hoc",
+	ClassSDP:               "SDP/SAP",
+	ClassSSM:               "source specific multicast",
+	ClassGLOP:              "GLOP",
+	ClassUnicastPrefix:     "unicast prefix based",
+	ClassAdminScoped:       "administratively scoped",
+	ClassOrganizationLocal: "organization local scope",
+	ClassLocalScope:        "local scope",
+	ClassReserved:          "reserved",
+	ClassIPv6Multicast:     "IPv6 multicast",
+}
+
+func (c AddressClass) String() string {
+	if name, ok := addressClassNames[c]; ok {
+		return name
+	}
+	return fmt.Sprintf("class(%d)", int(c))
+}
+
+// AddressInfo is the classification of an address.
+type AddressInfo struct {
+	Address net.IP
+	Class   AddressClass
+	AS      int    // the AS number a GLOP group belongs to
+	Scope   string // the scope of an IPv6 group
+}
+
+func (a AddressInfo) String() string {
+	switch {
+	case a.Class == ClassGLOP:
+		return fmt.Sprintf("%v, AS %d", a.Class, a.AS)
+	case a.Scope != "":
+		return fmt.Sprintf("%v, %v scope", a.Class, a.Scope)
+	}
+	return a.Class.String()
+}
+
+// ipv6Scopes names the scope field of IPv6 multicast addresses (RFC 7346).
+var ipv6Scopes = map[byte]string{
+	0x1: "interface-local",
+	0x2: "link-local",
+	0x3: "realm-local",
+	0x4: "admin-local",
+	0x5: "site-local",
+	0x8: "organization-local",
+	0xe: "global",
+}
+
+// ClassifyAddress returns the kind of address ip is.
+func ClassifyAddress(ip net.IP) AddressInfo {
+	info := AddressInfo{Address: ip, Class: ClassUnicast}
+	ip4 := ip.To4()
+	if ip4 == nil {
+		if ip.IsMulticast() {
+			info.Class = ClassIPv6Multicast
+			info.Scope = ipv6Scopes[ip[1]&0x0f]
+			if info.Scope == "" {
+				info.Scope = fmt.Sprintf("reserved (%x)", ip[1]&0x0f)
+			}
+		}
+		return info
+	}
+	if ip4.Equal(net.IPv4bcast) {
+		info.Class = ClassBroadcast
+		return info
+	}
+	if !ip4.IsMulticast() {
+		return info
+	}
+	switch a, b, c := ip4[0], ip4[1], ip4[2]; {
+	case a == 224 && b == 0 && c == 0:
+		info.Class = ClassLinkLocal
+	case a == 224 && b == 0 && c == 1:
+		info.Class = ClassInternetwork
+	case a == 224 && b == 0, a == 224 && (b == 3 || b == 4), a == 233 && b >= 252:
+		info.Class = ClassAdHoc
+	case a == 224 && b == 2:
+		info.Class = ClassSDP
+	case a == 232:
+		info.Class = ClassSSM
+	case a == 233:
+		info.Class = ClassGLOP
+		info.AS = int(b)<<8 | int(c)
+	case a == 234:
+		info.Class = ClassUnicastPrefix
+	case a == 239 && b == 255:
+		info.Class = ClassLocalScope
+	case a == 239 && b >= 192 && b <= 195:
+		info.Class = ClassOrganizationLocal
+	case a == 239:
+		info.Class = ClassAdminScoped
+	default:
+		info.Class = ClassReserved
+	}
+	return info
+}
+
+// GLOPNetwork returns the /24 of GLOP groups (RFC 3180) for a 16 bit AS
+// number.
+func GLOPNetwork(as int) (*net.IPNet, error) {
+	if as < 1 || as > 65535 {
+		return nil, fmt.Errorf("AS number %d must be from 1 to 65535 to have GLOP addresses", as)
+	}
+	return &net.IPNet{IP: net.IPv4(233, byte(as>>8), byte(as), 0).To4(), Mask: net.CIDRMask(24, 32)}, nil
+}
+
+// GroupWarnings returns the problems with using group, sending with the TTL,
+// or receiving if the TTL is negative.
+func GroupWarnings(group net.IP, ttl int) []string {
+	info := ClassifyAddress(group)
+	var warnings []string
+	switch info.Class {
+	case ClassUnicast, ClassBroadcast:
+		return []string{fmt.Sprintf("%v is not a multicast group", group)}
+	case ClassLinkLocal:
+		warnings = append(warnings, fmt.Sprintf("%v is reserved for network control protocols on the local network, and routers never forward it", group))
+	case ClassInternetwork:
+		warnings = append(warnings, fmt.Sprintf("%v is reserved for internetwork control protocols", group))
+	case ClassReserved:
+		warnings = append(warnings, fmt.Sprintf("%v is in a block IANA reserves", group))
+	case ClassSSM:
+		if ttl < 0 {
+			warnings = append(warnings, fmt.Sprintf("%v is a source specific group, which routers only forward to receivers joining with a source", group))
+		}
+	}
+	if ttl < 0 || info.Class == ClassLinkLocal || info.Scope == "interface-local" || info.Scope == "link-local" {
+		return warnings
+	}
+	switch ttl {
+	case 0:
+		warnings = append(warnings, fmt.Sprintf("TTL 0 keeps traffic to %v on this host", group))
+	case 1:
+		warnings = append(warnings, fmt.Sprintf("TTL 1 keeps traffic to %v on the local network, so routers won't forward it", group))
+	}
+	return warnings
+}
@@ -0,0 +1,92 @@
+/*
+*    mcast - Command line tool and library for testing multicast traffic
+*    flows and stress testing networks and devices.
+*    Copyright (C) 2018 Will Smith
+*
+*    This program is free software: you can redistribute it and/or modify
+*    it under the terms of the GNU General Public License as published by
+*    the Free Software Foundation, either version 3 of the License, or
+*    (at your option) any later version.
+*
+*    This program is distributed in the hope that it will be useful,
+*    but WITHOUT ANY WARRANTY; without even the implied warranty of
+*    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+*    GNU General Public License for more details.
+*
+*    You should have received a copy of the GNU General Public License
+*    along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package multicast
+
+import (
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestClassifyAddress(t *testing.T) {
+	tests := map[string]string{
+		"192.0.2.1":       "unicast",
+		"255.255.255.255": "broadcast",
+		"224.0.0.5":       "link-local control",
+		"224.0.1.1":       "internetwork control",
+		"224.0.2.1":       "ad-hoc",
+		"224.2.127.254":   "SDP/SAP",
+		"225.1.1.1":       "reserved",
+		"232.1.1.1":       "source specific multicast",
+		"233.252.0.1":     "ad-hoc",
+		"233.3.223.1":     "GLOP, AS 991",
+		"234.192.0.2":     "unicast prefix based",
+		"239.1.1.50":      "administratively scoped",
+		"239.193.0.1":     "organization local scope",
+		"239.255.255.250": "local scope",
+		"ff02::1":         "IPv6 multicast, link-local scope",
+		"ff3e::1234":      "IPv6 multicast, global scope",
+	}
+	for address, want := range tests {
+		if got := ClassifyAddress(net.ParseIP(address)).String(); got != want {
+			t.Errorf("Expected %v for %v, instead got %v", want, address, got)
+		}
+	}
+}
+
+func TestGLOPNetwork(t *testing.T) {
+	network, err := GLOPNetwork(5662)
+	if err != nil {
+		t.Fatal("Received error for AS 5662", err)
+	}
+	if network.String() != "233.22.30.0/24" {
+		t.Errorf("Expected 233.22.30.0/24, instead got %v", network)
+	}
+	if as := ClassifyAddress(net.ParseIP("233.22.30.7")).AS; as != 5662 {
+		t.Errorf("Expected AS 5662, instead got %d", as)
+	}
+	if _, err := GLOPNetwork(70000); err == nil {
+		t.Error("Expected error for a 32 bit AS number")
+	}
+}
+
+func TestGroupWarnings(t *testing.T) {
+	tests := []struct {
+		group string
+		ttl   int
+		want  string
+	}{
+		{"239.1.1.50", 50, ""},
+		{"239.1.1.50", 1, "TTL 1"},
+		{"239.1.1.50", 0, "TTL 0"},
+		{"224.0.0.5", 1, "reserved for network control"},
+		{"225.1.1.1", 50, "IANA reserves"},
+		{"232.1.1.1", -1, "source specific"},
+		{"232.1.1.1", 50, ""},
+		{"ff02::1", 1, ""},
+		{"192.0.2.1", 50, "not a multicast group"},
+	}
+	for _, test := range tests {
+		warnings := strings.Join(GroupWarnings(net.ParseIP(test.group), test.ttl), "\n")
+		if (test.want == "") != (warnings == "") || !strings.Contains(warnings, test.want) {
+			t.Errorf("Expected a warning containing %q for %v ttl %d, instead got %q", test.want, test.group, test.ttl, warnings)
+		}
+	}
+}
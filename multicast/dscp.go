@@ -0,0 +1,102 @@
+/*
+*    mcast - Command line tool and library for testing multicast traffic
+*    flows and stress testing networks and devices.
+*    Copyright (C) 2018 Will Smith
+*
+*    This program is free software: you can redistribute it and/or modify
+*    it under the terms of the GNU General Public License as published by
+*    the Free Software Foundation, either version 3 of the License, or
+*    (at your option) any later version.
+*
+*    This program is distributed in the hope that it will be useful,
+*    but WITHOUT ANY WARRANTY; without even the implied warranty of
+*    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+*    GNU General Public License for more details.
+*
+*    You should have received a copy of the GNU General Public License
+*    along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package multicast
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// DSCP code points by name, as used by the DiffServ RFCs.
+var dscpNames = map[string]int{
+	"CS0": 0, "DF": 0, "BE": 0,
+	"LE":  1,
+	"CS1": 8, "AF11": 10, "AF12": 12, "AF13": 14,
+	"CS2": 16, "AF21": 18, "AF22": 20, "AF23": 22,
+	"CS3": 24, "AF31": 26, "AF32": 28, "AF33": 30,
+	"CS4": 32, "AF41": 34, "AF42": 36, "AF43": 38,
+	"CS5": 40, "VA": 44, "EF": 46,
+	"CS6": 48, "CS7": 56,
+}
+
+// ECN code points, the low two bits of the TOS byte.
+const (
+	ECNNotECT = 0
+	ECNECT1   = 1
+	ECNECT0   = 2
+	ECNCE     = 3
+)
+
+var ecnNames = map[int]string{
+	ECNNotECT: "not-ect",
+	ECNECT1:   "ect1",
+	ECNECT0:   "ect0",
+	ECNCE:     "ce",
+}
+
+// ParseDSCP parses a DSCP given by name, such as EF, AF41 or CS5, or as a
+// number from 0 to 63.
+func ParseDSCP(name string) (int, error) {
+	if dscp, ok := dscpNames[strings.ToUpper(name)]; ok {
+		return dscp, nil
+	}
+	dscp, err := strconv.ParseInt(name, 0, 0)
+	if err != nil || dscp < 0 || dscp > 63 {
+		return 0, fmt.Errorf("unknown DSCP %q, use a name such as EF, AF41 or CS5, or a number from 0 to 63", name)
+	}
+	return int(dscp), nil
+}
+
+// ParseECN parses an ECN code point: not-ect, ect0, ect1 or ce.
+func ParseECN(name string) (int, error) {
+	for ecn, n := range ecnNames {
+		if strings.EqualFold(n, name) {
+			return ecn, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown ECN %q, use not-ect, ect0, ect1 or ce", name)
+}
+
+// TOSByte builds the TOS byte from a DSCP and an ECN code point.
+func TOSByte(dscp int, ecn int) int {
+	return dscp<<2 | ecn&3
+}
+
+// DSCPName returns the name of a DSCP, or its number if it has no name.
+func DSCPName(dscp int) string {
+	best := ""
+	for name, d := range dscpNames {
+		// prefer the class selector name, CS0, for the aliases of 0
+		if d == dscp && (best == "" || strings.HasPrefix(name, "CS")) {
+			best = name
+		}
+	}
+	if best == "" {
+		return strconv.Itoa(dscp)
+	}
+	return best
+}
+
+// FormatTOS describes a TOS byte as its DSCP and ECN, for example
+// "0xb8 (EF, not-ect)".
+func FormatTOS(tos int) string {
+	return fmt.Sprintf("0x%02x (%v, %v)", tos, DSCPName(tos>>2), ecnNames[tos&3])
+}
@@ -0,0 +1,54 @@
+/*
+*    mcast - Command line tool and library for testing multicast traffic
+*    flows and stress testing networks and devices.
+*    Copyright (C) 2018 Will Smith
+*
+*    This program is free software: you can redistribute it and/or modify
+*    it under the terms of the GNU General Public License as published by
+*    the Free Software Foundation, either version 3 of the License, or
+*    (at your option) any later version.
+*
+*    This program is distributed in the hope that it will be useful,
+*    but WITHOUT ANY WARRANTY; without even the implied warranty of
+*    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+*    GNU General Public License for more details.
+*
+*    You should have received a copy of the GNU General Public License
+*    along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package multicast
+
+import (
+	"testing"
+)
+
+func TestParseDSCP(t *testing.T) {
+	for name, expected := range map[string]int{"EF": 46, "af41": 34, "CS5": 40, "BE": 0, "10": 10, "0x2e": 46} {
+		dscp, err := ParseDSCP(name)
+		if err != nil || dscp != expected {
+			t.Errorf("Expected DSCP %d for %v, instead got %d (%v)", expected, name, dscp, err)
+		}
+	}
+	for _, name := range []string{"AF51", "64", "-1"} {
+		if _, err := ParseDSCP(name); err == nil {
+			t.Errorf("Expected error for DSCP %v", name)
+		}
+	}
+}
+
+func TestTOSByte(t *testing.T) {
+	ecn, err := ParseECN("ECT0")
+	if err != nil {
+		t.Fatal("Received error for ECN ECT0", err)
+	}
+	if tos := TOSByte(46, ecn); tos != 0xba {
+		t.Errorf("Expected TOS 0xba, instead got %#x", tos)
+	}
+	if s := FormatTOS(0xb8); s != "0xb8 (EF, not-ect)" {
+		t.Errorf("Unexpected description %q", s)
+	}
+	if s := FormatTOS(0x03); s != "0x03 (CS0, ce)" {
+		t.Errorf("Unexpected description %q", s)
+	}
+}
@@ -0,0 +1,62 @@
+/*
+*    mcast - Command line tool and library for testing multicast traffic
+*    flows and stress testing networks and devices.
+*    Copyright (C) 2018 Will Smith
+*
+*    This program is free software: you can redistribute it and/or modify
+*    it under the terms of the GNU General Public License as published by
+*    the Free Software Foundation, either version 3 of the License, or
+*    (at your option) any later version.
+*
+*    This program is distributed in the hope that it will be useful,
+*    but WITHOUT ANY WARRANTY; without even the implied warranty of
+*    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+*    GNU General Public License for more details.
+*
+*    You should have received a copy of the GNU General Public License
+*    along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package multicast
+
+import (
+	"fmt"
+)
+
+// Fragmentation controls the don't fragment (DF) bit on sent datagrams.
+type Fragmentation int
+
+const (
+	// FragmentDefault leaves path MTU discovery to the kernel's default.
+	FragmentDefault Fragmentation = iota
+	// FragmentDontFragment sets DF on every datagram. Datagrams larger than
+	// the MTU fail to send rather than being fragmented.
+	FragmentDontFragment
+	// FragmentAllow clears DF, so datagrams larger than the MTU are sent as
+	// IP fragments.
+	FragmentAllow
+)
+
+var fragmentationNames = map[Fragmentation]string{
+	FragmentDefault:      "default",
+	FragmentDontFragment: "set",
+	FragmentAllow:        "clear",
+}
+
+func (f Fragmentation) String() string {
+	if name, ok := fragmentationNames[f]; ok {
+		return name
+	}
+	return fmt.Sprintf("fragmentation(%d)", int(f))
+}
+
+// ParseFragmentation parses how the DF bit is handled: default, set or
+// clear.
+func ParseFragmentation(name string) (Fragmentation, error) {
+	for f, n := range fragmentationNames {
+		if n == name {
+			return f, nil
+		}
+	}
+	return FragmentDefault, fmt.Errorf("DF must be default, set or clear, not %q", name)
+}
@@ -0,0 +1,51 @@
+/*
+*    mcast - Command line tool and library for testing multicast traffic
+*    flows and stress testing networks and devices.
+*    Copyright (C) 2018 Will Smith
+*
+*    This program is free software: you can redistribute it and/or modify
+*    it under the terms of the GNU General Public License as published by
+*    the Free Software Foundation, either version 3 of the License, or
+*    (at your option) any later version.
+*
+*    This program is distributed in the hope that it will be useful,
+*    but WITHOUT ANY WARRANTY; without even the implied warranty of
+*    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+*    GNU General Public License for more details.
+*
+*    You should have received a copy of the GNU General Public License
+*    along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package multicast
+
+import (
+	"net"
+	"syscall"
+)
+
+// setFragmentation sets IP_MTU_DISCOVER on the socket to set or clear DF on
+// the datagrams it sends.
+func setFragmentation(conn *net.UDPConn, f Fragmentation) error {
+	var value int
+	switch f {
+	case FragmentDefault:
+		return nil
+	case FragmentDontFragment:
+		value = syscall.IP_PMTUDISC_DO
+	case FragmentAllow:
+		value = syscall.IP_PMTUDISC_DONT
+	}
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var serr error
+	err = raw.Control(func(fd uintptr) {
+		serr = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IP, syscall.IP_MTU_DISCOVER, value)
+	})
+	if err != nil {
+		return err
+	}
+	return serr
+}
@@ -0,0 +1,47 @@
+/*
+*    mcast - Command line tool and library for testing multicast traffic
+*    flows and stress testing networks and devices.
+*    Copyright (C) 2018 Will Smith
+*
+*    This program is free software: you can redistribute it and/or modify
+*    it under the terms of the GNU General Public License as published by
+*    the Free Software Foundation, either version 3 of the License, or
+*    (at your option) any later version.
+*
+*    This program is distributed in the hope that it will be useful,
+*    but WITHOUT ANY WARRANTY; without even the implied warranty of
+*    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+*    GNU General Public License for more details.
+*
+*    You should have received a copy of the GNU General Public License
+*    along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package multicast
+
+import (
+	"net"
+	"syscall"
+	"testing"
+)
+
+func TestSetFragmentation(t *testing.T) {
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	for f, expected := range map[Fragmentation]int{FragmentDontFragment: syscall.IP_PMTUDISC_DO, FragmentAllow: syscall.IP_PMTUDISC_DONT} {
+		if err := setFragmentation(conn, f); err != nil {
+			t.Fatal("Received error setting DF", err)
+		}
+		raw, _ := conn.SyscallConn()
+		var value int
+		raw.Control(func(fd uintptr) {
+			value, err = syscall.GetsockoptInt(int(fd), syscall.IPPROTO_IP, syscall.IP_MTU_DISCOVER)
+		})
+		if err != nil || value != expected {
+			t.Errorf("Expected IP_MTU_DISCOVER %d for %v, instead got %d (%v)", expected, f, value, err)
+		}
+	}
+}
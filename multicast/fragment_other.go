@@ -0,0 +1,37 @@
+//go:build !linux
+// +build !linux
+
+/*
+*    mcast - Command line tool and library for testing multicast traffic
+*    flows and stress testing networks and devices.
+*    Copyright (C) 2018 Will Smith
+*
+*    This program is free software: you can redistribute it and/or modify
+*    it under the terms of the GNU General Public License as published by
+*    the Free Software Foundation, either version 3 of the License, or
+*    (at your option) any later version.
+*
+*    This program is distributed in the hope that it will be useful,
+*    but WITHOUT ANY WARRANTY; without even the implied warranty of
+*    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+*    GNU General Public License for more details.
+*
+*    You should have received a copy of the GNU General Public License
+*    along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package multicast
+
+import (
+	"errors"
+	"net"
+)
+
+// setFragmentation is only supported on Linux, where IP_MTU_DISCOVER
+// controls the DF bit.
+func setFragmentation(conn *net.UDPConn, f Fragmentation) error {
+	if f == FragmentDefault {
+		return nil
+	}
+	return errors.New("Setting or clearing DF is only supported on Linux")
+}
@@ -0,0 +1,315 @@
+/*
+*    mcast - Command line tool and library for testing multicast traffic
+*    flows and stress testing networks and devices.
+*    Copyright (C) 2018 Will Smith
+*
+*    This program is free software: you can redistribute it and/or modify
+*    it under the terms of the GNU General Public License as published by
+*    the Free Software Foundation, either version 3 of the License, or
+*    (at your option) any later version.
+*
+*    This program is distributed in the hope that it will be useful,
+*    but WITHOUT ANY WARRANTY; without even the implied warranty of
+*    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+*    GNU General Public License for more details.
+*
+*    You should have received a copy of the GNU General Public License
+*    along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package multicast
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// maxGroupFileDepth limits how deeply group files can name other files.
+const maxGroupFileDepth = 8
+
+// groupItem is an IPv4 range of addresses, or a single IPv6 address.
+type groupItem struct {
+	from, to uint32
+	ip       net.IP // the IPv6 address, when set
+}
+
+// groupSpec collects the addresses included and excluded by a group
+// specification.
+type groupSpec struct {
+	include []groupItem
+	exclude []groupItem
+}
+
+// groupSet returns the set of groups spec names in any of the forms
+// ParseAddressSet takes, or nil if it is a single address or a host name,
+// which is left to the caller to resolve. Host names can have a dash in
+// them, so only a dash after an address makes a range.
+func groupSet(spec string) (*AddressSet, error) {
+	if strings.ContainsAny(spec, "/,@!") {
+		return ParseAddressSet(spec)
+	}
+	if i := strings.Index(spec, "-"); i > 0 && net.ParseIP(spec[:i]) != nil {
+		return ParseAddressSet(spec)
+	}
+	return nil, nil
+}
+
+// ParseAddressSet parses a comma separated list of groups into a set that
+// is walked lazily, so it can be of any size. Each item is one of:
+//
+//	239.1.1.1                single group, IPv4 or IPv6
+//	239.1.1.0/24             every address in an IPv4 network
+//	239.1.1.10-239.1.1.90    every address in an IPv4 range
+//	239.1.1.10-90            the same, with only the last byte of the end
+//	@groups.txt              the groups in a file, in the form ReadGroups takes
+//	!239.1.1.64/28           leaves out the addresses, in any of the forms above
+//
+// The set holds the groups in the order given without repeats. Exclusions
+// apply to the whole list, wherever they appear in it.
+func ParseAddressSet(spec string) (*AddressSet, error) {
+	g := &groupSpec{}
+	if err := g.parse(spec, 0); err != nil {
+		return nil, err
+	}
+	return g.set(), nil
+}
+
+// ParseGroups parses a list of groups in the form ParseAddressSet takes, into
+// a list of at most MaxListAddresses.
+func ParseGroups(spec string) ([]net.IP, error) {
+	s, err := ParseAddressSet(spec)
+	if err != nil {
+		return nil, err
+	}
+	return s.List(MaxListAddresses)
+}
+
+// ReadGroups reads groups in the form ParseAddressSet takes, one or more to
+// a line, into a list of at most MaxListAddresses. Blank lines and anything
+// after a # are ignored.
+func ReadGroups(r io.Reader) ([]net.IP, error) {
+	g := &groupSpec{}
+	if err := g.read(r, 0); err != nil {
+		return nil, err
+	}
+	return g.set().List(MaxListAddresses)
+}
+
+func (g *groupSpec) parse(spec string, depth int) error {
+	for _, item := range strings.Split(spec, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		exclude := strings.HasPrefix(item, "!")
+		item = strings.TrimSpace(strings.TrimPrefix(item, "!"))
+		if strings.HasPrefix(item, "@") {
+			if exclude {
+				return fmt.Errorf("%q: a file of groups can't be excluded", item)
+			}
+			if err := g.readFile(item[1:], depth+1); err != nil {
+				return err
+			}
+			continue
+		}
+		parsed, err := parseGroupItem(item)
+		if err != nil {
+			return err
+		}
+		if exclude {
+			g.exclude = append(g.exclude, parsed)
+		} else {
+			g.include = append(g.include, parsed)
+		}
+	}
+	return nil
+}
+
+func (g *groupSpec) read(r io.Reader, depth int) error {
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := scanner.Text()
+		if i := strings.Index(text, "#"); i >= 0 {
+			text = text[:i]
+		}
+		if err := g.parse(text, depth); err != nil {
+			return fmt.Errorf("line %d: %v", line, err)
+		}
+	}
+	return scanner.Err()
+}
+
+func (g *groupSpec) readFile(name string, depth int) error {
+	if depth > maxGroupFileDepth {
+		return fmt.Errorf("%v: group files nested more than %d deep", name, maxGroupFileDepth)
+	}
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := g.read(f, depth); err != nil {
+		return fmt.Errorf("%v: %v", name, err)
+	}
+	return nil
+}
+
+// parseGroupItem parses a single address, network or range.
+func parseGroupItem(item string) (groupItem, error) {
+	if strings.Contains(item, "/") {
+		if strings.Contains(item, ":") {
+			return groupItem{}, fmt.Errorf("%q: only IPv4 networks can be given in CIDR notation", item)
+		}
+		_, network, err := net.ParseCIDR(item)
+		if err != nil {
+			return groupItem{}, err
+		}
+		from := IP4ToInt(network.IP)
+		ones, _ := network.Mask.Size()
+		return groupItem{from: from, to: from | uint32(uint64(1)<<uint(32-ones)-1)}, nil
+	}
+	if i := strings.Index(item, "-"); i >= 0 {
+		from := net.ParseIP(strings.TrimSpace(item[:i])).To4()
+		if from == nil {
+			return groupItem{}, fmt.Errorf("%q: the start of a range must be an IPv4 address", item)
+		}
+		end := strings.TrimSpace(item[i+1:])
+		to := net.ParseIP(end).To4()
+		if last, err := strconv.Atoi(end); err == nil && last >= 0 && last <= 255 {
+			to = net.IPv4(from[0], from[1], from[2], byte(last)).To4()
+		}
+		if to == nil {
+			return groupItem{}, fmt.Errorf("%q: the end of a range must be an IPv4 address or its last byte", item)
+		}
+		r := groupItem{from: IP4ToInt(from), to: IP4ToInt(to)}
+		if r.to < r.from {
+			return groupItem{}, fmt.Errorf("%q: a range must go from the lowest to the highest address", item)
+		}
+		return r, nil
+	}
+	ip := net.ParseIP(item)
+	if ip == nil {
+		return groupItem{}, fmt.Errorf("%q is not an IP address", item)
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		return groupItem{from: IP4ToInt(ip4), to: IP4ToInt(ip4)}, nil
+	}
+	return groupItem{ip: ip}, nil
+}
+
+// subtract returns the parts of the IPv4 range r not in any of the ranges.
+func subtract(r groupItem, ranges []groupItem) []groupItem {
+	pieces := []groupItem{r}
+	for _, c := range ranges {
+		if c.ip != nil {
+			continue
+		}
+		var kept []groupItem
+		for _, p := range pieces {
+			if c.to < p.from || c.from > p.to {
+				kept = append(kept, p)
+				continue
+			}
+			if c.from > p.from {
+				kept = append(kept, groupItem{from: p.from, to: c.from - 1})
+			}
+			if c.to < p.to {
+				kept = append(kept, groupItem{from: c.to + 1, to: p.to})
+			}
+		}
+		pieces = kept
+	}
+	return pieces
+}
+
+// set returns the included addresses, less the excluded ones and repeats, as
+// disjoint ranges in the order given.
+func (g *groupSpec) set() *AddressSet {
+	s := &AddressSet{}
+	used := append([]groupItem{}, g.exclude...)
+	for _, item := range g.include {
+		if item.ip == nil {
+			s.items = append(s.items, subtract(item, used)...)
+			used = append(used, item)
+			continue
+		}
+		repeat := false
+		for _, u := range used {
+			if u.ip != nil && u.ip.Equal(item.ip) {
+				repeat = true
+				break
+			}
+		}
+		if !repeat {
+			s.items = append(s.items, item)
+			used = append(used, item)
+		}
+	}
+	return s
+}
+
+// SkipNetworkEdges returns the groups without the IPv4 addresses ending in
+// .0 or .255, which some devices treat as network or broadcast addresses.
+func SkipNetworkEdges(groups []net.IP) []net.IP {
+	kept := make([]net.IP, 0, len(groups))
+	for _, group := range groups {
+		if ip4 := group.To4(); ip4 != nil && (ip4[3] == 0 || ip4[3] == 255) {
+			continue
+		}
+		kept = append(kept, group)
+	}
+	return kept
+}
+
+// ParsePorts parses a comma separated list of UDP ports and ranges of ports,
+// such as 5000-5010,6000. Ports are returned in the order given without
+// repeats.
+func ParsePorts(spec string) ([]int, error) {
+	var ports []int
+	seen := map[int]bool{}
+	for _, item := range strings.Split(spec, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		from, to := item, item
+		if i := strings.Index(item, "-"); i >= 0 {
+			from, to = item[:i], item[i+1:]
+		}
+		first, err := parsePort(from)
+		if err != nil {
+			return nil, err
+		}
+		last, err := parsePort(to)
+		if err != nil {
+			return nil, err
+		}
+		if last < first {
+			return nil, fmt.Errorf("port range %q must go from the lowest to the highest port", item)
+		}
+		for port := first; port <= last; port++ {
+			if !seen[port] {
+				seen[port] = true
+				ports = append(ports, port)
+			}
+		}
+	}
+	if len(ports) == 0 {
+		return nil, errors.New("no ports given")
+	}
+	return ports, nil
+}
+
+func parsePort(text string) (int, error) {
+	port, err := strconv.Atoi(strings.TrimSpace(text))
+	if err != nil || port < 1 || port > 65535 {
+		return 0, fmt.Errorf("port %q must be a whole number from 1 to 65535", strings.TrimSpace(text))
+	}
+	return port, nil
+}
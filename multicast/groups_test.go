@@ -0,0 +1,127 @@
+/*
+*    mcast - Command line tool and library for testing multicast traffic
+*    flows and stress testing networks and devices.
+*    Copyright (C) 2018 Will Smith
+*
+*    This program is free software: you can redistribute it and/or modify
+*    it under the terms of the GNU General Public License as published by
+*    the Free Software Foundation, either version 3 of the License, or
+*    (at your option) any later version.
+*
+*    This program is distributed in the hope that it will be useful,
+*    but WITHOUT ANY WARRANTY; without even the implied warranty of
+*    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+*    GNU General Public License for more details.
+*
+*    You should have received a copy of the GNU General Public License
+*    along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package multicast
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func ipStrings(ips []net.IP) string {
+	parts := make([]string, len(ips))
+	for i, ip := range ips {
+		parts[i] = ip.String()
+	}
+	return strings.Join(parts, ",")
+}
+
+func TestParseGroups(t *testing.T) {
+	tests := map[string]string{
+		"239.1.1.1":                           "239.1.1.1",
+		"239.1.1.0/30":                        "239.1.1.0,239.1.1.1,239.1.1.2,239.1.1.3",
+		"239.1.1.10-239.1.1.12":               "239.1.1.10,239.1.1.11,239.1.1.12",
+		"239.1.1.254-239.1.2.1":               "239.1.1.254,239.1.1.255,239.1.2.0,239.1.2.1",
+		"239.1.1.10-12, ff3e::1":              "239.1.1.10,239.1.1.11,239.1.1.12,ff3e::1",
+		"!239.1.1.2,239.1.1.0/30,239.1.1.1":   "239.1.1.0,239.1.1.1,239.1.1.3",
+		"239.1.1.0/28,!239.1.1.1-14,!ff3e::1": "239.1.1.0,239.1.1.15",
+		"ff3e::1,ff3e::2,!ff3e::1":            "ff3e::2",
+		"255.255.255.254-255.255.255.255":     "255.255.255.254,255.255.255.255",
+	}
+	for spec, want := range tests {
+		groups, err := ParseGroups(spec)
+		if err != nil {
+			t.Errorf("Received error for %q: %v", spec, err)
+		} else if got := ipStrings(groups); got != want {
+			t.Errorf("Expected %v for %q, instead got %v", want, spec, got)
+		}
+	}
+	for _, spec := range []string{"239.1.1.12-10", "239.1.1.1-ff3e::1", "ff3e::/120", "239.1.1", "!@groups.txt"} {
+		if _, err := ParseGroups(spec); err == nil {
+			t.Errorf("Expected error for %q", spec)
+		}
+	}
+}
+
+func TestParseGroupsFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "groups")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	name := filepath.Join(dir, "groups.txt")
+	if err := ioutil.WriteFile(name, []byte("# video\n239.1.1.0/30 # four\n!239.1.1.0\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	groups, err := ParseGroups("@" + name + ",239.2.2.2")
+	if err != nil {
+		t.Fatal("Received error reading a file of groups", err)
+	}
+	if got := ipStrings(groups); got != "239.1.1.1,239.1.1.2,239.1.1.3,239.2.2.2" {
+		t.Errorf("Expected the file's groups then 239.2.2.2, instead got %v", got)
+	}
+	if err := ioutil.WriteFile(name, []byte("@"+name+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ParseGroups("@" + name); err == nil || !strings.Contains(err.Error(), "nested") {
+		t.Errorf("Expected error for a file naming itself, instead got %v", err)
+	}
+}
+
+func TestGroupSet(t *testing.T) {
+	for _, spec := range []string{"239.1.1.1", "ff3e::1", "mcast-src.lab", "localhost"} {
+		if set, err := groupSet(spec); set != nil || err != nil {
+			t.Errorf("Expected %q to be a single group, instead got %v (%v)", spec, set, err)
+		}
+	}
+	set, err := groupSet("239.1.1.1-3")
+	if err != nil || set == nil || set.Count() != 3 {
+		t.Errorf("Expected 3 groups for 239.1.1.1-3, instead got %v (%v)", set, err)
+	}
+	if _, err := groupSet("239.1.1.12-10"); err == nil {
+		t.Error("Expected error for 239.1.1.12-10")
+	}
+}
+
+func TestSkipNetworkEdges(t *testing.T) {
+	groups, _ := ParseGroups("239.1.1.254-239.1.2.1,ff3e::")
+	if got := ipStrings(SkipNetworkEdges(groups)); got != "239.1.1.254,239.1.2.1,ff3e::" {
+		t.Errorf("Expected .0 and .255 to be skipped, instead got %v", got)
+	}
+}
+
+func TestParsePorts(t *testing.T) {
+	ports, err := ParsePorts("5000-5003,6000,5001")
+	if err != nil {
+		t.Fatal("Received error parsing ports", err)
+	}
+	if want := []int{5000, 5001, 5002, 5003, 6000}; !reflect.DeepEqual(ports, want) {
+		t.Errorf("Expected %v, instead got %v", want, ports)
+	}
+	for _, spec := range []string{"", "0", "5010-5000", "65536", "http"} {
+		if _, err := ParsePorts(spec); err == nil {
+			t.Errorf("Expected error for %q", spec)
+		}
+	}
+}
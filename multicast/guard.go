@@ -0,0 +1,307 @@
+/*
+*    mcast - Command line tool and library for testing multicast traffic
+*    flows and stress testing networks and devices.
+*    Copyright (C) 2018 Will Smith
+*
+*    This program is free software: you can redistribute it and/or modify
+*    it under the terms of the GNU General Public License as published by
+*    the Free Software Foundation, either version 3 of the License, or
+*    (at your option) any later version.
+*
+*    This program is distributed in the hope that it will be useful,
+*    but WITHOUT ANY WARRANTY; without even the implied warranty of
+*    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+*    GNU General Public License for more details.
+*
+*    You should have received a copy of the GNU General Public License
+*    along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package multicast
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net"
+	"strings"
+	"time"
+)
+
+// Limits are guardrails on what senders and joins may do, since a
+// misjudged send can entirely disrupt a layer 2 network. Senders are paced
+// so they stay under the packet and bit rate ceilings, and sending to more
+// groups than MaxGroups or to reserved addresses is refused.
+type Limits struct {
+	MaxPPS        float64 // packets per second a sender is held to, 0 for no ceiling
+	MaxBPS        float64 // bits per second of UDP payload a sender is held to, 0 for no ceiling
+	MaxGroups     uint64  // most groups sent to or joined at once, 0 for no limit
+	AllowReserved bool    // allows sending to 224.0.0.0/24, the all-nodes groups and broadcast addresses
+}
+
+// DefaultLimits are the limits of senders that aren't given their own.
+var DefaultLimits = Limits{MaxPPS: 100000, MaxBPS: 1e9, MaxGroups: 65536}
+
+// NoLimits turns off every guardrail.
+var NoLimits = Limits{AllowReserved: true}
+
+// limitsOr returns the limits, or DefaultLimits if they aren't set.
+func limitsOr(limits *Limits) Limits {
+	if limits != nil {
+		return *limits
+	}
+	return DefaultLimits
+}
+
+// LimitError is returned when Limits refuse an operation.
+type LimitError struct {
+	Reason   string
+	Reserved bool // refused for a reserved address rather than too many groups
+}
+
+func (e *LimitError) Error() string {
+	return "refused by the limits: " + e.Reason
+}
+
+// CheckGroup returns a LimitError if sending to the address could disrupt
+// the local network and reserved addresses aren't allowed.
+func (l Limits) CheckGroup(ip net.IP) error {
+	if l.AllowReserved {
+		return nil
+	}
+	if reason := reservedReason(ip); reason != "" {
+		return &LimitError{Reason: fmt.Sprintf("%v %v", ip, reason), Reserved: true}
+	}
+	return nil
+}
+
+// CheckGroupCount returns a LimitError if count groups are more than
+// MaxGroups.
+func (l Limits) CheckGroupCount(count uint64) error {
+	if l.MaxGroups > 0 && count > l.MaxGroups {
+		return &LimitError{Reason: fmt.Sprintf("%d groups are more than the limit of %d", count, l.MaxGroups)}
+	}
+	return nil
+}
+
+// CheckGroups returns a LimitError if the set has more groups than
+// MaxGroups, or holds a reserved address when they aren't allowed. Only
+// the reserved blocks are looked for, so the set isn't walked.
+func (l Limits) CheckGroups(groups *AddressSet) error {
+	if err := l.CheckGroupCount(groups.Count()); err != nil {
+		return err
+	}
+	if l.AllowReserved {
+		return nil
+	}
+	blocks := [][2]uint32{{0xe0000000, 0xe00000ff}, {0xffffffff, 0xffffffff}}
+	for _, broadcast := range localBroadcasts() {
+		n := IP4ToInt(broadcast)
+		blocks = append(blocks, [2]uint32{n, n})
+	}
+	for _, block := range blocks {
+		if ip, ok := groups.firstIn(block[0], block[1]); ok {
+			return l.CheckGroup(ip)
+		}
+	}
+	for _, item := range groups.items {
+		if item.ip != nil {
+			if err := l.CheckGroup(item.ip); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// reservedReason returns why sending to the address could disrupt the local
+// network, or "" if it can't.
+func reservedReason(ip net.IP) string {
+	switch ClassifyAddress(ip).Class {
+	case ClassLinkLocal:
+		return "is in 224.0.0.0/24, used by routing protocols and IGMP on every link"
+	case ClassBroadcast:
+		return "is the broadcast address"
+	}
+	if ip.Equal(net.IPv6interfacelocalallnodes) || ip.Equal(net.IPv6linklocalallnodes) {
+		return "is the all-nodes group every IPv6 host listens to"
+	}
+	for _, broadcast := range localBroadcasts() {
+		if ip.Equal(broadcast) {
+			return "is the broadcast address of a local network"
+		}
+	}
+	return ""
+}
+
+// localBroadcasts returns the broadcast addresses of the IPv4 networks of
+// this host's interfaces.
+func localBroadcasts() []net.IP {
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return nil
+	}
+	var broadcasts []net.IP
+	for _, addr := range addrs {
+		ipNet, ok := addr.(*net.IPNet)
+		if !ok || ipNet.IP.To4() == nil {
+			continue
+		}
+		if ones, bits := ipNet.Mask.Size(); bits != 32 || ones > 30 {
+			continue
+		}
+		broadcast := make(net.IP, 4)
+		for i, b := range ipNet.IP.To4() {
+			broadcast[i] = b | ^ipNet.Mask[len(ipNet.Mask)-4+i]
+		}
+		broadcasts = append(broadcasts, broadcast)
+	}
+	return broadcasts
+}
+
+// ceiling returns the packet rate the limits hold a sender asking for rate
+// packets per second, of size bytes each, to.
+func (l Limits) ceiling(rate float64, size int) float64 {
+	if l.MaxPPS > 0 && l.MaxPPS < rate {
+		rate = l.MaxPPS
+	}
+	if l.MaxBPS > 0 && size > 0 && l.MaxBPS/float64(size*8) < rate {
+		rate = l.MaxBPS / float64(size*8)
+	}
+	return rate
+}
+
+// pacer holds a sender to the rate ceilings of its limits, by working out
+// the earliest time after the start that the next packet may be sent.
+type pacer struct {
+	ctx     context.Context
+	limits  Limits
+	start   time.Time
+	packets float64
+	bits    float64
+	at      time.Duration
+}
+
+func newPacer(ctx context.Context, limits Limits, start time.Time) *pacer {
+	return &pacer{ctx: ctx, limits: limits, start: start}
+}
+
+// wait sleeps until the next packet may be sent, returning the context's
+// error early if it is done first.
+func (p *pacer) wait() error {
+	return sleepContext(p.ctx, time.Until(p.start.Add(p.at)))
+}
+
+// sent records packets sent, totalling bytes of UDP payload.
+func (p *pacer) sent(packets, bytes int) {
+	p.packets += float64(packets)
+	p.bits += float64(bytes) * 8
+	if p.limits.MaxPPS > 0 {
+		if at := seconds(p.packets / p.limits.MaxPPS); at > p.at {
+			p.at = at
+		}
+	}
+	if p.limits.MaxBPS > 0 {
+		if at := seconds(p.bits / p.limits.MaxBPS); at > p.at {
+			p.at = at
+		}
+	}
+}
+
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
+
+// peakRate returns the most packets a profile sends in any one second of
+// its first minute, or +Inf if it sends them all at once.
+func peakRate(profile Profile) float64 {
+	const most = 1000000
+	var window []time.Duration
+	var at time.Duration
+	peak := 0
+	for sent := 0; sent < most && at < time.Minute; sent++ {
+		window = append(window, at)
+		for at-window[0] >= time.Second {
+			window = window[1:]
+		}
+		if len(window) > peak {
+			peak = len(window)
+		}
+		at = profile.Next(sent+1, at)
+	}
+	if at < time.Second {
+		// the profile sent all it was allowed before a second was up
+		if at <= 0 {
+			return math.Inf(1)
+		}
+		return most / at.Seconds()
+	}
+	return float64(peak)
+}
+
+// MaxPlanStreams is the most streams a SendPlan lists.
+const MaxPlanStreams = 16
+
+// StreamPlan is one group and port a sender plans to send to.
+type StreamPlan struct {
+	Group net.IP
+	Port  int
+}
+
+// SendPlan describes the traffic a sender would put on the network, as
+// shown by a dry run.
+type SendPlan struct {
+	Streams      []StreamPlan // the first streams, at most MaxPlanStreams
+	Count        uint64       // streams in all
+	Sources      uint64       // forged source addresses each message is sent from, 0 if none
+	Packets      uint64       // packets in all, 0 for no end
+	PacketSize   int          // largest UDP payload in bytes
+	RequestedPPS float64      // packets per second asked for, +Inf for as fast as possible
+	PPS          float64      // packets per second once held to the limits
+	BPS          float64      // bits per second of UDP payload once held to the limits
+	Limits       Limits
+	Refused      error // why the limits refuse to send, nil if they don't
+}
+
+// limit fills in the rates the limits allow and whether they refuse the
+// groups.
+func (p *SendPlan) limit(limits Limits, groups *AddressSet) {
+	p.Limits = limits
+	p.PPS = limits.ceiling(p.RequestedPPS, p.PacketSize)
+	p.BPS = p.PPS * float64(p.PacketSize*8)
+	p.Refused = limits.CheckGroups(groups)
+}
+
+func formatRate(rate float64, unit string) string {
+	if math.IsInf(rate, 1) {
+		return "unlimited " + unit
+	}
+	return fmt.Sprintf("%.1f %v", rate, unit)
+}
+
+func (p *SendPlan) String() string {
+	lines := []string{fmt.Sprintf("%d streams", p.Count)}
+	for _, stream := range p.Streams {
+		lines = append(lines, fmt.Sprintf("  %v:%d", stream.Group, stream.Port))
+	}
+	if uint64(len(p.Streams)) < p.Count {
+		lines = append(lines, fmt.Sprintf("  ... and %d more", p.Count-uint64(len(p.Streams))))
+	}
+	if p.Sources > 0 {
+		lines = append(lines, fmt.Sprintf("each message sent from %d forged sources", p.Sources))
+	}
+	packets := "no end"
+	if p.Packets > 0 {
+		packets = fmt.Sprint(p.Packets)
+	}
+	lines = append(lines, fmt.Sprintf("packets: %v of up to %d bytes", packets, p.PacketSize))
+	rate := fmt.Sprintf("rate: %v, %v", formatRate(p.PPS, "pps"), formatRate(p.BPS, "bps"))
+	if p.PPS < p.RequestedPPS {
+		rate += fmt.Sprintf(" (held down from %v by the limits)", formatRate(p.RequestedPPS, "pps"))
+	}
+	lines = append(lines, rate)
+	if p.Refused != nil {
+		lines = append(lines, p.Refused.Error())
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,201 @@
+/*
+*    mcast - Command line tool and library for testing multicast traffic
+*    flows and stress testing networks and devices.
+*    Copyright (C) 2018 Will Smith
+*
+*    This program is free software: you can redistribute it and/or modify
+*    it under the terms of the GNU General Public License as published by
+*    the Free Software Foundation, either version 3 of the License, or
+*    (at your option) any later version.
+*
+*    This program is distributed in the hope that it will be useful,
+*    but WITHOUT ANY WARRANTY; without even the implied warranty of
+*    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+*    GNU General Public License for more details.
+*
+*    You should have received a copy of the GNU General Public License
+*    along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package multicast
+
+import (
+	"errors"
+	"math"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestLimitsCheckGroup(t *testing.T) {
+	limits := Limits{}
+	for address, refused := range map[string]bool{
+		"224.0.0.5":       true,
+		"255.255.255.255": true,
+		"ff02::1":         true,
+		"224.0.1.1":       false,
+		"239.1.1.1":       false,
+		"ff05::1:3":       false,
+	} {
+		err := limits.CheckGroup(net.ParseIP(address))
+		if refused != (err != nil) {
+			t.Errorf("Expected %v refused to be %v, instead got %v", address, refused, err)
+		}
+		var limitErr *LimitError
+		if err != nil && (!errors.As(err, &limitErr) || !limitErr.Reserved) {
+			t.Errorf("Expected a reserved LimitError for %v, instead got %v", address, err)
+		}
+	}
+	limits.AllowReserved = true
+	if err := limits.CheckGroup(net.ParseIP("224.0.0.5")); err != nil {
+		t.Errorf("Expected reserved groups to be allowed, instead got %v", err)
+	}
+}
+
+func TestLimitsCheckGroups(t *testing.T) {
+	limits := Limits{MaxGroups: 256}
+	for spec, refused := range map[string]bool{
+		"239.1.1.0/24":                      false,
+		"239.1.0.0/23":                      true,
+		"223.255.255.250-224.0.0.3":         true,
+		"224.0.0.0/24,!224.0.0.1-254":       true,
+		"255.255.255.0/24,!255.255.255.255": false,
+	} {
+		set, err := ParseAddressSet(spec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := limits.CheckGroups(set); refused != (err != nil) {
+			t.Errorf("Expected %v refused to be %v, instead got %v", spec, refused, err)
+		}
+	}
+	// with the edges skipped only 224.0.0.0 and 224.0.0.255 are left out
+	set, _ := ParseAddressSet("224.0.0.0,224.0.0.255,239.1.1.1")
+	if err := limits.CheckGroups(set.SkipEdges()); err != nil {
+		t.Errorf("Expected the edges to be skipped, instead got %v", err)
+	}
+}
+
+func TestJoinRawLimits(t *testing.T) {
+	limits := Limits{MaxGroups: 256}
+	for address, refused := range map[string]bool{
+		"239.1.0.0/16":    true,
+		"239.1.1.1-3":     false,
+		"224.0.0.5":       true,
+		"239.1.1.1,!ff3e": true,
+	} {
+		err := JoinRaw(address, 5050, "", 0, false, 2, limits)
+		if refused != (err != nil) {
+			t.Errorf("Expected %v refused to be %v, instead got %v", address, refused, err)
+		}
+	}
+}
+
+func TestPacer(t *testing.T) {
+	pace := newPacer(nil, Limits{MaxPPS: 1000, MaxBPS: 80000}, time.Now())
+	pace.sent(1, 10)
+	if pace.at != time.Millisecond {
+		t.Errorf("Expected the next packet after 1ms, instead got %v", pace.at)
+	}
+	// 100 bytes at 80000 bits per second take 10ms
+	pace.sent(1, 90)
+	if pace.at != 10*time.Millisecond {
+		t.Errorf("Expected the next packet after 10ms, instead got %v", pace.at)
+	}
+}
+
+func TestPeakRate(t *testing.T) {
+	if rate := peakRate(&ConstantProfile{Rate: 100}); rate != 100 {
+		t.Errorf("Expected 100 pps, instead got %v", rate)
+	}
+	burst, err := ParseProfile("burst:count=10,every=100ms")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rate := peakRate(burst); rate != 100 {
+		t.Errorf("Expected 100 pps, instead got %v", rate)
+	}
+}
+
+func TestManySenderPlan(t *testing.T) {
+	set, err := ParseAddressSet("239.1.1.0/24")
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := NewManySenderSet(set, 5000, 1)
+	m.SetPorts([]int{5000, 5001})
+	m.SetLimits(Limits{MaxPPS: 100})
+	plan, err := m.Plan("x", 1000, 1, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if plan.Count != 512 || plan.Packets != 1536 || len(plan.Streams) != MaxPlanStreams {
+		t.Errorf("Expected 512 streams and 1536 packets, instead got %d and %d", plan.Count, plan.Packets)
+	}
+	if plan.RequestedPPS != 512 || plan.PPS != 100 || plan.BPS != 800 {
+		t.Errorf("Expected 512 pps held to 100 pps, instead got %v held to %v (%v bps)", plan.RequestedPPS, plan.PPS, plan.BPS)
+	}
+	plan, _ = NewSender("239.1.1.1", 5000, 1).Plan("x", 0, 1, 0)
+	if !math.IsInf(plan.RequestedPPS, 1) || plan.PPS != DefaultLimits.MaxPPS {
+		t.Errorf("Expected an unpaced sender held to %v pps, instead got %v", DefaultLimits.MaxPPS, plan.PPS)
+	}
+}
+
+func TestSenderPlanSources(t *testing.T) {
+	sources, err := ParseAddressSet("10.0.0.0/8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewSender("239.1.1.1", 5000, 1)
+	s.SetSources(sources, 0)
+	plan, err := s.Plan("x", 1000, 1, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if plan.Sources != 1<<24 || plan.Packets != 2<<24 {
+		t.Errorf("Expected %d sources and %d packets, instead got %d and %d", 1<<24, 2<<24, plan.Sources, plan.Packets)
+	}
+	if !s.SourceAddress.Equal(net.IPv4(10, 0, 0, 0)) {
+		t.Errorf("Expected the first source 10.0.0.0, instead got %v", s.SourceAddress)
+	}
+	s.SetSources(nil, 0)
+	if s.Protocol != "udp" || s.Sources != nil {
+		t.Errorf("Expected no sources to go back to UDP, instead got %v with %v", s.Protocol, s.Sources)
+	}
+}
+
+func TestSenderRefusesReserved(t *testing.T) {
+	s := NewSender("224.0.0.5", 5000, 1)
+	var limitErr *LimitError
+	if err := s.One("x"); !errors.As(err, &limitErr) {
+		t.Errorf("Expected a LimitError, instead got %v", err)
+	}
+}
+
+func TestSenderOnePaced(t *testing.T) {
+	listener, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal("Unable to listen on loopback", err)
+	}
+	defer listener.Close()
+	s := NewSender("127.0.0.1", listener.LocalAddr().(*net.UDPAddr).Port, 1)
+	defer s.Close()
+	s.SetLimits(Limits{MaxPPS: 50})
+
+	start := time.Now()
+	for i := 0; i < 5; i++ {
+		if err := s.One("x"); err != nil {
+			t.Fatal("Received error sending", err)
+		}
+	}
+	// the first message goes straight away and each of the rest 20ms later
+	if elapsed := time.Since(start); elapsed < 80*time.Millisecond {
+		t.Errorf("Expected 5 messages at 50 pps to take at least 80ms, instead took %v", elapsed)
+	}
+
+	s.SetAddress("224.0.0.5")
+	var limitErr *LimitError
+	if err := s.One("x"); !errors.As(err, &limitErr) {
+		t.Errorf("Expected a LimitError once the address changed, instead got %v", err)
+	}
+}
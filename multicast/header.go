@@ -0,0 +1,179 @@
+/*
+*    mcast - Command line tool and library for testing multicast traffic
+*    flows and stress testing networks and devices.
+*    Copyright (C) 2018 Will Smith
+*
+*    This program is free software: you can redistribute it and/or modify
+*    it under the terms of the GNU General Public License as published by
+*    the Free Software Foundation, either version 3 of the License, or
+*    (at your option) any later version.
+*
+*    This program is distributed in the hope that it will be useful,
+*    but WITHOUT ANY WARRANTY; without even the implied warranty of
+*    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+*    GNU General Public License for more details.
+*
+*    You should have received a copy of the GNU General Public License
+*    along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package multicast
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"time"
+)
+
+// TestHeaderLen is the length of the test header at the start of a packet.
+const TestHeaderLen = 40
+
+// crcTrailerLen is the length of the CRC32 trailer at the end of a packet.
+const crcTrailerLen = 4
+
+const testHeaderVersion = 1
+
+// testHeaderMagic marks the start of a packet sent with a test header.
+var testHeaderMagic = []byte("MCST")
+
+// Test header flags
+const (
+	HeaderFlagCRC = 1 << iota // a CRC32 trailer covers the rest of the packet
+)
+
+// TestHeader is written at the start of a packet so the receiver can track
+// loss and check the packet's contents. On the wire it is:
+//
+//	0  magic "MCST"        4 bytes
+//	4  version             1 byte
+//	5  flags               1 byte
+//	6  pattern kind        1 byte, 0 for none
+//	7  TOS                 1 byte, as set by the sender
+//	8  TTL                 1 byte, as set by the sender
+//	9  sweep TTL           1 byte, lowest TTL of the sender's TTL sweep, 0 without one
+//	10 pattern offset      2 bytes, where the pattern starts in the packet
+//	12 length              4 bytes, of the whole packet as built
+//	16 sequence            8 bytes
+//	24 pattern seed        8 bytes
+//	32 send time           8 bytes, nanoseconds since the Unix epoch
+//
+// All fields are big endian. When the CRC flag is set, the last 4 bytes of
+// the packet are the IEEE CRC32 of everything before them.
+type TestHeader struct {
+	Flags         uint8
+	Pattern       PatternKind
+	TOS           uint8
+	TTL           uint8
+	SweepTTL      uint8
+	PatternOffset uint16
+	Length        uint32
+	Sequence      uint64
+	Seed          uint64
+	SentAt        time.Time
+}
+
+// ErrNotTestPacket is returned by ParseTestHeader for packets that don't
+// start with a test header.
+var ErrNotTestPacket = errors.New("packet has no test header")
+
+// Marshal writes the header into the first TestHeaderLen bytes of b.
+func (h *TestHeader) Marshal(b []byte) {
+	copy(b, testHeaderMagic)
+	b[4] = testHeaderVersion
+	b[5] = h.Flags
+	b[6] = byte(h.Pattern)
+	b[7] = h.TOS
+	b[8] = h.TTL
+	b[9] = h.SweepTTL
+	binary.BigEndian.PutUint16(b[10:], h.PatternOffset)
+	binary.BigEndian.PutUint32(b[12:], h.Length)
+	binary.BigEndian.PutUint64(b[16:], h.Sequence)
+	binary.BigEndian.PutUint64(b[24:], h.Seed)
+	binary.BigEndian.PutUint64(b[32:], uint64(h.SentAt.UnixNano()))
+}
+
+// ParseTestHeader reads the test header from the start of a packet.
+func ParseTestHeader(b []byte) (*TestHeader, error) {
+	if len(b) < TestHeaderLen || !bytes.Equal(b[:4], testHeaderMagic) {
+		return nil, ErrNotTestPacket
+	}
+	if b[4] != testHeaderVersion {
+		return nil, fmt.Errorf("unsupported test header version %d", b[4])
+	}
+	return &TestHeader{
+		Flags:         b[5],
+		Pattern:       PatternKind(b[6]),
+		TOS:           b[7],
+		TTL:           b[8],
+		SweepTTL:      b[9],
+		PatternOffset: binary.BigEndian.Uint16(b[10:]),
+		Length:        binary.BigEndian.Uint32(b[12:]),
+		Sequence:      binary.BigEndian.Uint64(b[16:]),
+		Seed:          binary.BigEndian.Uint64(b[24:]),
+		SentAt:        time.Unix(0, int64(binary.BigEndian.Uint64(b[32:]))),
+	}, nil
+}
+
+// Kinds of corruption found by TestHeader.Verify.
+var (
+	ErrLengthMismatch  = errors.New("length differs from the length sent")
+	ErrCRCMismatch     = errors.New("CRC32 trailer doesn't match")
+	ErrPatternMismatch = errors.New("pattern bytes don't match")
+)
+
+// CorruptionError describes how a packet failed verification. Kind is one of
+// ErrLengthMismatch, ErrCRCMismatch or ErrPatternMismatch.
+type CorruptionError struct {
+	Kind   error
+	Detail string
+}
+
+func (e *CorruptionError) Error() string {
+	return fmt.Sprintf("%v: %v", e.Kind, e.Detail)
+}
+
+// Verify checks a received packet, which starts with this header, against
+// what the sender wrote. The length is checked first, as a packet that was
+// truncated or extended can't have its trailer or pattern checked reliably,
+// then the CRC32 trailer and then the pattern. A *CorruptionError is returned
+// for the first problem found.
+func (h *TestHeader) Verify(packet []byte) error {
+	if uint32(len(packet)) != h.Length {
+		change := "truncated"
+		if uint32(len(packet)) > h.Length {
+			change = "extended"
+		}
+		return &CorruptionError{Kind: ErrLengthMismatch,
+			Detail: fmt.Sprintf("%v, received %d bytes, sent %d", change, len(packet), h.Length)}
+	}
+	end := len(packet)
+	if h.Flags&HeaderFlagCRC != 0 {
+		end -= crcTrailerLen
+		if end < TestHeaderLen {
+			return &CorruptionError{Kind: ErrLengthMismatch, Detail: "too short for the CRC32 trailer"}
+		}
+		sent := binary.BigEndian.Uint32(packet[end:])
+		if actual := crc32.ChecksumIEEE(packet[:end]); actual != sent {
+			return &CorruptionError{Kind: ErrCRCMismatch, Detail: fmt.Sprintf("received %08x, sent %08x", actual, sent)}
+		}
+	}
+	if h.Pattern != 0 {
+		start := int(h.PatternOffset)
+		if start < TestHeaderLen || start > end {
+			return &CorruptionError{Kind: ErrPatternMismatch, Detail: fmt.Sprintf("pattern offset %d out of range", start)}
+		}
+		expected := make([]byte, end-start)
+		(&Pattern{Kind: h.Pattern, Seed: h.Seed}).Fill(expected, h.Sequence)
+		actual := packet[start:end]
+		for i := range expected {
+			if actual[i] != expected[i] {
+				return &CorruptionError{Kind: ErrPatternMismatch,
+					Detail: fmt.Sprintf("first difference at byte %d, received %02x, expected %02x", start+i, actual[i], expected[i])}
+			}
+		}
+	}
+	return nil
+}
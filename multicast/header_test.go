@@ -0,0 +1,194 @@
+/*
+*    mcast - Command line tool and library for testing multicast traffic
+*    flows and stress testing networks and devices.
+*    Copyright (C) 2018 Will Smith
+*
+*    This program is free software: you can redistribute it and/or modify
+*    it under the terms of the GNU General Public License as published by
+*    the Free Software Foundation, either version 3 of the License, or
+*    (at your option) any later version.
+*
+*    This program is distributed in the hope that it will be useful,
+*    but WITHOUT ANY WARRANTY; without even the implied warranty of
+*    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+*    GNU General Public License for more details.
+*
+*    You should have received a copy of the GNU General Public License
+*    along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package multicast
+
+import (
+	"reflect"
+	"testing"
+
+	"golang.org/x/net/ipv4"
+)
+
+func buildTestPacket(t *testing.T, crc bool, size int, seq int) []byte {
+	tmpl, err := ParseTemplate("seq {c}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := &payloadBuilder{template: tmpl, pattern: &Pattern{Kind: PatternPRBS15}, size: size, header: true, crc: crc, sweepTTL: 2}
+	return b.build(nil, TemplateValues{Counter: seq, TTL: 5})
+}
+
+func corruptionKind(err error) error {
+	if c, ok := err.(*CorruptionError); ok {
+		return c.Kind
+	}
+	return err
+}
+
+func TestHeaderRoundTrip(t *testing.T) {
+	packet := buildTestPacket(t, true, 200, 7)
+	if len(packet) != 200 {
+		t.Fatalf("Expected packet of 200 bytes, instead got %d", len(packet))
+	}
+	h, err := ParseTestHeader(packet)
+	if err != nil {
+		t.Fatal("Received error parsing header", err)
+	}
+	if h.Sequence != 7 || h.Length != 200 || h.TTL != 5 || h.SweepTTL != 2 || h.Pattern != PatternPRBS15 || h.Flags&HeaderFlagCRC == 0 {
+		t.Errorf("Unexpected header %+v", h)
+	}
+	if err := h.Verify(packet); err != nil {
+		t.Error("Expected clean packet to verify, instead got", err)
+	}
+	if _, err := ParseTestHeader([]byte("plain text message that is long enough to hold a header")); err != ErrNotTestPacket {
+		t.Errorf("Expected ErrNotTestPacket, instead got %v", err)
+	}
+}
+
+func TestHeaderVerifyCorruption(t *testing.T) {
+	packet := buildTestPacket(t, true, 200, 1)
+	h, _ := ParseTestHeader(packet)
+
+	if err := h.Verify(packet[:150]); corruptionKind(err) != ErrLengthMismatch {
+		t.Errorf("Expected length mismatch for truncated packet, instead got %v", err)
+	}
+	if err := h.Verify(append(append([]byte{}, packet...), 0)); corruptionKind(err) != ErrLengthMismatch {
+		t.Errorf("Expected length mismatch for extended packet, instead got %v", err)
+	}
+	flipped := append([]byte{}, packet...)
+	flipped[100] ^= 0x01
+	if err := h.Verify(flipped); corruptionKind(err) != ErrCRCMismatch {
+		t.Errorf("Expected CRC mismatch, instead got %v", err)
+	}
+
+	packet = buildTestPacket(t, false, 200, 1)
+	h, _ = ParseTestHeader(packet)
+	packet[150] ^= 0x80
+	if err := h.Verify(packet); corruptionKind(err) != ErrPatternMismatch {
+		t.Errorf("Expected pattern mismatch, instead got %v", err)
+	}
+}
+
+func TestStreamSequence(t *testing.T) {
+	s := newStream("", "")
+	for _, seq := range []uint64{1, 2, 5, 3, 3, 6, 10, 8} {
+		s.received(&message{Header: &TestHeader{Sequence: seq}, TOS: -1})
+	}
+	expected := StreamStats{Received: 8, Lost: 3, Duplicates: 1, Reordered: 2, FirstSequence: 1, LastSequence: 10, Hops: -1}
+	stats := s.stats
+	stats.Sizes = nil
+	stats.TTLs = nil
+	if !reflect.DeepEqual(stats, expected) {
+		t.Errorf("Expected %+v, instead got %+v", expected, stats)
+	}
+	s.received(&message{Header: &TestHeader{Sequence: 11}, Problem: &CorruptionError{Kind: ErrCRCMismatch}, TOS: -1})
+	if s.stats.Corrupted != 1 || s.stats.CRCErrors != 1 {
+		t.Errorf("Expected 1 CRC error, instead got %+v", s.stats)
+	}
+}
+
+func TestStreamMissingSizes(t *testing.T) {
+	s := newStream("", "")
+	for _, p := range []struct {
+		seq  uint64
+		size int
+	}{{1, 1000}, {2, 1400}, {6, 1000}, {4, 1200}, {9, 1400}} {
+		s.received(&message{Header: &TestHeader{Sequence: p.seq, Length: uint32(p.size)}, TOS: -1})
+	}
+	stats := s.snapshot()
+	expected := []MissingRange{
+		{First: 3, Last: 3, SizeBefore: 1400, SizeAfter: 1200},
+		{First: 5, Last: 5, SizeBefore: 1200, SizeAfter: 1000},
+		{First: 7, Last: 8, SizeBefore: 1000, SizeAfter: 1400},
+	}
+	if !reflect.DeepEqual(stats.Missing, expected) {
+		t.Errorf("Expected missing %v, instead got %v", expected, stats.Missing)
+	}
+	if stats.Sizes[1000] != 2 || stats.Sizes[1400] != 2 || stats.Sizes[1200] != 1 {
+		t.Errorf("Unexpected size histogram %v", stats.Sizes)
+	}
+}
+
+func TestStreamRemarked(t *testing.T) {
+	s := newStream("", "")
+	h := &TestHeader{TOS: 0xb8}
+	for i, tos := range []int{0xb8, 0xbb, 0x00, -1} {
+		h.Sequence = uint64(i)
+		s.received(&message{Header: h, TOS: tos})
+	}
+	stats := s.snapshot()
+	if stats.Remarked != 1 {
+		t.Errorf("Expected 1 remarked packet, instead got %d", stats.Remarked)
+	}
+	if stats.SentTOS != 0xb8 || stats.ReceivedTOS[0xb8] != 1 || stats.ReceivedTOS[0xbb] != 1 || stats.ReceivedTOS[0] != 1 {
+		t.Errorf("Unexpected TOS counts sent %#x received %v", stats.SentTOS, stats.ReceivedTOS)
+	}
+}
+
+func TestStreamTTLThreshold(t *testing.T) {
+	s := newStream("", "")
+	// 3 hops away, but packets of a sweep from 1 sent with a TTL below 6 are
+	// dropped
+	for seq, ttl := range []int{6, 7, 8} {
+		s.received(&message{Header: &TestHeader{Sequence: uint64(seq), TTL: uint8(ttl), SweepTTL: 1},
+			CM: &ipv4.ControlMessage{TTL: ttl - 3}, TOS: -1})
+	}
+	stats := s.snapshot()
+	if stats.LowestTTL != 6 || stats.Hops != 3 {
+		t.Errorf("Expected lowest TTL 6 and 3 hops, instead got %d and %d", stats.LowestTTL, stats.Hops)
+	}
+	if !stats.TTLThreshold() {
+		t.Error("Expected a TTL threshold")
+	}
+	if from, to := stats.ThresholdTTLs(); from != 4 || to != 5 {
+		t.Errorf("Expected TTLs 4-5 to be missing, instead got %d-%d", from, to)
+	}
+	s.received(&message{Header: &TestHeader{Sequence: 3, TTL: 4, SweepTTL: 1}, CM: &ipv4.ControlMessage{TTL: 1}, TOS: -1})
+	if stats := s.snapshot(); stats.LowestTTL != 4 || stats.TTLThreshold() {
+		t.Errorf("Expected lowest TTL 4 and no threshold, instead got %d", stats.LowestTTL)
+	}
+}
+
+func TestStreamTTLSweepAboveHops(t *testing.T) {
+	s := newStream("", "")
+	// 3 hops away, with a sweep that starts at 10 and loses nothing
+	for seq, ttl := range []int{10, 11, 12} {
+		s.received(&message{Header: &TestHeader{Sequence: uint64(seq), TTL: uint8(ttl), SweepTTL: 10},
+			CM: &ipv4.ControlMessage{TTL: ttl - 3}, TOS: -1})
+	}
+	stats := s.snapshot()
+	if stats.LowestTTL != 10 || stats.SweepTTL != 10 || stats.Hops != 3 {
+		t.Errorf("Expected lowest TTL 10 of a sweep from 10 and 3 hops, instead got %d, %d and %d", stats.LowestTTL, stats.SweepTTL, stats.Hops)
+	}
+	if stats.TTLThreshold() {
+		t.Error("Expected no TTL threshold for a sweep starting above the hops")
+	}
+
+	// the same sweep, but TTLs below 12 are dropped
+	s = newStream("", "")
+	s.received(&message{Header: &TestHeader{Sequence: 2, TTL: 12, SweepTTL: 10}, CM: &ipv4.ControlMessage{TTL: 9}, TOS: -1})
+	stats = s.snapshot()
+	if !stats.TTLThreshold() {
+		t.Error("Expected a TTL threshold")
+	}
+	if from, to := stats.ThresholdTTLs(); from != 10 || to != 11 {
+		t.Errorf("Expected TTLs 10-11 to be missing, instead got %d-%d", from, to)
+	}
+}
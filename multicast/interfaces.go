@@ -0,0 +1,136 @@
+/*
+*    mcast - Command line tool and library for testing multicast traffic
+*    flows and stress testing networks and devices.
+*    Copyright (C) 2018 Will Smith
+*
+*    This program is free software: you can redistribute it and/or modify
+*    it under the terms of the GNU General Public License as published by
+*    the Free Software Foundation, either version 3 of the License, or
+*    (at your option) any later version.
+*
+*    This program is distributed in the hope that it will be useful,
+*    but WITHOUT ANY WARRANTY; without even the implied warranty of
+*    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+*    GNU General Public License for more details.
+*
+*    You should have received a copy of the GNU General Public License
+*    along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package multicast
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// InterfaceInfo describes an interface and its multicast state.
+type InterfaceInfo struct {
+	Index        int
+	Name         string
+	MTU          int
+	Flags        net.Flags
+	HardwareAddr net.HardwareAddr
+	Addrs        []*net.IPNet // IPv4 and IPv6 addresses
+	Groups       []net.IP     // joined multicast groups
+}
+
+// Multicast reports whether multicast can be sent and received on the
+// interface.
+func (i *InterfaceInfo) Multicast() bool {
+	return MulticastCapable(&net.Interface{Flags: i.Flags})
+}
+
+// MulticastCapable reports whether multicast can be sent and received on the
+// interface. Loopback interfaces carry multicast without the multicast flag.
+func MulticastCapable(ifi *net.Interface) bool {
+	return ifi.Flags&(net.FlagMulticast|net.FlagLoopback) != 0
+}
+
+// ListInterfaces returns every interface with its addresses and joined
+// groups, in index order.
+func ListInterfaces() ([]InterfaceInfo, error) {
+	ifis, err := net.Interfaces()
+	if err != nil {
+		return nil, err
+	}
+	infos := make([]InterfaceInfo, len(ifis))
+	for i, ifi := range ifis {
+		info := InterfaceInfo{Index: ifi.Index, Name: ifi.Name, MTU: ifi.MTU, Flags: ifi.Flags, HardwareAddr: ifi.HardwareAddr}
+		if addrs, err := ifi.Addrs(); err == nil {
+			for _, addr := range addrs {
+				if ipNet, ok := addr.(*net.IPNet); ok {
+					info.Addrs = append(info.Addrs, ipNet)
+				}
+			}
+		}
+		// not every system can list the groups
+		if groups, err := ifi.MulticastAddrs(); err == nil {
+			for _, group := range groups {
+				if ipAddr, ok := group.(*net.IPAddr); ok {
+					info.Groups = append(info.Groups, ipAddr.IP)
+				}
+			}
+		}
+		infos[i] = info
+	}
+	return infos, nil
+}
+
+// DefaultMulticastInterface returns the interface the system sends multicast
+// to the group out of when no interface is chosen, by asking the routing
+// table which local address it would send from. A nil group uses the route
+// for 239.1.1.1.
+func DefaultMulticastInterface(group net.IP) (*net.Interface, error) {
+	if group == nil {
+		group = net.IPv4(239, 1, 1, 1)
+	}
+	// connecting a UDP socket sends nothing, but picks the route
+	conn, err := net.DialUDP("udp", nil, &net.UDPAddr{IP: group, Port: 9})
+	if err != nil {
+		return nil, fmt.Errorf("no route for multicast to %v: %v", group, err)
+	}
+	local := conn.LocalAddr().(*net.UDPAddr).IP
+	conn.Close()
+
+	ifis, err := net.Interfaces()
+	if err != nil {
+		return nil, err
+	}
+	for i := range ifis {
+		addrs, err := ifis[i].Addrs()
+		if err != nil {
+			continue
+		}
+		for _, addr := range addrs {
+			if ipNet, ok := addr.(*net.IPNet); ok && ipNet.IP.Equal(local) {
+				return &ifis[i], nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("no interface has the address %v multicast to %v is sent from", local, group)
+}
+
+// LookupMulticastInterface returns the named interface, with an error
+// naming the available interfaces if there is no such interface, or saying
+// why it can't be used for multicast.
+func LookupMulticastInterface(name string) (*net.Interface, error) {
+	ifi, err := net.InterfaceByName(name)
+	if err != nil {
+		var names []string
+		if ifis, err := net.Interfaces(); err == nil {
+			for _, ifi := range ifis {
+				names = append(names, ifi.Name)
+			}
+		}
+		return nil, fmt.Errorf("no interface named %q, the interfaces are %v", name, strings.Join(names, ", "))
+	}
+	if ifi.Flags&net.FlagUp == 0 {
+		return nil, fmt.Errorf("interface %v is down", name)
+	}
+	if !MulticastCapable(ifi) {
+		return nil, fmt.Errorf("interface %v doesn't support multicast", name)
+	}
+	return ifi, nil
+}
@@ -0,0 +1,63 @@
+/*
+*    mcast - Command line tool and library for testing multicast traffic
+*    flows and stress testing networks and devices.
+*    Copyright (C) 2018 Will Smith
+*
+*    This program is free software: you can redistribute it and/or modify
+*    it under the terms of the GNU General Public License as published by
+*    the Free Software Foundation, either version 3 of the License, or
+*    (at your option) any later version.
+*
+*    This program is distributed in the hope that it will be useful,
+*    but WITHOUT ANY WARRANTY; without even the implied warranty of
+*    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+*    GNU General Public License for more details.
+*
+*    You should have received a copy of the GNU General Public License
+*    along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package multicast
+
+import (
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestListInterfaces(t *testing.T) {
+	infos, err := ListInterfaces()
+	if err != nil {
+		t.Fatal("Received error listing interfaces", err)
+	}
+	for _, info := range infos {
+		if info.Flags&net.FlagLoopback == 0 {
+			continue
+		}
+		if !info.Multicast() {
+			t.Errorf("Expected loopback %v to carry multicast", info.Name)
+		}
+		for _, addr := range info.Addrs {
+			if addr.IP.IsLoopback() {
+				return
+			}
+		}
+		t.Errorf("Expected a loopback address on %v, instead got %v", info.Name, info.Addrs)
+		return
+	}
+	t.Skip("No loopback interface")
+}
+
+func TestLookupMulticastInterface(t *testing.T) {
+	_, err := LookupMulticastInterface("no-such-interface")
+	if err == nil || !strings.Contains(err.Error(), "the interfaces are") {
+		t.Errorf("Expected an error listing the interfaces, instead got %v", err)
+	}
+	ifi, err := DefaultMulticastInterface(nil)
+	if err != nil {
+		t.Skip("No multicast route:", err)
+	}
+	if _, err := LookupMulticastInterface(ifi.Name); err != nil {
+		t.Errorf("Expected the default interface %v to be usable, instead got %v", ifi.Name, err)
+	}
+}
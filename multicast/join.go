@@ -20,10 +20,10 @@
 package multicast
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"net"
-	"strings"
 	"sync"
 
 	"golang.org/x/net/ipv4"
@@ -39,28 +39,42 @@ func joinRaw(address string, port int, interfaceName string, interval int, route
 	return nil
 }
 
-func JoinRaw(address string, port int, interfaceName string, interval int, routerAlert bool, igmpVersion int) error {
-	if strings.Contains(address, "/") {
-		ips, err := IPListCIDR(address)
-		if err != nil {
-			return err
+// JoinRaw sends raw joins for the groups address names, in any of the forms
+// ParseAddressSet takes, after checking them against the limits. A host
+// name is handed on as it is.
+func JoinRaw(address string, port int, interfaceName string, interval int, routerAlert bool, igmpVersion int, limits Limits) error {
+	ips, err := groupSet(address)
+	if err != nil {
+		return err
+	}
+	if ips == nil {
+		ip := net.ParseIP(address)
+		if ip == nil {
+			return joinRaw(address, port, interfaceName, interval, routerAlert, igmpVersion)
 		}
-		wg := new(sync.WaitGroup)
-		for _, ip := range ips {
-			wg.Add(1)
-			func(ipAddress string) {
-				err := joinRaw(ipAddress, port, interfaceName, interval, routerAlert, igmpVersion)
-				if err != nil {
-					log.Printf("Problem with join of %v:%d\n", ipAddress, port)
-					log.Println(err)
-				}
-				wg.Done()
-			}(ip.String())
+		ips = addressSetOf([]net.IP{ip})
+	}
+	if err := limits.CheckGroups(ips); err != nil {
+		return err
+	}
+	wg := new(sync.WaitGroup)
+	for it := ips.Iter(); ; {
+		ip, ok := it.Next()
+		if !ok {
+			break
 		}
-		wg.Wait()
-		return nil
+		wg.Add(1)
+		func(ipAddress string) {
+			err := joinRaw(ipAddress, port, interfaceName, interval, routerAlert, igmpVersion)
+			if err != nil {
+				log.Printf("Problem with join of %v:%d\n", ipAddress, port)
+				log.Println(err)
+			}
+			wg.Done()
+		}(ip.String())
 	}
-	return joinRaw(address, port, interfaceName, interval, routerAlert, igmpVersion)
+	wg.Wait()
+	return nil
 }
 
 // Join will use the system built in IGMP group join mechanisms to join a group.
@@ -68,30 +82,58 @@ func JoinRaw(address string, port int, interfaceName string, interval int, route
 // (group is currently joined, and timers are good). Join does not explicitly
 // send a leave request.
 func Join(joinIPString string, joinPort int, interfaceName string) error {
+	c, _, err := joinGroup(joinIPString, joinPort, interfaceName)
+	if err != nil {
+		return err
+	}
+	c.Close()
+	return nil
+}
+
+// JoinContext joins the group like Join, but holds the membership until the
+// context is done and then leaves the group.
+func JoinContext(ctx context.Context, joinIPString string, joinPort int, interfaceName string) error {
+	c, leave, err := joinGroup(joinIPString, joinPort, interfaceName)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	<-ctx.Done()
+	return leave()
+}
+
+// joinGroup listens on the group and port and joins the group on the
+// interface. The membership lasts until the returned connection is closed or
+// leave is called.
+func joinGroup(joinIPString string, joinPort int, interfaceName string) (net.PacketConn, func() error, error) {
 	address := fmt.Sprintf("%v:%d", joinIPString, joinPort)
 	c, err := net.ListenPacket("udp", address)
 	if err != nil {
 		log.Println("Problem with initial listen")
-		return err
+		return nil, nil, err
 	}
-	defer c.Close()
 
 	p := ipv4.NewPacketConn(c)
 	addr, err := net.ResolveUDPAddr("udp", address)
 	if err != nil {
 		log.Println("Problem resolving address")
-		return err
+		c.Close()
+		return nil, nil, err
 	}
 	joinInterface, err := GetInterface(interfaceName)
 	if err != nil {
-		log.Println("Problem gettin interface")
-		return err
+		log.Println("Problem getting interface")
+		c.Close()
+		return nil, nil, err
 	}
 
-	err = p.JoinGroup(joinInterface, addr)
-	if err != nil {
+	if err := p.JoinGroup(joinInterface, addr); err != nil {
 		log.Println("Problem joining")
-		return err
+		c.Close()
+		return nil, nil, err
 	}
-	return nil
+	leave := func() error {
+		return p.LeaveGroup(joinInterface, addr)
+	}
+	return c, leave, nil
 }
@@ -0,0 +1,89 @@
+/*
+*    mcast - Command line tool and library for testing multicast traffic
+*    flows and stress testing networks and devices.
+*    Copyright (C) 2018 Will Smith
+*
+*    This program is free software: you can redistribute it and/or modify
+*    it under the terms of the GNU General Public License as published by
+*    the Free Software Foundation, either version 3 of the License, or
+*    (at your option) any later version.
+*
+*    This program is distributed in the hope that it will be useful,
+*    but WITHOUT ANY WARRANTY; without even the implied warranty of
+*    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+*    GNU General Public License for more details.
+*
+*    You should have received a copy of the GNU General Public License
+*    along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package multicast
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+)
+
+// MulticastMAC returns the Ethernet address a multicast group is sent to.
+// An IPv4 group maps to 01:00:5e followed by the low 23 bits of the group,
+// so 32 groups share each address. An IPv6 group maps to 33:33 followed by
+// the low 32 bits of the group.
+func MulticastMAC(group net.IP) (net.HardwareAddr, error) {
+	if !group.IsMulticast() {
+		return nil, fmt.Errorf("%v is not a multicast group", group)
+	}
+	if ip4 := group.To4(); ip4 != nil {
+		return net.HardwareAddr{0x01, 0x00, 0x5e, ip4[1] & 0x7f, ip4[2], ip4[3]}, nil
+	}
+	return net.HardwareAddr{0x33, 0x33, group[12], group[13], group[14], group[15]}, nil
+}
+
+// MACCollision is a set of groups that are sent to the same Ethernet
+// address, so switches doing IGMP or MLD snooping can't keep them apart.
+type MACCollision struct {
+	MAC    net.HardwareAddr
+	Groups []net.IP
+}
+
+func (c MACCollision) String() string {
+	groups := make([]string, len(c.Groups))
+	for i, group := range c.Groups {
+		groups[i] = group.String()
+	}
+	return fmt.Sprintf("%v: %v", c.MAC, strings.Join(groups, ", "))
+}
+
+// FindMACCollisions returns the Ethernet addresses shared by more than one of
+// the groups, sorted by address, with the groups in the order given.
+// Repeated groups are only counted once.
+func FindMACCollisions(groups []net.IP) ([]MACCollision, error) {
+	byMAC := map[string]*MACCollision{}
+	seen := map[string]bool{}
+	for _, group := range groups {
+		if seen[string(group.To16())] {
+			continue
+		}
+		seen[string(group.To16())] = true
+		mac, err := MulticastMAC(group)
+		if err != nil {
+			return nil, err
+		}
+		c, ok := byMAC[string(mac)]
+		if !ok {
+			c = &MACCollision{MAC: mac}
+			byMAC[string(mac)] = c
+		}
+		c.Groups = append(c.Groups, group)
+	}
+	var collisions []MACCollision
+	for _, c := range byMAC {
+		if len(c.Groups) > 1 {
+			collisions = append(collisions, *c)
+		}
+	}
+	sort.Slice(collisions, func(i, j int) bool { return bytes.Compare(collisions[i].MAC, collisions[j].MAC) < 0 })
+	return collisions, nil
+}
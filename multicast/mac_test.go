@@ -0,0 +1,79 @@
+/*
+*    mcast - Command line tool and library for testing multicast traffic
+*    flows and stress testing networks and devices.
+*    Copyright (C) 2018 Will Smith
+*
+*    This program is free software: you can redistribute it and/or modify
+*    it under the terms of the GNU General Public License as published by
+*    the Free Software Foundation, either version 3 of the License, or
+*    (at your option) any later version.
+*
+*    This program is distributed in the hope that it will be useful,
+*    but WITHOUT ANY WARRANTY; without even the implied warranty of
+*    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+*    GNU General Public License for more details.
+*
+*    You should have received a copy of the GNU General Public License
+*    along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package multicast
+
+import (
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestMulticastMAC(t *testing.T) {
+	tests := map[string]string{
+		"224.0.0.1":       "01:00:5e:00:00:01",
+		"239.1.1.50":      "01:00:5e:01:01:32",
+		"239.129.1.50":    "01:00:5e:01:01:32",
+		"ff02::1":         "33:33:00:00:00:01",
+		"ff3e::8000:1234": "33:33:80:00:12:34",
+	}
+	for group, want := range tests {
+		mac, err := MulticastMAC(net.ParseIP(group))
+		if err != nil {
+			t.Errorf("Received error for %v: %v", group, err)
+		} else if mac.String() != want {
+			t.Errorf("Expected %v for %v, instead got %v", want, group, mac)
+		}
+	}
+	if _, err := MulticastMAC(net.ParseIP("192.0.2.1")); err == nil {
+		t.Error("Expected error for a unicast address")
+	}
+}
+
+func TestFindMACCollisions(t *testing.T) {
+	groups, err := ReadGroups(strings.NewReader(`# planned groups
+239.1.1.0/30
+224.1.1.1, 239.129.1.1 # both collide with 239.1.1.1
+239.1.1.2
+239.2.2.2
+`))
+	if err != nil {
+		t.Fatal("Received error reading groups", err)
+	}
+	if len(groups) != 7 {
+		t.Fatalf("Expected 7 groups without the repeat, instead got %d", len(groups))
+	}
+	collisions, err := FindMACCollisions(groups)
+	if err != nil {
+		t.Fatal("Received error finding collisions", err)
+	}
+	if len(collisions) != 1 {
+		t.Fatalf("Expected 1 collision, instead got %v", collisions)
+	}
+	want := "01:00:5e:01:01:01: 239.1.1.1, 224.1.1.1, 239.129.1.1"
+	if s := collisions[0].String(); s != want {
+		t.Errorf("Expected %v, instead got %v", want, s)
+	}
+	if _, err := ReadGroups(strings.NewReader("239.1.1.1\nnot-a-group\n")); err == nil || !strings.Contains(err.Error(), "line 2") {
+		t.Errorf("Expected error on line 2, instead got %v", err)
+	}
+	if _, err := ParseGroups("ff3e::/120"); err == nil {
+		t.Error("Expected error for an IPv6 network")
+	}
+}
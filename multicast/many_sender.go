@@ -20,55 +20,425 @@
 package multicast
 
 import (
+	"bytes"
+	"context"
 	"errors"
-	"log"
+	"fmt"
+	"math"
 	"net"
+	"sort"
 	"strings"
 	"sync"
+	"time"
+
+	"golang.org/x/net/ipv4"
 )
 
+// ManySender sends to every address in a network from a small number of
+// shared, unconnected sockets. Groups are visited round robin: each round
+// sends one message to every group, spread evenly over the round.
 type ManySender struct {
 	Addresses      *[]net.IP
+	Groups         *AddressSet // walked in place of Addresses when set, for sets too large to list
 	Port           int
+	Ports          []int // ports every group is sent to, in place of Port when set
 	TTL            int
 	MessagePadding int
 	TOS            int
 	LocalAddress   *net.UDPAddr
+	Interface      *net.Interface // sends out of this interface when set
+	NoLoopback     bool           // keeps the multicast sent from local listeners
+	Profile        Profile
+	BatchSize      int
+	Sockets        int      // number of shared sockets, 1 if not set
+	Rate           float64  // cap on packets per second across all groups, 0 for no cap
+	GroupRate      float64  // packets per second sent to each group, overrides the interval
+	Payload        []byte   // sent instead of the message text when set
+	Pattern        *Pattern // fills padding beyond the message when set
+	Sizes          *Sizes   // chooses the size of each round's messages instead of the padding
+	TTLSweep       []int    // TTLs cycled through, one per round, instead of TTL
+	Fragmentation  Fragmentation
+	Header         bool    // start every message with a TestHeader
+	CRC            bool    // end every message with a CRC32 trailer, implies Header
+	Limits         *Limits // guardrails on what is sent, DefaultLimits if not set
+	mu             sync.Mutex
+	groupErrors    map[string]*GroupError
+	stats          sendCounter
+}
+
+// GroupError records the send errors seen for one destination group.
+type GroupError struct {
+	Address net.IP
+	Count   int
+	Last    error
+}
+
+// GroupErrors is returned by ManySender.Start when sending to one or more
+// groups failed. There is one entry per group with errors.
+type GroupErrors []GroupError
+
+func (e GroupErrors) Error() string {
+	parts := make([]string, len(e))
+	for i, g := range e {
+		parts[i] = fmt.Sprintf("%v: %d errors, last: %v", g.Address, g.Count, g.Last)
+	}
+	return fmt.Sprintf("send failed for %d groups\n%v", len(e), strings.Join(parts, "\n"))
 }
 
+// NewManySender creates a ManySender for every address in a network. The
+// addresses are walked as they are sent to rather than listed, so the
+// network can be of any size.
 func NewManySender(network string, mask int, port int, ttl int) (*ManySender, error) {
-	m := ManySender{TTL: ttl, Port: port}
-	addresses, err := IPList(network, mask)
+	groups, err := ParseAddressSet(fmt.Sprintf("%v/%d", network, mask))
 	if err != nil {
 		return nil, err
 	}
-	m.Addresses = &addresses
+	return NewManySenderSet(groups, port, ttl), nil
+}
+
+// NewManySenderSet creates a ManySender for a set of groups, such as one from
+// ParseAddressSet.
+func NewManySenderSet(groups *AddressSet, port int, ttl int) *ManySender {
+	return &ManySender{Groups: groups, Port: port, TTL: ttl}
+}
+
+// NewManySenderGroups creates a ManySender for a list of groups, such as one
+// from ParseGroups.
+func NewManySenderGroups(groups []net.IP, port int, ttl int) *ManySender {
+	return &ManySender{Addresses: &groups, Port: port, TTL: ttl}
+}
+
+// manySocket is one of the shared sockets along with the messages queued on
+// it for the next batch write.
+type manySocket struct {
+	packetConn *ipv4.PacketConn
+	source     string
+	pending    []ipv4.Message
+	buffers    [][]byte
+}
+
+func (m *ManySender) listen() ([]*manySocket, error) {
+	count := m.Sockets
+	if count < 1 {
+		count = 1
+	}
+	if count > 1 && m.LocalAddress != nil && m.LocalAddress.Port != 0 {
+		return nil, errors.New("Can not share a fixed source port across multiple sockets")
+	}
+	sockets := make([]*manySocket, 0, count)
+	for i := 0; i < count; i++ {
+		conn, err := net.ListenUDP("udp4", m.LocalAddress)
+		if err != nil {
+			closeManySockets(sockets)
+			return nil, err
+		}
+		if err := setFragmentation(conn, m.Fragmentation); err != nil {
+			conn.Close()
+			closeManySockets(sockets)
+			return nil, err
+		}
+		packetConn := ipv4.NewPacketConn(conn)
+		packetConn.SetMulticastTTL(m.TTL)
+		packetConn.SetTOS(m.TOS)
+		err = packetConn.SetMulticastLoopback(!m.NoLoopback)
+		if err == nil && m.Interface != nil {
+			err = packetConn.SetMulticastInterface(m.Interface)
+		}
+		if err != nil {
+			packetConn.Close()
+			closeManySockets(sockets)
+			return nil, err
+		}
+		sockets = append(sockets, &manySocket{packetConn: packetConn, source: conn.LocalAddr().String()})
+	}
+	return sockets, nil
+}
+
+func closeManySockets(sockets []*manySocket) {
+	for _, socket := range sockets {
+		socket.packetConn.Close()
+	}
+}
 
-	return &m, nil
+// packetGap returns the time between consecutive packets, across all groups,
+// when no profile is set.
+func (m *ManySender) packetGap(interval int, groups uint64) time.Duration {
+	round := time.Duration(interval) * time.Millisecond
+	if m.GroupRate > 0 {
+		round = rateInterval(m.GroupRate)
+	}
+	gap := round / time.Duration(groups)
+	if m.Rate > 0 && rateInterval(m.Rate) > gap {
+		gap = rateInterval(m.Rate)
+	}
+	return gap
+}
+
+// destinations returns the groups and ports sent to.
+func (m *ManySender) destinations() (*AddressSet, []int) {
+	addresses := m.Groups
+	if addresses == nil {
+		var list []net.IP
+		if m.Addresses != nil {
+			list = *m.Addresses
+		}
+		addresses = addressSetOf(list)
+	}
+	ports := m.Ports
+	if len(ports) == 0 {
+		ports = []int{m.Port}
+	}
+	return addresses, ports
 }
 
-func (m *ManySender) startSender(address, message string, interval, startValue, numberOfMessages int) {
-	s := NewSender(address, m.Port, m.TTL)
-	s.SetMessagePadding(m.MessagePadding)
-	s.SetTOS(m.TOS)
-	s.LocalAddress = m.LocalAddress
-	err := s.Max(message, interval, startValue, numberOfMessages)
+// Plan returns the traffic StartContext would send with the same arguments,
+// held to the sender's limits, without sending anything.
+func (m *ManySender) Plan(message string, interval int, startValue int, numberOfMessages int) (*SendPlan, error) {
+	tmpl, err := ParseTemplate(message)
 	if err != nil {
-		log.Println("Problem sending max messages")
-		log.Println(err)
+		return nil, err
+	}
+	builder := &payloadBuilder{template: tmpl, payload: m.Payload, pattern: m.Pattern, size: m.MessagePadding,
+		sizes: m.Sizes, first: startValue, header: m.Header, crc: m.CRC, tos: m.TOS}
+	if err := builder.check(); err != nil {
+		return nil, err
+	}
+	addresses, ports := m.destinations()
+	plan := &SendPlan{Count: addresses.Count() * uint64(len(ports))}
+	plan.Packets = uint64(numberOfMessages) * plan.Count
+	for it := addresses.Iter(); uint64(len(plan.Streams)) < MaxPlanStreams; {
+		address, ok := it.Next()
+		if !ok {
+			break
+		}
+		for _, port := range ports {
+			if len(plan.Streams) < MaxPlanStreams {
+				plan.Streams = append(plan.Streams, StreamPlan{Group: address, Port: port})
+			}
+		}
+	}
+	if len(plan.Streams) > 0 {
+		first := plan.Streams[0]
+		plan.PacketSize = builder.largest(TemplateValues{Counter: startValue, Group: first.Group.String(), Port: first.Port, TTL: m.TTL})
+	}
+	switch {
+	case m.Profile != nil:
+		plan.RequestedPPS = peakRate(m.Profile) * float64(plan.Count)
+	case m.GroupRate > 0:
+		plan.RequestedPPS = m.GroupRate * float64(plan.Count)
+	case interval > 0:
+		plan.RequestedPPS = 1000 / float64(interval) * float64(plan.Count)
+	default:
+		plan.RequestedPPS = math.Inf(1)
+	}
+	if m.Rate > 0 && m.Rate < plan.RequestedPPS {
+		plan.RequestedPPS = m.Rate
 	}
+	plan.limit(limitsOr(m.Limits), addresses)
+	return plan, nil
 }
 
-func (m *ManySender) Start(message string, interval int, startValue int, numberOfMessages int) {
-	wg := new(sync.WaitGroup)
-	for _, address := range *m.Addresses {
-		wg.Add(1)
-		go func(address string) {
-			m.startSender(address, message, interval, startValue, numberOfMessages)
-			wg.Done()
-		}(address.String())
+func (m *ManySender) Start(message string, interval int, startValue int, numberOfMessages int) error {
+	return m.StartContext(context.Background(), message, interval, startValue, numberOfMessages)
+}
+
+// StartContext sends numberOfMessages rounds, or rounds forever if it is 0. The
+// message is a Template, with {group} and {port} filled in for each
+// destination, unless a Payload is set. The interval (milliseconds) is the
+// time between messages to the same group and port unless GroupRate or a
+// Profile is set; with a profile each profile packet is a round to every
+// group. Send errors don't stop the other groups; they are
+// collected per group and returned as GroupErrors once sending finishes. If
+// the context is done first, sending stops and the context's error is
+// returned.
+func (m *ManySender) StartContext(ctx context.Context, message string, interval int, startValue int, numberOfMessages int) error {
+	addresses, ports := m.destinations()
+	destinationCount := addresses.Count() * uint64(len(ports))
+	if destinationCount == 0 {
+		return nil
+	}
+	limits := limitsOr(m.Limits)
+	if err := limits.CheckGroups(addresses); err != nil {
+		return err
+	}
+	tmpl, err := ParseTemplate(message)
+	if err != nil {
+		return err
+	}
+	builder := &payloadBuilder{template: tmpl, payload: m.Payload, pattern: m.Pattern, size: m.MessagePadding,
+		sizes: m.Sizes, first: startValue, header: m.Header, crc: m.CRC, tos: m.TOS, sweepTTL: lowestTTL(m.TTLSweep)}
+	if err := builder.check(); err != nil {
+		return err
+	}
+	sockets, err := m.listen()
+	if err != nil {
+		return err
+	}
+	defer closeManySockets(sockets)
+	m.mu.Lock()
+	m.groupErrors = map[string]*GroupError{}
+	m.mu.Unlock()
+	m.stats.begin()
+	defer m.stats.finish()
+
+	batchSize := m.BatchSize
+	if batchSize < 1 {
+		batchSize = 1
 	}
-	wg.Wait()
+
+	gap := m.packetGap(interval, destinationCount)
+	if m.Profile != nil {
+		gap = 0
+		if m.Rate > 0 {
+			gap = rateInterval(m.Rate)
+		}
+	}
+	start := time.Now()
+	pace := newPacer(ctx, limits, start)
+	var achieved *profileLogger
+	if m.Profile != nil {
+		// each round is one profile packet to every group
+		achieved = newProfileLogger(fmt.Sprintf("each of %d destinations", destinationCount), start)
+		defer func() { achieved.done(time.Now()) }()
+	}
+	var roundAt, at time.Duration
+	packet := 0
+	ttl := m.TTL
+	for round := 0; numberOfMessages == 0 || round < numberOfMessages; round++ {
+		if m.Profile != nil && roundAt > at {
+			at = roundAt
+		}
+		if len(m.TTLSweep) > 0 && m.TTLSweep[round%len(m.TTLSweep)] != ttl {
+			// messages already queued must go out with the TTL they were
+			// built with
+			m.flush(sockets)
+			ttl = m.TTLSweep[round%len(m.TTLSweep)]
+			for _, socket := range sockets {
+				socket.packetConn.SetMulticastTTL(ttl)
+			}
+		}
+		groups := addresses.Iter()
+		for address, ok := groups.Next(); ok; address, ok = groups.Next() {
+			group := address.String()
+			for _, port := range ports {
+				if wait := time.Until(start.Add(at)); wait > 0 {
+					m.flush(sockets)
+					if err := sleepContext(ctx, wait); err != nil {
+						return err
+					}
+				} else if err := ctx.Err(); err != nil {
+					m.flush(sockets)
+					return err
+				}
+				socket := sockets[packet%len(sockets)]
+				values := TemplateValues{Counter: startValue + round, Group: group, Port: port, Source: socket.source, TTL: ttl}
+				pace.sent(1, m.queue(socket, builder, values, &net.UDPAddr{IP: address, Port: port}))
+				if len(socket.pending) >= batchSize {
+					m.write(socket)
+				}
+				packet++
+				at += gap
+				if pace.at > at {
+					at = pace.at
+				}
+			}
+		}
+		if m.Profile != nil {
+			achieved.sent(time.Now())
+			roundAt = m.Profile.Next(round+1, roundAt)
+		}
+	}
+	m.flush(sockets)
+
+	if errs := m.Errors(); len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// queue adds the message for the destination to the socket's next batch,
+// returning its size.
+func (m *ManySender) queue(socket *manySocket, builder *payloadBuilder, values TemplateValues, destination *net.UDPAddr) int {
+	n := len(socket.pending)
+	if n == len(socket.buffers) {
+		socket.buffers = append(socket.buffers, nil)
+	}
+	buf := builder.build(socket.buffers[n][:0], values)
+	socket.buffers[n] = buf
+	socket.pending = append(socket.pending, ipv4.Message{Buffers: [][]byte{buf}, Addr: destination})
+	return len(buf)
+}
+
+func (m *ManySender) flush(sockets []*manySocket) {
+	for _, socket := range sockets {
+		if len(socket.pending) > 0 {
+			m.write(socket)
+		}
+	}
+}
+
+// write sends every message queued on the socket, recording an error against
+// the group of any message that couldn't be sent.
+func (m *ManySender) write(socket *manySocket) {
+	pending := socket.pending
+	for len(pending) > 0 {
+		start := time.Now()
+		n, err := socket.packetConn.WriteBatch(pending, 0)
+		bytes := 0
+		for _, message := range pending[:n] {
+			bytes += len(message.Buffers[0])
+		}
+		m.stats.record(n, bytes, time.Since(start), err)
+		if err != nil && n < len(pending) {
+			m.recordError(pending[n].Addr.(*net.UDPAddr).IP, err)
+			n++
+		}
+		pending = pending[n:]
+	}
+	socket.pending = socket.pending[:0]
+}
+
+func (m *ManySender) recordError(address net.IP, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	key := address.String()
+	g, ok := m.groupErrors[key]
+	if !ok {
+		g = &GroupError{Address: address}
+		m.groupErrors[key] = g
+	}
+	g.Count++
+	g.Last = err
+}
+
+// Stats returns the packets, bytes and errors counted across all groups
+// since Start was called, along with the achieved rates and send call
+// latency. Per group errors are available from Errors. It is safe to call
+// while sending.
+func (m *ManySender) Stats() SendStats {
+	return m.stats.stats()
+}
+
+// Errors returns the send errors recorded so far for each group, sorted by
+// address. It is safe to call while Start is running.
+func (m *ManySender) Errors() GroupErrors {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if len(m.groupErrors) == 0 {
+		return nil
+	}
+	errs := make(GroupErrors, 0, len(m.groupErrors))
+	for _, g := range m.groupErrors {
+		errs = append(errs, *g)
+	}
+	sort.Slice(errs, func(i, j int) bool { return bytes.Compare(errs[i].Address.To16(), errs[j].Address.To16()) < 0 })
+	return errs
+}
+
+// SetLimits sets the guardrails the sender is held to in place of
+// DefaultLimits.
+func (m *ManySender) SetLimits(limits Limits) {
+	m.Limits = &limits
 }
 
 func (m *ManySender) SetMessagePadding(paddingSize int) {
@@ -79,14 +449,106 @@ func (m *ManySender) SetTOS(tos int) {
 	m.TOS = tos
 }
 
+// SetPorts sends every group to each of the ports, in place of the Port.
+// nil restores the Port.
+func (m *ManySender) SetPorts(ports []int) {
+	m.Ports = ports
+}
+
+// SetTTLSweep sends each round with the next TTL from ttls, starting again
+// once they have all been used, in place of the TTL. nil restores the fixed
+// TTL.
+func (m *ManySender) SetTTLSweep(ttls []int) {
+	m.TTLSweep = ttls
+}
+
+// SetFragmentation sets or clears DF on the datagrams sent. Only supported
+// on Linux.
+func (m *ManySender) SetFragmentation(f Fragmentation) {
+	m.Fragmentation = f
+}
+
+// SetPayload makes every group be sent the given bytes, which may be binary,
+// instead of the message text. A nil payload restores the message text.
+func (m *ManySender) SetPayload(payload []byte) {
+	m.Payload = payload
+}
+
+// SetPattern fills the space between the end of the message and the padding
+// size with the pattern instead of zeros.
+func (m *ManySender) SetPattern(pattern *Pattern) {
+	m.Pattern = pattern
+}
+
+// SetSizes chooses the size of the messages in each round from sizes instead
+// of the message padding. Every group is sent the same size in a round. nil
+// restores the padding.
+func (m *ManySender) SetSizes(sizes *Sizes) {
+	m.Sizes = sizes
+}
+
+// SetTestHeader starts every message with a TestHeader.
+func (m *ManySender) SetTestHeader(header bool) {
+	m.Header = header
+}
+
+// SetCRC ends every message with a CRC32 trailer. It also turns on the test
+// header.
+func (m *ManySender) SetCRC(crc bool) {
+	m.CRC = crc
+}
+
+// SetProfile sets the profile used to schedule rounds to every group.
+func (m *ManySender) SetProfile(profile Profile) {
+	m.Profile = profile
+}
+
+// SetBatchSize sets how many messages are handed to the kernel at once on
+// each shared socket.
+func (m *ManySender) SetBatchSize(batchSize int) {
+	m.BatchSize = batchSize
+}
+
+// SetSockets sets how many shared sockets the groups are spread across.
+func (m *ManySender) SetSockets(sockets int) {
+	m.Sockets = sockets
+}
+
+// SetRate caps the packets per second sent across all groups. 0 removes the cap.
+func (m *ManySender) SetRate(rate float64) {
+	m.Rate = rate
+}
+
+// SetGroupRate sets the packets per second sent to each group. 0 falls back
+// to the interval given to Start.
+func (m *ManySender) SetGroupRate(rate float64) {
+	m.GroupRate = rate
+}
+
+// SetLocalAddress binds the shared sockets to a local IP address, with an
+// optional source port as ip:port. A fixed port only works with one socket.
 func (m *ManySender) SetLocalAddress(address string) error {
-	if !strings.Contains(address, ":") {
-		return errors.New("Local address must contain a ':' with source port")
-	}
-	addr, err := net.ResolveUDPAddr("udp", address)
+	addr, err := ResolveLocalAddress(address)
 	if err != nil {
 		return err
 	}
 	m.LocalAddress = addr
 	return nil
 }
+
+// SetInterface sends multicast out of the named interface. An empty name
+// leaves the choice to the routing table.
+func (m *ManySender) SetInterface(name string) error {
+	ifi, err := GetInterface(name)
+	if err != nil {
+		return err
+	}
+	m.Interface = ifi
+	return nil
+}
+
+// SetLoopback sets whether multicast sent is also delivered to listeners on
+// this host. It is on by default.
+func (m *ManySender) SetLoopback(loopback bool) {
+	m.NoLoopback = !loopback
+}
@@ -0,0 +1,106 @@
+/*
+*    mcast - Command line tool and library for testing multicast traffic
+*    flows and stress testing networks and devices.
+*    Copyright (C) 2018 Will Smith
+*
+*    This program is free software: you can redistribute it and/or modify
+*    it under the terms of the GNU General Public License as published by
+*    the Free Software Foundation, either version 3 of the License, or
+*    (at your option) any later version.
+*
+*    This program is distributed in the hope that it will be useful,
+*    but WITHOUT ANY WARRANTY; without even the implied warranty of
+*    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+*    GNU General Public License for more details.
+*
+*    You should have received a copy of the GNU General Public License
+*    along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package multicast
+
+import (
+	"fmt"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestManySenderPacketGap(t *testing.T) {
+	m := &ManySender{}
+	if gap := m.packetGap(1000, 100); gap != 10*time.Millisecond {
+		t.Errorf("Expected 10ms between packets for 100 groups at 1000ms, instead got %v", gap)
+	}
+	m.SetGroupRate(10)
+	if gap := m.packetGap(1000, 100); gap != time.Millisecond {
+		t.Errorf("Expected group rate to override interval giving 1ms, instead got %v", gap)
+	}
+	m.SetRate(100)
+	if gap := m.packetGap(1000, 100); gap != 10*time.Millisecond {
+		t.Errorf("Expected global rate cap to give 10ms, instead got %v", gap)
+	}
+}
+
+func TestManySenderLoopback(t *testing.T) {
+	listener, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal("Unable to listen on loopback", err)
+	}
+	defer listener.Close()
+	port := listener.LocalAddr().(*net.UDPAddr).Port
+
+	m, err := NewManySender("127.0.0.1", 32, port, 1)
+	if err != nil {
+		t.Fatal("Problem creating many sender", err)
+	}
+	m.SetBatchSize(2)
+	if err := m.Start("message {c}", 0, 1, 3); err != nil {
+		t.Fatal("Problem sending", err)
+	}
+	if stats := m.Stats(); stats.Packets != 3 || stats.Bytes != 27 || stats.Calls != 2 {
+		t.Errorf("Expected 3 packets of 27 bytes in 2 calls, instead got %v", stats)
+	}
+
+	buf := make([]byte, 100)
+	listener.SetReadDeadline(time.Now().Add(time.Second))
+	for _, expected := range []string{"message 1", "message 2", "message 3"} {
+		n, err := listener.Read(buf)
+		if err != nil {
+			t.Fatal("Problem reading", err)
+		}
+		if string(buf[:n]) != expected {
+			t.Errorf("Expected %q, instead got %q", expected, buf[:n])
+		}
+	}
+}
+
+func TestManySenderPorts(t *testing.T) {
+	var listeners []*net.UDPConn
+	var ports []int
+	for i := 0; i < 2; i++ {
+		listener, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+		if err != nil {
+			t.Fatal("Unable to listen on loopback", err)
+		}
+		defer listener.Close()
+		listeners = append(listeners, listener)
+		ports = append(ports, listener.LocalAddr().(*net.UDPAddr).Port)
+	}
+
+	m := NewManySenderGroups([]net.IP{net.IPv4(127, 0, 0, 1)}, 0, 1)
+	m.SetPorts(ports)
+	if err := m.Start("to {port}", 0, 1, 1); err != nil {
+		t.Fatal("Problem sending", err)
+	}
+	buf := make([]byte, 100)
+	for i, listener := range listeners {
+		listener.SetReadDeadline(time.Now().Add(time.Second))
+		n, err := listener.Read(buf)
+		if err != nil {
+			t.Fatal("Problem reading", err)
+		}
+		if expected := fmt.Sprintf("to %d", ports[i]); string(buf[:n]) != expected {
+			t.Errorf("Expected %q, instead got %q", expected, buf[:n])
+		}
+	}
+}
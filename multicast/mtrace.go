@@ -0,0 +1,516 @@
+/*
+*    mcast - Command line tool and library for testing multicast traffic
+*    flows and stress testing networks and devices.
+*    Copyright (C) 2018 Will Smith
+*
+*    This program is free software: you can redistribute it and/or modify
+*    it under the terms of the GNU General Public License as published by
+*    the Free Software Foundation, either version 3 of the License, or
+*    (at your option) any later version.
+*
+*    This program is distributed in the hope that it will be useful,
+*    but WITHOUT ANY WARRANTY; without even the implied warranty of
+*    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+*    GNU General Public License for more details.
+*
+*    You should have received a copy of the GNU General Public License
+*    along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package multicast
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"strings"
+	"time"
+
+	"golang.org/x/net/ipv4"
+)
+
+// DefaultTracePort is the UDP port mtrace2 queries are sent to, as assigned
+// by IANA.
+const DefaultTracePort = 33435
+
+// DefaultTraceRouter is where a trace query is sent when the last hop router
+// isn't known: every router on the local network.
+const DefaultTraceRouter = "224.0.0.2"
+
+// Mtrace2 (RFC 8487) messages are a sequence of TLVs: a 1 byte type, a 2 byte
+// length of the value and the value. A query, request or reply starts with a
+// header TLV laid out, for IPv4, as:
+//
+//	 3 hops           1 byte, the most response blocks wanted
+//	 4 group          4 bytes
+//	 8 source         4 bytes
+//	12 client         4 bytes, address the reply is sent to
+//	16 query ID       2 bytes
+//	18 client port    2 bytes
+//
+// A request or reply follows it with a standard response block from each
+// router on the path, starting with the last hop router and working back
+// toward the source:
+//
+//	 3 MBZ               1 byte
+//	 4 arrival time      4 bytes, middle 32 bits of an NTP timestamp
+//	 8 incoming if       4 bytes
+//	12 outgoing if       4 bytes
+//	16 local address     4 bytes, of the router's outgoing interface
+//	20 remote address    4 bytes, of the upstream router
+//	24 input packets     8 bytes
+//	32 output packets    8 bytes
+//	40 total packets     8 bytes, for the source and group
+//	48 routing protocol  2 bytes
+//	50 multicast routing protocol 2 bytes
+//	52 forwarding TTL    1 byte
+//	53 S flag            1 byte, low bit
+//	54 source mask       1 byte
+//	55 forwarding code   1 byte
+const (
+	traceTypeQuery    = 0x01
+	traceTypeRequest  = 0x02
+	traceTypeReply    = 0x03
+	traceTypeResponse = 0x04
+
+	traceTLVHeaderLen = 3
+	traceHeaderLen    = 20
+	traceResponseLen  = 56
+)
+
+// TraceForwardingCode is the forwarding code a router reports for a trace,
+// saying whether and why it isn't forwarding the traffic.
+type TraceForwardingCode uint8
+
+const (
+	TraceNoError       TraceForwardingCode = 0x00
+	TraceWrongIf       TraceForwardingCode = 0x01
+	TracePruneSent     TraceForwardingCode = 0x02
+	TracePruneReceived TraceForwardingCode = 0x03
+	TraceScoped        TraceForwardingCode = 0x04
+	TraceNoRoute       TraceForwardingCode = 0x05
+	TraceWrongLastHop  TraceForwardingCode = 0x06
+	TraceNotForwarding TraceForwardingCode = 0x07
+	TraceReachedRP     TraceForwardingCode = 0x08
+	TraceRPFIf         TraceForwardingCode = 0x09
+	TraceNoMulticast   TraceForwardingCode = 0x0a
+	TraceInfoHidden    TraceForwardingCode = 0x0b
+	TraceReachedGW     TraceForwardingCode = 0x0c
+	TraceUnknownQuery  TraceForwardingCode = 0x0d
+	TraceFatalError    TraceForwardingCode = 0x80
+	TraceNoSpace       TraceForwardingCode = 0x81
+	TraceAdminProhib   TraceForwardingCode = 0x83
+)
+
+var traceForwardingNames = map[TraceForwardingCode]string{
+	TraceNoError:       "NO_ERROR",
+	TraceWrongIf:       "WRONG_IF",
+	TracePruneSent:     "PRUNE_SENT",
+	TracePruneReceived: "PRUNE_RCVD",
+	TraceScoped:        "SCOPED",
+	TraceNoRoute:       "NO_ROUTE",
+	TraceWrongLastHop:  "WRONG_LAST_HOP",
+	TraceNotForwarding: "NOT_FORWARDING",
+	TraceReachedRP:     "REACHED_RP",
+	TraceRPFIf:         "RPF_IF",
+	TraceNoMulticast:   "NO_MULTICAST",
+	TraceInfoHidden:    "INFO_HIDDEN",
+	TraceReachedGW:     "REACHED_GW",
+	TraceUnknownQuery:  "UNKNOWN_QUERY",
+	TraceFatalError:    "FATAL_ERROR",
+	TraceNoSpace:       "NO_SPACE",
+	TraceAdminProhib:   "ADMIN_PROHIB",
+}
+
+func (c TraceForwardingCode) String() string {
+	if name, ok := traceForwardingNames[c]; ok {
+		return name
+	}
+	return fmt.Sprintf("0x%02x", uint8(c))
+}
+
+// TraceHop is the response block one router adds to a trace.
+type TraceHop struct {
+	ArrivalTime              uint32 // when the query arrived, middle 32 bits of an NTP timestamp
+	IncomingInterface        uint32
+	OutgoingInterface        uint32
+	LocalAddress             net.IP // the router's address on the outgoing interface
+	RemoteAddress            net.IP // the upstream router, toward the source
+	InputPackets             uint64 // on the incoming interface
+	OutputPackets            uint64 // on the outgoing interface
+	TotalPackets             uint64 // for the source and group
+	RoutingProtocol          uint16
+	MulticastRoutingProtocol uint16
+	ForwardTTL               uint8 // TTL a packet needs to be forwarded
+	SourceSpecific           bool  // the state is for the source rather than the group
+	SourceMask               uint8
+	ForwardingCode           TraceForwardingCode
+}
+
+func (h TraceHop) String() string {
+	state := "(*,G)"
+	if h.SourceSpecific {
+		state = "(S,G)"
+	}
+	return fmt.Sprintf("%v  upstream %v  in if %d  out if %d  fwd ttl %d  %v  protocol %d/%d  pkts in %d out %d total %d  %v",
+		h.LocalAddress, h.RemoteAddress, h.IncomingInterface, h.OutgoingInterface, h.ForwardTTL, state,
+		h.RoutingProtocol, h.MulticastRoutingProtocol, h.InputPackets, h.OutputPackets, h.TotalPackets, h.ForwardingCode)
+}
+
+func (h *TraceHop) marshal(b []byte) {
+	b[0] = traceTypeResponse
+	binary.BigEndian.PutUint16(b[1:], traceResponseLen-traceTLVHeaderLen)
+	b[3] = 0
+	binary.BigEndian.PutUint32(b[4:], h.ArrivalTime)
+	binary.BigEndian.PutUint32(b[8:], h.IncomingInterface)
+	binary.BigEndian.PutUint32(b[12:], h.OutgoingInterface)
+	copy(b[16:20], ipv4Bytes(h.LocalAddress))
+	copy(b[20:24], ipv4Bytes(h.RemoteAddress))
+	binary.BigEndian.PutUint64(b[24:], h.InputPackets)
+	binary.BigEndian.PutUint64(b[32:], h.OutputPackets)
+	binary.BigEndian.PutUint64(b[40:], h.TotalPackets)
+	binary.BigEndian.PutUint16(b[48:], h.RoutingProtocol)
+	binary.BigEndian.PutUint16(b[50:], h.MulticastRoutingProtocol)
+	b[52] = h.ForwardTTL
+	b[53] = 0
+	if h.SourceSpecific {
+		b[53] = 1
+	}
+	b[54] = h.SourceMask
+	b[55] = byte(h.ForwardingCode)
+}
+
+func parseTraceHop(b []byte) TraceHop {
+	return TraceHop{
+		ArrivalTime:              binary.BigEndian.Uint32(b[4:]),
+		IncomingInterface:        binary.BigEndian.Uint32(b[8:]),
+		OutgoingInterface:        binary.BigEndian.Uint32(b[12:]),
+		LocalAddress:             net.IP(append([]byte{}, b[16:20]...)),
+		RemoteAddress:            net.IP(append([]byte{}, b[20:24]...)),
+		InputPackets:             binary.BigEndian.Uint64(b[24:]),
+		OutputPackets:            binary.BigEndian.Uint64(b[32:]),
+		TotalPackets:             binary.BigEndian.Uint64(b[40:]),
+		RoutingProtocol:          binary.BigEndian.Uint16(b[48:]),
+		MulticastRoutingProtocol: binary.BigEndian.Uint16(b[50:]),
+		ForwardTTL:               b[52],
+		SourceSpecific:           b[53]&1 != 0,
+		SourceMask:               b[54],
+		ForwardingCode:           TraceForwardingCode(b[55]),
+	}
+}
+
+// ipv4Bytes returns the 4 byte form of ip, or 0.0.0.0 if it isn't IPv4.
+func ipv4Bytes(ip net.IP) []byte {
+	if ip4 := ip.To4(); ip4 != nil {
+		return ip4
+	}
+	return net.IPv4zero.To4()
+}
+
+// TraceReply is the reply to a trace query, holding the path from the last
+// hop router back toward the source.
+type TraceReply struct {
+	Type       uint8 // the TLV type of the header, a query, request or reply
+	Hops       int   // the most response blocks the query asked for
+	Group      net.IP
+	Source     net.IP
+	Client     net.IP
+	QueryID    uint16
+	ClientPort int
+	Path       []TraceHop // one per router, starting at the last hop router
+}
+
+func (r *TraceReply) marshal() []byte {
+	b := make([]byte, traceHeaderLen+len(r.Path)*traceResponseLen)
+	b[0] = r.Type
+	binary.BigEndian.PutUint16(b[1:], traceHeaderLen-traceTLVHeaderLen)
+	b[3] = byte(r.Hops)
+	copy(b[4:8], ipv4Bytes(r.Group))
+	copy(b[8:12], ipv4Bytes(r.Source))
+	copy(b[12:16], ipv4Bytes(r.Client))
+	binary.BigEndian.PutUint16(b[16:], r.QueryID)
+	binary.BigEndian.PutUint16(b[18:], uint16(r.ClientPort))
+	for i := range r.Path {
+		r.Path[i].marshal(b[traceHeaderLen+i*traceResponseLen:])
+	}
+	return b
+}
+
+// parseTrace decodes an mtrace2 message. TLVs it doesn't understand, such as
+// augmented response blocks, are skipped.
+func parseTrace(b []byte) (*TraceReply, error) {
+	if len(b) < traceHeaderLen {
+		return nil, errors.New("mtrace2 message too short")
+	}
+	r := &TraceReply{Type: b[0]}
+	if r.Type != traceTypeQuery && r.Type != traceTypeRequest && r.Type != traceTypeReply {
+		return nil, fmt.Errorf("not an mtrace2 message, type %d", r.Type)
+	}
+	if length := int(binary.BigEndian.Uint16(b[1:])); length != traceHeaderLen-traceTLVHeaderLen {
+		return nil, fmt.Errorf("mtrace2 header length %d is not for IPv4", length)
+	}
+	r.Hops = int(b[3])
+	r.Group = net.IP(append([]byte{}, b[4:8]...))
+	r.Source = net.IP(append([]byte{}, b[8:12]...))
+	r.Client = net.IP(append([]byte{}, b[12:16]...))
+	r.QueryID = binary.BigEndian.Uint16(b[16:])
+	r.ClientPort = int(binary.BigEndian.Uint16(b[18:]))
+	for rest := b[traceHeaderLen:]; len(rest) > 0; {
+		if len(rest) < traceTLVHeaderLen {
+			return nil, errors.New("mtrace2 message has a truncated block")
+		}
+		end := traceTLVHeaderLen + int(binary.BigEndian.Uint16(rest[1:]))
+		if end > len(rest) {
+			return nil, errors.New("mtrace2 message has a truncated block")
+		}
+		if rest[0] == traceTypeResponse {
+			if end != traceResponseLen {
+				return nil, fmt.Errorf("mtrace2 response block length %d is not for IPv4", end-traceTLVHeaderLen)
+			}
+			r.Path = append(r.Path, parseTraceHop(rest))
+		}
+		rest = rest[end:]
+	}
+	return r, nil
+}
+
+// ntpShort returns the middle 32 bits of the NTP timestamp for t, the form
+// of a response block's arrival time.
+func ntpShort(t time.Time) uint32 {
+	const ntpEpochOffset = 2208988800 // seconds from 1900 to 1970
+	seconds := uint64(t.Unix()+ntpEpochOffset) & 0xffff
+	fraction := uint64(t.Nanosecond()) << 16 / 1e9
+	return uint32(seconds<<16 | fraction)
+}
+
+// Tracer traces the reverse path multicast from Source to Group takes to
+// this host, by sending an mtrace2 query to the last hop router and
+// collecting the response blocks the routers back toward the source add.
+type Tracer struct {
+	Router        *net.UDPAddr // where the query is sent, the last hop router or DefaultTraceRouter
+	Source        net.IP
+	Group         net.IP
+	Client        net.IP // address the reply is sent to, nil to use this host's address toward the router
+	InterfaceName string // interface a query to a multicast router address is sent out of
+	MaxHops       int
+	Timeout       time.Duration
+}
+
+// NewTracer creates a tracer for the source and group which sends its query
+// to DefaultTraceRouter, with up to 32 hops and a 3 second timeout.
+func NewTracer(source string, group string) (*Tracer, error) {
+	src := net.ParseIP(source).To4()
+	if src == nil {
+		return nil, fmt.Errorf("%q is not an IPv4 source address", source)
+	}
+	ip := net.ParseIP(group).To4()
+	if ip == nil || !ip.IsMulticast() {
+		return nil, fmt.Errorf("%q is not an IPv4 multicast group", group)
+	}
+	t := &Tracer{Source: src, Group: ip, MaxHops: 32, Timeout: 3 * time.Second}
+	if err := t.SetRouter(DefaultTraceRouter); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// SetRouter sets where the query is sent, given as host or host:port. The
+// port defaults to DefaultTracePort.
+func (t *Tracer) SetRouter(router string) error {
+	if _, _, err := net.SplitHostPort(router); err != nil {
+		router = net.JoinHostPort(router, fmt.Sprint(DefaultTracePort))
+	}
+	addr, err := net.ResolveUDPAddr("udp4", router)
+	if err != nil {
+		return err
+	}
+	t.Router = addr
+	return nil
+}
+
+// clientAddress returns the address replies should be sent to.
+func (t *Tracer) clientAddress(ifi *net.Interface) (net.IP, error) {
+	if t.Client != nil {
+		return t.Client, nil
+	}
+	if ifi != nil {
+		return InterfaceIPv4(ifi)
+	}
+	// connecting a UDP socket sends nothing, but picks the local address
+	conn, err := net.DialUDP("udp4", nil, t.Router)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	return conn.LocalAddr().(*net.UDPAddr).IP, nil
+}
+
+// Run sends the query and returns the reply, or an error if none arrives
+// within Timeout.
+func (t *Tracer) Run(ctx context.Context) (*TraceReply, error) {
+	ifi, err := GetInterface(t.InterfaceName)
+	if err != nil {
+		return nil, err
+	}
+	client, err := t.clientAddress(ifi)
+	if err != nil {
+		log.Println("Unable to find the address for trace replies")
+		return nil, err
+	}
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{})
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if t.Router.IP.IsMulticast() {
+		// a query to the routers on the local network goes no further
+		packetConn := ipv4.NewPacketConn(conn)
+		packetConn.SetMulticastTTL(1)
+		if ifi != nil {
+			if err := packetConn.SetMulticastInterface(ifi); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	query := TraceReply{
+		Type:       traceTypeQuery,
+		Hops:       t.MaxHops,
+		Group:      t.Group,
+		Source:     t.Source,
+		Client:     client,
+		QueryID:    uint16(time.Now().UnixNano()),
+		ClientPort: conn.LocalAddr().(*net.UDPAddr).Port,
+	}
+	if _, err := conn.WriteToUDP(query.marshal(), t.Router); err != nil {
+		log.Println("Unable to send the trace query")
+		return nil, err
+	}
+
+	deadline := time.Now().Add(t.Timeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	conn.SetReadDeadline(deadline)
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-done:
+		}
+	}()
+	buf := make([]byte, 65535)
+	for {
+		n, _, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Timeout() {
+				return nil, fmt.Errorf("no reply to the trace query within %v", t.Timeout)
+			}
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			return nil, err
+		}
+		reply, err := parseTrace(buf[:n])
+		if err != nil || reply.Type != traceTypeReply || reply.QueryID != query.QueryID {
+			continue
+		}
+		return reply, nil
+	}
+}
+
+// TraceResponder stands in for the routers on a multicast path, answering
+// every mtrace2 query with the same path. It lets traces be tested without
+// a multicast network or routers that support mtrace2.
+type TraceResponder struct {
+	Port int
+	Path []TraceHop // from the last hop router toward the source
+	conn *net.UDPConn
+}
+
+func NewTraceResponder(port int, path []TraceHop) *TraceResponder {
+	return &TraceResponder{Port: port, Path: path}
+}
+
+// Listen opens the responder's socket. Start calls it if it hasn't been
+// called already.
+func (r *TraceResponder) Listen() error {
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{Port: r.Port})
+	if err != nil {
+		log.Println("Unable to listen for trace queries")
+		return err
+	}
+	r.conn = conn
+	return nil
+}
+
+// LocalAddr returns the address the responder is listening on, once Listen
+// has been called.
+func (r *TraceResponder) LocalAddr() net.Addr {
+	return r.conn.LocalAddr()
+}
+
+// Start answers queries until the context is done, then returns nil. The
+// reply holds as much of the path as the query asked for, and is sent to
+// the client address and port in the query.
+func (r *TraceResponder) Start(ctx context.Context) error {
+	if r.conn == nil {
+		if err := r.Listen(); err != nil {
+			return err
+		}
+	}
+	conn := r.conn
+	defer conn.Close()
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-done:
+		}
+	}()
+
+	buf := make([]byte, 65535)
+	for {
+		n, src, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		query, err := parseTrace(buf[:n])
+		if err != nil || query.Type != traceTypeQuery {
+			continue
+		}
+		reply := *query
+		reply.Type = traceTypeReply
+		reply.Path = make([]TraceHop, 0, len(r.Path))
+		arrival := ntpShort(time.Now())
+		for i := 0; i < len(r.Path) && i < query.Hops; i++ {
+			hop := r.Path[i]
+			hop.ArrivalTime = arrival
+			reply.Path = append(reply.Path, hop)
+		}
+		to := &net.UDPAddr{IP: query.Client, Port: query.ClientPort}
+		if _, err := conn.WriteToUDP(reply.marshal(), to); err != nil {
+			log.Printf("Unable to send trace reply to %v for query from %v: %v\n", to, src, err)
+		}
+	}
+}
+
+// FormatTracePath returns the reverse path of a trace reply, one line per
+// router numbered back from the last hop router as 0, -1, -2 and so on.
+func FormatTracePath(r *TraceReply) string {
+	var b strings.Builder
+	for i, hop := range r.Path {
+		fmt.Fprintf(&b, "%3d  %v\n", -i, hop)
+	}
+	return b.String()
+}
@@ -0,0 +1,115 @@
+/*
+*    mcast - Command line tool and library for testing multicast traffic
+*    flows and stress testing networks and devices.
+*    Copyright (C) 2018 Will Smith
+*
+*    This program is free software: you can redistribute it and/or modify
+*    it under the terms of the GNU General Public License as published by
+*    the Free Software Foundation, either version 3 of the License, or
+*    (at your option) any later version.
+*
+*    This program is distributed in the hope that it will be useful,
+*    but WITHOUT ANY WARRANTY; without even the implied warranty of
+*    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+*    GNU General Public License for more details.
+*
+*    You should have received a copy of the GNU General Public License
+*    along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package multicast
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+)
+
+var testTracePath = []TraceHop{
+	{IncomingInterface: 3, OutgoingInterface: 2, LocalAddress: net.IPv4(192, 0, 2, 1).To4(),
+		RemoteAddress: net.IPv4(198, 51, 100, 1).To4(), InputPackets: 100, OutputPackets: 99, TotalPackets: 98,
+		RoutingProtocol: 1, MulticastRoutingProtocol: 3, ForwardTTL: 1, SourceSpecific: true, SourceMask: 32},
+	{IncomingInterface: 1, OutgoingInterface: 4, LocalAddress: net.IPv4(198, 51, 100, 1).To4(),
+		RemoteAddress: net.IPv4(203, 0, 113, 1).To4(), ForwardTTL: 1, SourceMask: 24, ForwardingCode: TraceReachedRP},
+}
+
+func TestTraceRoundTrip(t *testing.T) {
+	r := TraceReply{Type: traceTypeReply, Hops: 10, Group: net.IPv4(239, 1, 1, 50).To4(),
+		Source: net.IPv4(203, 0, 113, 9).To4(), Client: net.IPv4(192, 0, 2, 2).To4(), QueryID: 0xbeef,
+		ClientPort: 40000, Path: testTracePath}
+	b := r.marshal()
+	if len(b) != traceHeaderLen+2*traceResponseLen {
+		t.Errorf("Expected %d bytes, instead got %d", traceHeaderLen+2*traceResponseLen, len(b))
+	}
+	// an augmented response block between the standard ones is skipped
+	augmented := append(append(append([]byte{}, b[:traceHeaderLen+traceResponseLen]...), 0x05, 0, 2, 0, 0),
+		b[traceHeaderLen+traceResponseLen:]...)
+	for _, data := range [][]byte{b, augmented} {
+		parsed, err := parseTrace(data)
+		if err != nil {
+			t.Fatal("Received error parsing trace", err)
+		}
+		if !reflect.DeepEqual(*parsed, r) {
+			t.Errorf("Expected %+v, instead got %+v", r, *parsed)
+		}
+	}
+	if _, err := parseTrace(b[:len(b)-1]); err == nil {
+		t.Error("Expected error parsing a truncated response block")
+	}
+}
+
+func TestTraceForwardingCodeString(t *testing.T) {
+	if s := TraceNoSpace.String(); s != "NO_SPACE" {
+		t.Errorf("Expected NO_SPACE, instead got %v", s)
+	}
+	if s := TraceForwardingCode(0x42).String(); s != "0x42" {
+		t.Errorf("Expected 0x42, instead got %v", s)
+	}
+}
+
+func TestTraceResponder(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	r := NewTraceResponder(0, testTracePath)
+	if err := r.Listen(); err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error, 1)
+	go func() { done <- r.Start(ctx) }()
+
+	tracer, err := NewTracer("203.0.113.9", "239.1.1.50")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tracer.SetRouter(fmt.Sprintf("127.0.0.1:%d", r.LocalAddr().(*net.UDPAddr).Port)); err != nil {
+		t.Fatal(err)
+	}
+	tracer.Timeout = time.Second
+	for _, hops := range []int{32, 1} {
+		tracer.MaxHops = hops
+		reply, err := tracer.Run(ctx)
+		if err != nil {
+			t.Fatal("Received error tracing", err)
+		}
+		want := len(testTracePath)
+		if hops < want {
+			want = hops
+		}
+		if len(reply.Path) != want {
+			t.Fatalf("Expected %d hops, instead got %d", want, len(reply.Path))
+		}
+		if !reply.Path[0].LocalAddress.Equal(testTracePath[0].LocalAddress) || reply.Path[0].ArrivalTime == 0 {
+			t.Errorf("Expected the first hop from the path, instead got %+v", reply.Path[0])
+		}
+		if !reply.Source.Equal(tracer.Source) || !reply.Group.Equal(tracer.Group) {
+			t.Errorf("Expected source and group %v %v, instead got %v %v", tracer.Source, tracer.Group, reply.Source, reply.Group)
+		}
+	}
+	cancel()
+	if err := <-done; err != nil {
+		t.Error("Expected responder to stop cleanly, instead got", err)
+	}
+}
@@ -20,6 +20,7 @@
 package multicast
 
 import (
+	"errors"
 	"fmt"
 	"log"
 	"net"
@@ -36,14 +37,34 @@ type Packet struct {
 	IGMPVersion  int // 1, 2, or 3
 	Interface    *net.Interface
 	Message      []byte
-	Protocol     string // 'udp' or 'ip:2'/'ip4:2'
+	Protocol     string // 'udp', 'ip:2'/'ip4:2' for IGMP or 'ip:17'/'ip4:17' for raw UDP
 	LocalAddress *net.UDPAddr
-	udpConn      *net.UDPConn
-	packetConn   *ipv4.PacketConn
-	ipConn       net.PacketConn
-	rawConn      *ipv4.RawConn
-	padding      []byte
-	TOS          int
+	// SourceAddress and SourcePort are written into raw UDP packets, so
+	// they can be any address rather than one of this host's
+	SourceAddress net.IP
+	SourcePort    int // the destination port is used if 0
+	udpConn       *net.UDPConn
+	packetConn    *ipv4.PacketConn
+	ipConn        net.PacketConn
+	rawConn       *ipv4.RawConn
+	padding       []byte
+	TOS           int
+	// Fragmentation sets or clears DF on UDP datagrams, on Linux only
+	Fragmentation Fragmentation
+	// NoLoopback keeps multicast UDP datagrams from being sent back to
+	// local listeners
+	NoLoopback bool
+	// socket options currently applied to udpConn, so they are only set
+	// again when they change rather than before every write
+	optionsSet   bool
+	appliedTTL   int
+	appliedTOS   int
+	appliedFrag  Fragmentation
+	appliedIf    *net.Interface
+	appliedLoop  bool
+	batch        []ipv4.Message
+	rawBuf       []byte
+	batchPadding [][]byte
 }
 
 func NewPacket() *Packet {
@@ -72,13 +93,59 @@ func (p *Packet) ConnectUDP() error {
 		return err
 	}
 
-	p.udpConn, err = net.DialUDP("udp", p.LocalAddress, udpAddr)
+	localAddress := p.LocalAddress
+	if localAddress == nil && p.Interface != nil {
+		// send from the interface's address rather than the one the
+		// routing table picks for the group
+		ip, err := InterfaceIPv4(p.Interface)
+		if err != nil {
+			log.Println("Unable to use interface")
+			return err
+		}
+		localAddress = &net.UDPAddr{IP: ip}
+	}
+
+	p.udpConn, err = net.DialUDP("udp", localAddress, udpAddr)
 	if err != nil {
 		log.Println("Problem dialing UDP")
 		return err
 	}
 
 	p.packetConn = ipv4.NewPacketConn(p.udpConn)
+	p.optionsSet = false
+	return p.applyOptions()
+}
+
+// applyOptions sets the TTL, TOS, DF, multicast interface and loopback
+// socket options if they haven't been set yet or have changed since they
+// were last set.
+func (p *Packet) applyOptions() error {
+	if p.optionsSet && p.appliedTTL == p.TTL && p.appliedTOS == p.TOS && p.appliedFrag == p.Fragmentation &&
+		p.appliedIf == p.Interface && p.appliedLoop == p.NoLoopback {
+		return nil
+	}
+	p.packetConn.SetMulticastTTL(p.TTL)
+	p.packetConn.SetTOS(p.TOS)
+	if err := setFragmentation(p.udpConn, p.Fragmentation); err != nil {
+		log.Println("Unable to set DF")
+		return err
+	}
+	if p.Interface != nil {
+		if err := p.packetConn.SetMulticastInterface(p.Interface); err != nil {
+			log.Println("Unable to set multicast interface")
+			return err
+		}
+	}
+	if err := p.packetConn.SetMulticastLoopback(!p.NoLoopback); err != nil {
+		log.Println("Unable to set multicast loopback")
+		return err
+	}
+	p.appliedTTL = p.TTL
+	p.appliedTOS = p.TOS
+	p.appliedFrag = p.Fragmentation
+	p.appliedIf = p.Interface
+	p.appliedLoop = p.NoLoopback
+	p.optionsSet = true
 	return nil
 }
 
@@ -91,9 +158,10 @@ func (p *Packet) SendUDP() error {
 		}
 	}
 
-	var err error
-	p.packetConn.SetMulticastTTL(p.TTL)
-	p.packetConn.SetTOS(p.TOS)
+	err := p.applyOptions()
+	if err != nil {
+		return err
+	}
 	if len(p.padding) > 0 {
 		copy(p.padding, p.Message)
 		_, err = p.udpConn.Write(p.padding)
@@ -103,6 +171,57 @@ func (p *Packet) SendUDP() error {
 	return err
 }
 
+// SendUDPBatch sends each of the messages as its own UDP datagram, handing
+// them to the kernel as a batch. On Linux this uses sendmmsg so a whole batch
+// costs a single system call; on other platforms the messages are written one
+// at a time. Padding is applied to every message the same way as SendUDP.
+// The number of messages sent is returned along with any error.
+func (p *Packet) SendUDPBatch(messages [][]byte) (int, error) {
+	if p.udpConn == nil || p.packetConn == nil {
+		err := p.ConnectUDP()
+		if err != nil {
+			log.Println("Unable to iniate UDP connection")
+			return 0, err
+		}
+	}
+	if err := p.applyOptions(); err != nil {
+		return 0, err
+	}
+
+	for len(p.batch) < len(messages) {
+		p.batch = append(p.batch, ipv4.Message{Buffers: make([][]byte, 1)})
+	}
+	batch := p.batch[:len(messages)]
+	for i, message := range messages {
+		if len(p.padding) > 0 {
+			for len(p.batchPadding) <= i {
+				p.batchPadding = append(p.batchPadding, make([]byte, len(p.padding)))
+			}
+			copy(p.batchPadding[i], message)
+			message = p.batchPadding[i]
+		}
+		batch[i].Buffers[0] = message
+	}
+
+	sent := 0
+	for sent < len(batch) {
+		n, err := p.packetConn.WriteBatch(batch[sent:], 0)
+		sent += n
+		if err != nil {
+			return sent, err
+		}
+	}
+	return sent, nil
+}
+
+// datagramLen returns the number of payload bytes the next send will write.
+func (p *Packet) datagramLen() int {
+	if len(p.padding) > 0 {
+		return len(p.padding)
+	}
+	return len(p.Message)
+}
+
 func (p *Packet) AddressAndPort() string {
 	return fmt.Sprintf("%v:%v", p.Address, p.Port)
 }
@@ -120,6 +239,18 @@ func (p *Packet) ConnectRaw() error {
 		log.Println("probelem getting raw socket")
 		return err
 	}
+	if p.isRawUDP() {
+		if p.Interface != nil {
+			if err := p.rawConn.SetMulticastInterface(p.Interface); err != nil {
+				log.Println("Unable to set multicast interface")
+				return err
+			}
+		}
+		if err := p.rawConn.SetMulticastLoopback(!p.NoLoopback); err != nil {
+			log.Println("Unable to set multicast loopback")
+			return err
+		}
+	}
 	return nil
 }
 
@@ -139,18 +270,42 @@ func (p *Packet) SendRaw() error {
 	if p.RouterAlert {
 		options = []byte{0x94, 0x04, 0x0, 0x0}
 	}
+	protocol, payload := 2, p.Message
+	var flags ipv4.HeaderFlags
+	if p.isRawUDP() {
+		if p.SourceAddress.To4() == nil {
+			return errors.New("Raw UDP packets need an IPv4 source address")
+		}
+		protocol = 17
+		data := p.Message
+		if len(p.padding) > 0 {
+			copy(p.padding, p.Message)
+			data = p.padding
+		}
+		srcPort := p.SourcePort
+		if srcPort == 0 {
+			srcPort = p.Port
+		}
+		p.rawBuf = AppendUDP(p.rawBuf[:0], p.SourceAddress, srcPort, p.Address, p.Port, data)
+		payload = p.rawBuf
+		if p.Fragmentation == FragmentDontFragment {
+			flags = ipv4.DontFragment
+		}
+	}
 	hlen := ipv4.HeaderLen + len(options)
 	header := &ipv4.Header{
 		Version:  ipv4.Version,
 		Len:      hlen,
 		TOS:      p.TOS,
-		TotalLen: hlen + len(p.Message),
+		TotalLen: hlen + len(payload),
+		Flags:    flags,
 		TTL:      p.TTL,
-		Protocol: 2,
+		Protocol: protocol,
+		Src:      p.SourceAddress,
 		Dst:      p.Address,
 		Options:  options,
 	}
-	err := p.rawConn.WriteTo(header, p.Message, nil)
+	err := p.rawConn.WriteTo(header, payload, nil)
 	if err != nil {
 		log.Println("problem writing socket")
 		return err
@@ -158,25 +313,46 @@ func (p *Packet) SendRaw() error {
 	return nil
 }
 
+// isRaw reports whether the packet is sent over a raw IP socket rather than
+// a UDP socket.
+func (p *Packet) isRaw() bool {
+	return p.Protocol == "ip:2" || p.Protocol == "ip4:2" || p.isRawUDP()
+}
+
+// isRawUDP reports whether UDP datagrams are written over a raw IP socket,
+// with IP and UDP headers built here rather than by the kernel.
+func (p *Packet) isRawUDP() bool {
+	return p.Protocol == "ip:17" || p.Protocol == "ip4:17"
+}
+
 func (p *Packet) Send() error {
-	if p.Protocol != "ip:2" && p.Protocol != "ip4:2" {
+	if !p.isRaw() {
 		return p.SendUDP()
 	}
 	return p.SendRaw()
 }
 
+// Close closes every socket the packet has opened and returns the first
+// error encountered. The packet will reconnect if it is sent again.
 func (p *Packet) Close() error {
-	if p.udpConn != nil {
-		return p.udpConn.Close()
-	}
+	var errs []error
+	// packetConn and rawConn wrap udpConn and ipConn, so closing the wrapper
+	// closes the socket underneath as well
 	if p.packetConn != nil {
-		return p.packetConn.Close()
-	}
-	if p.ipConn != nil {
-		return p.ipConn.Close()
+		errs = append(errs, p.packetConn.Close())
+	} else if p.udpConn != nil {
+		errs = append(errs, p.udpConn.Close())
 	}
 	if p.rawConn != nil {
-		return p.rawConn.Close()
+		errs = append(errs, p.rawConn.Close())
+	} else if p.ipConn != nil {
+		errs = append(errs, p.ipConn.Close())
+	}
+	p.udpConn, p.packetConn, p.ipConn, p.rawConn = nil, nil, nil, nil
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
 	}
 	return nil
 }
@@ -0,0 +1,119 @@
+/*
+*    mcast - Command line tool and library for testing multicast traffic
+*    flows and stress testing networks and devices.
+*    Copyright (C) 2018 Will Smith
+*
+*    This program is free software: you can redistribute it and/or modify
+*    it under the terms of the GNU General Public License as published by
+*    the Free Software Foundation, either version 3 of the License, or
+*    (at your option) any later version.
+*
+*    This program is distributed in the hope that it will be useful,
+*    but WITHOUT ANY WARRANTY; without even the implied warranty of
+*    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+*    GNU General Public License for more details.
+*
+*    You should have received a copy of the GNU General Public License
+*    along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package multicast
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+// discardListener returns a UDP socket on the loopback interface that
+// packets can be sent to without being read. Packets beyond its receive
+// buffer are dropped by the kernel, which is enough for measuring the send
+// path.
+func discardListener(b *testing.B) *net.UDPConn {
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		b.Fatal("Unable to listen on loopback", err)
+	}
+	return conn
+}
+
+func benchmarkSender(b *testing.B, listener *net.UDPConn) *Sender {
+	addr := listener.LocalAddr().(*net.UDPAddr)
+	s := NewSender(addr.IP.String(), addr.Port, 1)
+	s.SetMessagePadding(64)
+	if err := s.ConnectUDP(); err != nil {
+		b.Fatal("Unable to connect sender", err)
+	}
+	return s
+}
+
+func TestSenderLoopbackZeroValue(t *testing.T) {
+	s := &Sender{}
+	s.Address = net.IPv4(127, 0, 0, 1)
+	s.Port = 5050
+	s.TTL = 1
+	if err := s.ConnectUDP(); err != nil {
+		t.Fatal("Unable to connect sender", err)
+	}
+	defer s.Close()
+	if on, err := s.packetConn.MulticastLoopback(); err != nil || !on {
+		t.Errorf("Expected loopback on for a zero value sender, instead got %v (%v)", on, err)
+	}
+	s.SetLoopback(false)
+	if err := s.applyOptions(); err != nil {
+		t.Fatal("Unable to apply options", err)
+	}
+	if on, err := s.packetConn.MulticastLoopback(); err != nil || on {
+		t.Errorf("Expected loopback off after SetLoopback(false), instead got %v (%v)", on, err)
+	}
+}
+
+// BenchmarkSendUDP measures the single packet send path. The pps metric is
+// the ceiling for one core.
+func BenchmarkSendUDP(b *testing.B) {
+	listener := discardListener(b)
+	defer listener.Close()
+	s := benchmarkSender(b, listener)
+	defer s.Close()
+	s.SetMessageText("benchmark")
+
+	b.ResetTimer()
+	start := time.Now()
+	for i := 0; i < b.N; i++ {
+		if err := s.SendUDP(); err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.ReportMetric(float64(b.N)/time.Since(start).Seconds(), "pps")
+}
+
+func benchmarkSendUDPBatch(b *testing.B, batchSize int) {
+	listener := discardListener(b)
+	defer listener.Close()
+	s := benchmarkSender(b, listener)
+	defer s.Close()
+	messages := make([][]byte, batchSize)
+	for i := range messages {
+		messages[i] = []byte("benchmark")
+	}
+
+	b.ResetTimer()
+	start := time.Now()
+	for i := 0; i < b.N; i += batchSize {
+		n := batchSize
+		if b.N-i < n {
+			n = b.N - i
+		}
+		if _, err := s.SendUDPBatch(messages[:n]); err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.ReportMetric(float64(b.N)/time.Since(start).Seconds(), "pps")
+}
+
+// BenchmarkSendUDPBatch measures the batched send path at a few batch sizes.
+// Each benchmark op is one packet, so the pps metric is directly comparable
+// with BenchmarkSendUDP.
+func BenchmarkSendUDPBatch8(b *testing.B)  { benchmarkSendUDPBatch(b, 8) }
+func BenchmarkSendUDPBatch32(b *testing.B) { benchmarkSendUDPBatch(b, 32) }
+func BenchmarkSendUDPBatch64(b *testing.B) { benchmarkSendUDPBatch(b, 64) }
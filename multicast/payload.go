@@ -0,0 +1,344 @@
+/*
+*    mcast - Command line tool and library for testing multicast traffic
+*    flows and stress testing networks and devices.
+*    Copyright (C) 2018 Will Smith
+*
+*    This program is free software: you can redistribute it and/or modify
+*    it under the terms of the GNU General Public License as published by
+*    the Free Software Foundation, either version 3 of the License, or
+*    (at your option) any later version.
+*
+*    This program is distributed in the hope that it will be useful,
+*    but WITHOUT ANY WARRANTY; without even the implied warranty of
+*    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+*    GNU General Public License for more details.
+*
+*    You should have received a copy of the GNU General Public License
+*    along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package multicast
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"hash/crc32"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// PatternKind identifies how a Pattern generates bytes.
+type PatternKind uint8
+
+const (
+	PatternZeros PatternKind = iota + 1
+	PatternIncrement
+	PatternPRBS7
+	PatternPRBS15
+	PatternPRBS31
+	PatternRandom
+)
+
+var patternNames = map[PatternKind]string{
+	PatternZeros:     "zeros",
+	PatternIncrement: "increment",
+	PatternPRBS7:     "prbs7",
+	PatternPRBS15:    "prbs15",
+	PatternPRBS31:    "prbs31",
+	PatternRandom:    "random",
+}
+
+func (k PatternKind) String() string {
+	if name, ok := patternNames[k]; ok {
+		return name
+	}
+	return fmt.Sprintf("pattern(%d)", uint8(k))
+}
+
+// Pattern generates payload bytes for a packet from its sequence number. The
+// bytes only depend on the kind, seed and sequence number, so a receiver can
+// regenerate them to check every packet independently of the others.
+type Pattern struct {
+	Kind PatternKind
+	Seed uint64 // only used by PatternRandom
+}
+
+// ParsePattern parses a pattern name: zeros, increment, prbs (the same as
+// prbs31), prbs7, prbs15, prbs31, random or random:seed=N.
+func ParsePattern(spec string) (*Pattern, error) {
+	name, params := spec, ""
+	if i := strings.Index(spec, ":"); i >= 0 {
+		name, params = spec[:i], spec[i+1:]
+	}
+	p := &Pattern{}
+	switch name {
+	case "zeros":
+		p.Kind = PatternZeros
+	case "increment":
+		p.Kind = PatternIncrement
+	case "prbs7":
+		p.Kind = PatternPRBS7
+	case "prbs15":
+		p.Kind = PatternPRBS15
+	case "prbs", "prbs31":
+		p.Kind = PatternPRBS31
+	case "random":
+		p.Kind = PatternRandom
+		if params != "" {
+			if !strings.HasPrefix(params, "seed=") {
+				return nil, fmt.Errorf("random pattern only takes a seed, as random:seed=N")
+			}
+			seed, err := strconv.ParseUint(params[len("seed="):], 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("random pattern seed: %v", err)
+			}
+			p.Seed = seed
+		}
+		return p, nil
+	default:
+		return nil, fmt.Errorf("unknown pattern %q", spec)
+	}
+	if params != "" {
+		return nil, fmt.Errorf("%v pattern doesn't take parameters", name)
+	}
+	return p, nil
+}
+
+func (p *Pattern) String() string {
+	if p.Kind == PatternRandom {
+		return fmt.Sprintf("random:seed=%d", p.Seed)
+	}
+	return p.Kind.String()
+}
+
+// Fill fills buf with the pattern for the packet with the sequence number.
+func (p *Pattern) Fill(buf []byte, seq uint64) {
+	switch p.Kind {
+	case PatternIncrement:
+		for i := range buf {
+			buf[i] = byte(seq + uint64(i))
+		}
+	case PatternPRBS7:
+		fillPRBS(buf, seq, 7, 6)
+	case PatternPRBS15:
+		fillPRBS(buf, seq, 15, 14)
+	case PatternPRBS31:
+		fillPRBS(buf, seq, 31, 28)
+	case PatternRandom:
+		// splitmix64, seeded from the pattern seed and sequence number
+		state := p.Seed ^ (seq * 0x9e3779b97f4a7c15)
+		for i := 0; i < len(buf); i += 8 {
+			state += 0x9e3779b97f4a7c15
+			z := mix64(state)
+			for j := 0; j < 8 && i+j < len(buf); j++ {
+				buf[i+j] = byte(z >> (8 * uint(j)))
+			}
+		}
+	default:
+		for i := range buf {
+			buf[i] = 0
+		}
+	}
+}
+
+// fillPRBS fills buf from a Fibonacci LFSR with the polynomial
+// x^degree + x^tap + 1, as used by the ITU-T O.150 PRBS test patterns. The
+// register is started from a non-zero state derived from the sequence
+// number.
+func fillPRBS(buf []byte, seq uint64, degree uint, tap uint) {
+	mask := uint32(1)<<degree - 1
+	state := uint32(seq*0x9e3779b97f4a7c15>>32) & mask
+	if state == 0 {
+		state = mask
+	}
+	for i := range buf {
+		var b byte
+		for bit := 0; bit < 8; bit++ {
+			next := ((state >> (degree - 1)) ^ (state >> (tap - 1))) & 1
+			state = (state<<1 | next) & mask
+			b = b<<1 | byte(next)
+		}
+		buf[i] = b
+	}
+}
+
+// mix64 is the splitmix64 output function, which scrambles the bits of a
+// counter into a well distributed value.
+func mix64(z uint64) uint64 {
+	z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+	z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+	return z ^ (z >> 31)
+}
+
+// ParseHexPayload decodes a payload given as hexadecimal. Whitespace, ':'
+// and '-' separators and a leading 0x are ignored, so the output of most hex
+// dump tools can be pasted in.
+func ParseHexPayload(s string) ([]byte, error) {
+	s = strings.TrimPrefix(strings.TrimSpace(s), "0x")
+	s = strings.Map(func(r rune) rune {
+		switch r {
+		case ' ', '\t', '\n', '\r', ':', '-':
+			return -1
+		}
+		return r
+	}, s)
+	return hex.DecodeString(s)
+}
+
+// payloadBuilder builds the datagram sent for each message. The message is
+// either a fixed binary payload or a template, and when a size is set it is
+// truncated or extended to that size, with the extension filled from the
+// pattern if there is one. The size is either fixed or chosen for each
+// message from sizes, indexed from the first counter value. With a test
+// header, the header goes before the message and an optional CRC32 trailer
+// after the fill.
+type payloadBuilder struct {
+	template *Template
+	payload  []byte
+	pattern  *Pattern
+	size     int
+	sizes    *Sizes
+	first    int
+	header   bool
+	crc      bool
+	tos      int
+	sweepTTL int // lowest TTL of the TTL sweep, 0 without one
+}
+
+// check returns an error if the sizes leave no room for the test header.
+func (b *payloadBuilder) check() error {
+	if b.sizes == nil || !(b.header || b.crc) {
+		return nil
+	}
+	least := TestHeaderLen
+	if b.crc {
+		least += crcTrailerLen
+	}
+	if b.sizes.Min() < least {
+		return fmt.Errorf("packet sizes must be at least %d bytes to hold the test header", least)
+	}
+	return nil
+}
+
+// packetSize returns the size of the message with the counter value, or 0
+// if the message isn't resized.
+func (b *payloadBuilder) packetSize(counter int) int {
+	if b.sizes != nil {
+		return b.sizes.Size(counter - b.first)
+	}
+	return b.size
+}
+
+// largest returns the size of the largest message the builder makes, taking
+// the message for values as typical when it isn't resized.
+func (b *payloadBuilder) largest(values TemplateValues) int {
+	if b.sizes != nil {
+		return b.sizes.Max()
+	}
+	return len(b.build(nil, values))
+}
+
+func (b *payloadBuilder) build(buf []byte, values TemplateValues) []byte {
+	if values.Time.IsZero() {
+		values.Time = time.Now()
+	}
+	size := b.packetSize(values.Counter)
+	if b.header || b.crc {
+		return b.buildWithHeader(buf, values, size)
+	}
+	start := len(buf)
+	buf = b.appendMessage(buf, values)
+	if size <= 0 {
+		return buf
+	}
+	if len(buf)-start >= size {
+		return buf[:start+size]
+	}
+	fillStart := len(buf)
+	buf = grow(buf, start+size)
+	b.fill(buf[fillStart:], values.Counter)
+	return buf
+}
+
+func (b *payloadBuilder) appendMessage(buf []byte, values TemplateValues) []byte {
+	if b.payload != nil {
+		return append(buf, b.payload...)
+	}
+	return b.template.AppendRender(buf, values)
+}
+
+// fill fills the padding from the pattern, or with zeros.
+func (b *payloadBuilder) fill(buf []byte, counter int) {
+	if b.pattern != nil {
+		b.pattern.Fill(buf, uint64(counter))
+		return
+	}
+	for i := range buf {
+		buf[i] = 0
+	}
+}
+
+// buildWithHeader appends a packet made up of the test header, the message,
+// the fill up to the size and the CRC32 trailer. If the size is too small
+// for the whole message, the message is cut short so the header and trailer
+// stay intact. The header records the packet's length as built, so if the
+// size is too small even for the header and trailer the packet is truncated
+// to the size and the receiver will see it as truncated.
+func (b *payloadBuilder) buildWithHeader(buf []byte, values TemplateValues, size int) []byte {
+	start := len(buf)
+	buf = grow(buf, start+TestHeaderLen)
+	buf = b.appendMessage(buf, values)
+	trailer := 0
+	if b.crc {
+		trailer = crcTrailerLen
+	}
+	if room := size - trailer; size > 0 && room >= TestHeaderLen && len(buf)-start > room {
+		buf = buf[:start+room]
+	}
+	h := TestHeader{
+		TOS:           uint8(b.tos),
+		TTL:           uint8(values.TTL),
+		SweepTTL:      uint8(b.sweepTTL),
+		PatternOffset: uint16(len(buf) - start),
+		Sequence:      uint64(values.Counter),
+		SentAt:        values.Time,
+	}
+	if b.crc {
+		h.Flags |= HeaderFlagCRC
+	}
+	length := len(buf) - start + trailer
+	if size > length {
+		length = size
+		h.Pattern = PatternZeros
+		if b.pattern != nil {
+			h.Pattern = b.pattern.Kind
+			h.Seed = b.pattern.Seed
+		}
+		fillStart := len(buf)
+		buf = grow(buf, start+length-trailer)
+		b.fill(buf[fillStart:], values.Counter)
+	}
+	h.Length = uint32(length)
+	h.Marshal(buf[start:])
+	if b.crc {
+		buf = grow(buf, len(buf)+crcTrailerLen)
+		binary.BigEndian.PutUint32(buf[len(buf)-crcTrailerLen:], crc32.ChecksumIEEE(buf[start:len(buf)-crcTrailerLen]))
+	}
+	if size > 0 && len(buf)-start > size {
+		buf = buf[:start+size]
+	}
+	return buf
+}
+
+// grow returns buf extended to length n, reusing its capacity if possible.
+// The contents of the new bytes are unspecified.
+func grow(buf []byte, n int) []byte {
+	if cap(buf) < n {
+		grown := make([]byte, len(buf), n)
+		copy(grown, buf)
+		buf = grown
+	}
+	return buf[:n]
+}
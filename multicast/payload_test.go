@@ -0,0 +1,89 @@
+/*
+*    mcast - Command line tool and library for testing multicast traffic
+*    flows and stress testing networks and devices.
+*    Copyright (C) 2018 Will Smith
+*
+*    This program is free software: you can redistribute it and/or modify
+*    it under the terms of the GNU General Public License as published by
+*    the Free Software Foundation, either version 3 of the License, or
+*    (at your option) any later version.
+*
+*    This program is distributed in the hope that it will be useful,
+*    but WITHOUT ANY WARRANTY; without even the implied warranty of
+*    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+*    GNU General Public License for more details.
+*
+*    You should have received a copy of the GNU General Public License
+*    along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package multicast
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestParseHexPayload(t *testing.T) {
+	b, err := ParseHexPayload("0xde ad:BE-ef\n00")
+	if err != nil {
+		t.Fatal("Received error for valid hex", err)
+	}
+	if !bytes.Equal(b, []byte{0xde, 0xad, 0xbe, 0xef, 0x00}) {
+		t.Errorf("Unexpected bytes % x", b)
+	}
+	if _, err := ParseHexPayload("abc"); err == nil {
+		t.Error("Expected error for odd length hex")
+	}
+}
+
+func TestPatternDeterministic(t *testing.T) {
+	for _, spec := range []string{"zeros", "increment", "prbs7", "prbs15", "prbs", "random:seed=9"} {
+		p, err := ParsePattern(spec)
+		if err != nil {
+			t.Fatalf("Received error for pattern %v: %v", spec, err)
+		}
+		a, b, c := make([]byte, 100), make([]byte, 100), make([]byte, 100)
+		p.Fill(a, 42)
+		p.Fill(b, 42)
+		p.Fill(c, 43)
+		if !bytes.Equal(a, b) {
+			t.Errorf("Pattern %v produced different bytes for the same sequence number", spec)
+		}
+		if spec != "zeros" && bytes.Equal(a, c) {
+			t.Errorf("Pattern %v produced the same bytes for different sequence numbers", spec)
+		}
+	}
+}
+
+func TestPatternPRBS7Period(t *testing.T) {
+	p := &Pattern{Kind: PatternPRBS7}
+	// 127 bytes hold the 127 bit sequence 8 times over, so the byte stream
+	// repeats every 127 bytes
+	buf := make([]byte, 254)
+	p.Fill(buf, 1)
+	if !bytes.Equal(buf[:127], buf[127:]) {
+		t.Error("PRBS7 sequence did not repeat after 127 bytes")
+	}
+	if bytes.Equal(buf[:63], buf[1:64]) {
+		t.Error("PRBS7 sequence repeated too early")
+	}
+}
+
+func TestPayloadBuilderSize(t *testing.T) {
+	tmpl, _ := ParseTemplate("msg {c}")
+	b := &payloadBuilder{template: tmpl, pattern: &Pattern{Kind: PatternIncrement}, size: 10}
+	out := b.build(nil, TemplateValues{Counter: 3})
+	expected := []byte{'m', 's', 'g', ' ', '3', 3, 4, 5, 6, 7}
+	if !bytes.Equal(out, expected) {
+		t.Errorf("Expected % x, instead got % x", expected, out)
+	}
+	b.size = 4
+	if out := b.build(nil, TemplateValues{Counter: 3}); string(out) != "msg " {
+		t.Errorf("Expected message truncated to padding size, instead got %q", out)
+	}
+	b = &payloadBuilder{template: tmpl, payload: []byte{0, 1, 2}}
+	if out := b.build(nil, TemplateValues{}); !bytes.Equal(out, []byte{0, 1, 2}) {
+		t.Errorf("Expected binary payload to replace the text, instead got % x", out)
+	}
+}
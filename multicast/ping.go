@@ -0,0 +1,391 @@
+/*
+*    mcast - Command line tool and library for testing multicast traffic
+*    flows and stress testing networks and devices.
+*    Copyright (C) 2018 Will Smith
+*
+*    This program is free software: you can redistribute it and/or modify
+*    it under the terms of the GNU General Public License as published by
+*    the Free Software Foundation, either version 3 of the License, or
+*    (at your option) any later version.
+*
+*    This program is distributed in the hope that it will be useful,
+*    but WITHOUT ANY WARRANTY; without even the implied warranty of
+*    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+*    GNU General Public License for more details.
+*
+*    You should have received a copy of the GNU General Public License
+*    along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package multicast
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"sync"
+	"time"
+
+	"golang.org/x/net/ipv4"
+)
+
+// DefaultPingPort is the UDP port a Responder listens on, the same port
+// ssmping uses.
+const DefaultPingPort = 4321
+
+// Ping messages, in the style of RFC 6450 multicast ping. The pinger sends
+// a request to the responder by unicast, naming the group it has joined, and
+// the responder answers both by unicast and by multicast to that group, on
+// the pinger's port. The layout is:
+//
+//	 0 magic "MPNG"  4 bytes
+//	 4 version       1 byte
+//	 5 type          1 byte
+//	 6 reply TTL     1 byte, the TTL the responder sent the reply with
+//	 7 reserved      1 byte
+//	 8 sequence      4 bytes
+//	12 send time     8 bytes, nanoseconds since the Unix epoch
+//	20 group         4 bytes
+const (
+	pingLen     = 24
+	pingVersion = 1
+
+	pingRequest        = 1
+	pingUnicastReply   = 2
+	pingMulticastReply = 3
+)
+
+var pingMagic = []byte("MPNG")
+
+type pingMessage struct {
+	Type     byte
+	ReplyTTL int
+	Sequence uint32
+	SentAt   time.Time
+	Group    net.IP
+}
+
+func (m *pingMessage) marshal() []byte {
+	b := make([]byte, pingLen)
+	copy(b, pingMagic)
+	b[4] = pingVersion
+	b[5] = m.Type
+	b[6] = byte(m.ReplyTTL)
+	binary.BigEndian.PutUint32(b[8:], m.Sequence)
+	binary.BigEndian.PutUint64(b[12:], uint64(m.SentAt.UnixNano()))
+	copy(b[20:24], m.Group.To4())
+	return b
+}
+
+func parsePing(b []byte) (*pingMessage, error) {
+	if len(b) < pingLen || !bytes.Equal(b[:4], pingMagic) || b[4] != pingVersion {
+		return nil, errors.New("not a ping message")
+	}
+	return &pingMessage{
+		Type:     b[5],
+		ReplyTTL: int(b[6]),
+		Sequence: binary.BigEndian.Uint32(b[8:]),
+		SentAt:   time.Unix(0, int64(binary.BigEndian.Uint64(b[12:]))),
+		Group:    net.IP(append([]byte{}, b[20:24]...)),
+	}, nil
+}
+
+// Responder answers ping requests by unicast and by multicast to the group
+// named in each request.
+type Responder struct {
+	Port          int
+	TTL           int    // TTL replies are sent with
+	InterfaceName string // interface multicast replies are sent out of
+	conn          *net.UDPConn
+}
+
+func NewResponder(port int, ttl int, interfaceName string) *Responder {
+	return &Responder{Port: port, TTL: ttl, InterfaceName: interfaceName}
+}
+
+// Listen opens the responder's socket. Start calls it if it hasn't been
+// called already.
+func (r *Responder) Listen() error {
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{Port: r.Port})
+	if err != nil {
+		log.Println("Unable to listen for ping requests")
+		return err
+	}
+	r.conn = conn
+	return nil
+}
+
+// LocalAddr returns the address the responder is listening on, once Listen
+// has been called.
+func (r *Responder) LocalAddr() net.Addr {
+	return r.conn.LocalAddr()
+}
+
+// Start answers requests until the context is done, then returns nil.
+func (r *Responder) Start(ctx context.Context) error {
+	if r.conn == nil {
+		if err := r.Listen(); err != nil {
+			return err
+		}
+	}
+	conn := r.conn
+	defer conn.Close()
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-done:
+		}
+	}()
+
+	packetConn := ipv4.NewPacketConn(conn)
+	ifi, err := GetInterface(r.InterfaceName)
+	if err != nil {
+		return err
+	}
+	if ifi != nil {
+		if err := packetConn.SetMulticastInterface(ifi); err != nil {
+			return err
+		}
+	}
+	packetConn.SetTTL(r.TTL)
+	packetConn.SetMulticastTTL(r.TTL)
+
+	buf := make([]byte, 1500)
+	for {
+		n, src, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		request, err := parsePing(buf[:n])
+		if err != nil || request.Type != pingRequest {
+			continue
+		}
+		if !request.Group.IsMulticast() {
+			log.Printf("Ignoring ping from %v for non multicast group %v\n", src, request.Group)
+			continue
+		}
+		log.Printf("Ping %d from %v for group %v\n", request.Sequence, src, request.Group)
+		reply := *request
+		reply.ReplyTTL = r.TTL
+		reply.Type = pingUnicastReply
+		if _, err := conn.WriteToUDP(reply.marshal(), src); err != nil {
+			log.Println("Unable to send unicast reply:", err)
+		}
+		reply.Type = pingMulticastReply
+		if _, err := conn.WriteToUDP(reply.marshal(), &net.UDPAddr{IP: request.Group, Port: src.Port}); err != nil {
+			log.Println("Unable to send multicast reply:", err)
+		}
+	}
+}
+
+// PingReply is one reply received by a Pinger.
+type PingReply struct {
+	Sequence  int
+	Multicast bool // the reply came by multicast rather than unicast
+	From      net.Addr
+	RTT       time.Duration
+	TTL       int // TTL the reply arrived with, -1 if unknown
+	Hops      int // routers between the responder and pinger, -1 if unknown
+	Duplicate bool
+}
+
+func (r PingReply) String() string {
+	path := "unicast"
+	if r.Multicast {
+		path = "multicast"
+	}
+	dup := ""
+	if r.Duplicate {
+		dup = " (DUP!)"
+	}
+	return fmt.Sprintf("%9v from %v: seq=%d ttl=%d hops=%d time=%v%v", path, r.From, r.Sequence, r.TTL, r.Hops,
+		r.RTT.Round(time.Microsecond), dup)
+}
+
+// PingPathStats summarises the replies received over one path.
+type PingPathStats struct {
+	Received   int           `json:"received"`
+	Duplicates int           `json:"duplicates"`
+	Loss       float64       `json:"loss"` // percent of requests not answered
+	MinRTT     time.Duration `json:"min_rtt"`
+	AvgRTT     time.Duration `json:"avg_rtt"`
+	MaxRTT     time.Duration `json:"max_rtt"`
+	Hops       int           `json:"hops"` // -1 if unknown
+}
+
+func (s PingPathStats) String() string {
+	return fmt.Sprintf("%d received, %.1f%% loss, %d duplicates, rtt min/avg/max=%v/%v/%v, hops %d",
+		s.Received, s.Loss, s.Duplicates, s.MinRTT.Round(time.Microsecond), s.AvgRTT.Round(time.Microsecond),
+		s.MaxRTT.Round(time.Microsecond), s.Hops)
+}
+
+// PingStats summarises a ping run.
+type PingStats struct {
+	Sent      int           `json:"sent"`
+	Unicast   PingPathStats `json:"unicast"`
+	Multicast PingPathStats `json:"multicast"`
+}
+
+// Pinger sends ping requests to a responder and times the unicast and
+// multicast replies. Unicast replies arriving without multicast ones mean
+// multicast isn't getting from the responder to this host; running a pinger
+// on the other host tests the other direction.
+type Pinger struct {
+	Responder     *net.UDPAddr
+	Group         net.IP
+	InterfaceName string // interface the group is joined on
+	Count         int    // requests to send, 0 to keep sending
+	Interval      time.Duration
+	Timeout       time.Duration // how long to wait for replies after the last request
+}
+
+// NewPinger creates a pinger for the responder, given as host or host:port,
+// and the group the multicast replies are sent to.
+func NewPinger(responder string, group string) (*Pinger, error) {
+	if _, _, err := net.SplitHostPort(responder); err != nil {
+		responder = net.JoinHostPort(responder, fmt.Sprint(DefaultPingPort))
+	}
+	addr, err := net.ResolveUDPAddr("udp4", responder)
+	if err != nil {
+		return nil, err
+	}
+	ip := net.ParseIP(group).To4()
+	if ip == nil || !ip.IsMulticast() {
+		return nil, fmt.Errorf("%q is not an IPv4 multicast group", group)
+	}
+	return &Pinger{Responder: addr, Group: ip, Count: 5, Interval: time.Second, Timeout: 2 * time.Second}, nil
+}
+
+// pingPath collects the replies for one path.
+type pingPath struct {
+	replies map[uint32]bool
+	stats   PingPathStats
+	total   time.Duration
+}
+
+func (p *pingPath) add(r *PingReply) {
+	if p.replies[uint32(r.Sequence)] {
+		r.Duplicate = true
+		p.stats.Duplicates++
+		return
+	}
+	p.replies[uint32(r.Sequence)] = true
+	p.stats.Received++
+	p.total += r.RTT
+	if p.stats.MinRTT == 0 || r.RTT < p.stats.MinRTT {
+		p.stats.MinRTT = r.RTT
+	}
+	if r.RTT > p.stats.MaxRTT {
+		p.stats.MaxRTT = r.RTT
+	}
+	if r.Hops >= 0 {
+		p.stats.Hops = r.Hops
+	}
+}
+
+func (p *pingPath) summary(sent int) PingPathStats {
+	s := p.stats
+	if s.Received > 0 {
+		s.AvgRTT = p.total / time.Duration(s.Received)
+	}
+	if sent > 0 {
+		s.Loss = 100 * float64(sent-s.Received) / float64(sent)
+	}
+	return s
+}
+
+// Run sends the requests, calling reply for every reply as it arrives, and
+// returns the summary once the replies to the last request have had Timeout
+// to arrive or the context is done.
+func (p *Pinger) Run(ctx context.Context, reply func(PingReply)) (PingStats, error) {
+	var stats PingStats
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{})
+	if err != nil {
+		return stats, err
+	}
+	defer conn.Close()
+	packetConn := ipv4.NewPacketConn(conn)
+	ifi, err := GetInterface(p.InterfaceName)
+	if err != nil {
+		return stats, err
+	}
+	if err := packetConn.JoinGroup(ifi, &net.UDPAddr{IP: p.Group}); err != nil {
+		log.Println("Unable to join", p.Group)
+		return stats, err
+	}
+	defer packetConn.LeaveGroup(ifi, &net.UDPAddr{IP: p.Group})
+	packetConn.SetControlMessage(ipv4.FlagTTL, true)
+
+	var mu sync.Mutex
+	unicast := &pingPath{replies: map[uint32]bool{}, stats: PingPathStats{Hops: -1}}
+	multicast := &pingPath{replies: map[uint32]bool{}, stats: PingPathStats{Hops: -1}}
+	readDone := make(chan struct{})
+	go func() {
+		defer close(readDone)
+		buf := make([]byte, 1500)
+		for {
+			n, cm, src, err := packetConn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			now := time.Now()
+			m, err := parsePing(buf[:n])
+			if err != nil || (m.Type != pingUnicastReply && m.Type != pingMulticastReply) {
+				continue
+			}
+			r := PingReply{Sequence: int(m.Sequence), Multicast: m.Type == pingMulticastReply, From: src,
+				RTT: now.Sub(m.SentAt), TTL: -1, Hops: -1}
+			if cm != nil {
+				r.TTL = cm.TTL
+				if cm.TTL > 0 && cm.TTL <= m.ReplyTTL {
+					r.Hops = m.ReplyTTL - cm.TTL
+				}
+			}
+			mu.Lock()
+			if r.Multicast {
+				multicast.add(&r)
+			} else {
+				unicast.add(&r)
+			}
+			mu.Unlock()
+			if reply != nil {
+				reply(r)
+			}
+		}
+	}()
+
+	var runErr error
+	for seq := 1; p.Count == 0 || seq <= p.Count; seq++ {
+		request := pingMessage{Type: pingRequest, Sequence: uint32(seq), SentAt: time.Now(), Group: p.Group}
+		if _, err := conn.WriteToUDP(request.marshal(), p.Responder); err != nil {
+			runErr = err
+			break
+		}
+		stats.Sent++
+		wait := p.Interval
+		if p.Count != 0 && seq == p.Count {
+			wait = p.Timeout
+		}
+		if err := sleepContext(ctx, wait); err != nil {
+			break
+		}
+	}
+	conn.Close()
+	<-readDone
+
+	mu.Lock()
+	defer mu.Unlock()
+	stats.Unicast = unicast.summary(stats.Sent)
+	stats.Multicast = multicast.summary(stats.Sent)
+	return stats, runErr
+}
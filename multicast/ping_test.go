@@ -0,0 +1,74 @@
+/*
+*    mcast - Command line tool and library for testing multicast traffic
+*    flows and stress testing networks and devices.
+*    Copyright (C) 2018 Will Smith
+*
+*    This program is free software: you can redistribute it and/or modify
+*    it under the terms of the GNU General Public License as published by
+*    the Free Software Foundation, either version 3 of the License, or
+*    (at your option) any later version.
+*
+*    This program is distributed in the hope that it will be useful,
+*    but WITHOUT ANY WARRANTY; without even the implied warranty of
+*    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+*    GNU General Public License for more details.
+*
+*    You should have received a copy of the GNU General Public License
+*    along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package multicast
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestPingMessageRoundTrip(t *testing.T) {
+	m := pingMessage{Type: pingMulticastReply, ReplyTTL: 64, Sequence: 7, SentAt: time.Unix(0, 12345), Group: net.IPv4(239, 1, 1, 50)}
+	parsed, err := parsePing(m.marshal())
+	if err != nil {
+		t.Fatal("Received error parsing ping", err)
+	}
+	if parsed.Type != m.Type || parsed.ReplyTTL != 64 || parsed.Sequence != 7 || !parsed.SentAt.Equal(m.SentAt) || !parsed.Group.Equal(m.Group) {
+		t.Errorf("Expected %+v, instead got %+v", m, parsed)
+	}
+	if _, err := parsePing([]byte("This is test number: 1")); err == nil {
+		t.Error("Expected error parsing a message that isn't a ping")
+	}
+}
+
+func TestPingResponder(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	r := NewResponder(0, 64, "")
+	if err := r.Listen(); err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error, 1)
+	go func() { done <- r.Start(ctx) }()
+
+	port := r.LocalAddr().(*net.UDPAddr).Port
+	p, err := NewPinger(fmt.Sprintf("127.0.0.1:%d", port), "239.1.1.91")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.Count, p.Interval, p.Timeout = 3, 10*time.Millisecond, 200*time.Millisecond
+	stats, err := p.Run(ctx, nil)
+	if err != nil {
+		t.Fatal("Received error pinging", err)
+	}
+	if stats.Sent != 3 || stats.Unicast.Received != 3 || stats.Unicast.Loss != 0 {
+		t.Errorf("Expected 3 unicast replies to 3 requests, instead got %+v", stats)
+	}
+	if stats.Multicast.Received == 0 {
+		t.Log("No multicast replies, multicast may not be routed on this host")
+	}
+	cancel()
+	if err := <-done; err != nil {
+		t.Error("Expected responder to stop cleanly, instead got", err)
+	}
+}
@@ -0,0 +1,354 @@
+/*
+*    mcast - Command line tool and library for testing multicast traffic
+*    flows and stress testing networks and devices.
+*    Copyright (C) 2018 Will Smith
+*
+*    This program is free software: you can redistribute it and/or modify
+*    it under the terms of the GNU General Public License as published by
+*    the Free Software Foundation, either version 3 of the License, or
+*    (at your option) any later version.
+*
+*    This program is distributed in the hope that it will be useful,
+*    but WITHOUT ANY WARRANTY; without even the implied warranty of
+*    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+*    GNU General Public License for more details.
+*
+*    You should have received a copy of the GNU General Public License
+*    along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package multicast
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"math/rand"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Profile decides when each packet of a stream is sent. Next is given the
+// number of packets already sent and the offset (from the first packet) at
+// which the previous packet was scheduled, and returns the offset at which
+// the next packet should be sent. Scheduling against offsets rather than
+// sleeping between packets keeps the achieved rate from drifting.
+type Profile interface {
+	Next(sent int, previous time.Duration) time.Duration
+	String() string
+}
+
+// ConstantProfile sends packets at a fixed rate.
+type ConstantProfile struct {
+	Rate float64 // packets per second
+}
+
+func (p *ConstantProfile) Next(sent int, previous time.Duration) time.Duration {
+	return previous + rateInterval(p.Rate)
+}
+
+func (p *ConstantProfile) String() string {
+	return fmt.Sprintf("constant:rate=%v", p.Rate)
+}
+
+// BurstProfile sends Count packets back to back every Every.
+type BurstProfile struct {
+	Count int
+	Every time.Duration
+}
+
+func (p *BurstProfile) Next(sent int, previous time.Duration) time.Duration {
+	return time.Duration(sent/p.Count) * p.Every
+}
+
+func (p *BurstProfile) String() string {
+	return fmt.Sprintf("burst:count=%d,every=%v", p.Count, p.Every)
+}
+
+// RampProfile climbs linearly from a rate of From to a rate of To over the
+// duration Over, then holds at To.
+type RampProfile struct {
+	From float64 // packets per second
+	To   float64 // packets per second
+	Over time.Duration
+}
+
+func (p *RampProfile) Next(sent int, previous time.Duration) time.Duration {
+	progress := 1.0
+	if previous < p.Over {
+		progress = float64(previous) / float64(p.Over)
+	}
+	return previous + rateInterval(p.From+(p.To-p.From)*progress)
+}
+
+func (p *RampProfile) String() string {
+	return fmt.Sprintf("ramp:from=%v,to=%v,over=%v", p.From, p.To, p.Over)
+}
+
+// StepProfile holds each of Rates for Every before moving to the next one.
+// The last rate is held once all steps have been taken.
+type StepProfile struct {
+	Rates []float64 // packets per second
+	Every time.Duration
+}
+
+func (p *StepProfile) Next(sent int, previous time.Duration) time.Duration {
+	step := int(previous / p.Every)
+	if step >= len(p.Rates) {
+		step = len(p.Rates) - 1
+	}
+	return previous + rateInterval(p.Rates[step])
+}
+
+func (p *StepProfile) String() string {
+	rates := make([]string, len(p.Rates))
+	for i, rate := range p.Rates {
+		rates[i] = strconv.FormatFloat(rate, 'f', -1, 64)
+	}
+	return fmt.Sprintf("step:rates=%v,every=%v", strings.Join(rates, "/"), p.Every)
+}
+
+// SineProfile varies the rate around Mean by up to Amplitude, completing a
+// full cycle every Period.
+type SineProfile struct {
+	Mean      float64 // packets per second
+	Amplitude float64 // packets per second
+	Period    time.Duration
+}
+
+func (p *SineProfile) Next(sent int, previous time.Duration) time.Duration {
+	phase := 2 * math.Pi * float64(previous) / float64(p.Period)
+	return previous + rateInterval(p.Mean+p.Amplitude*math.Sin(phase))
+}
+
+func (p *SineProfile) String() string {
+	return fmt.Sprintf("sine:mean=%v,amplitude=%v,period=%v", p.Mean, p.Amplitude, p.Period)
+}
+
+// PoissonProfile sends packets with exponentially distributed inter-arrival
+// times averaging Rate packets per second. It is safe to share between
+// senders.
+type PoissonProfile struct {
+	Rate float64 // packets per second
+	Seed int64
+	mu   sync.Mutex
+	rnd  *rand.Rand
+}
+
+func (p *PoissonProfile) Next(sent int, previous time.Duration) time.Duration {
+	p.mu.Lock()
+	if p.rnd == nil {
+		p.rnd = rand.New(rand.NewSource(p.Seed))
+	}
+	gap := p.rnd.ExpFloat64() / p.Rate
+	p.mu.Unlock()
+	return previous + time.Duration(gap*float64(time.Second))
+}
+
+func (p *PoissonProfile) String() string {
+	return fmt.Sprintf("poisson:rate=%v,seed=%d", p.Rate, p.Seed)
+}
+
+func rateInterval(rate float64) time.Duration {
+	return time.Duration(float64(time.Second) / rate)
+}
+
+// ParseProfile builds a Profile from a specification of the form
+// name:key=value,key=value. The supported profiles are:
+//
+//	constant:rate=100
+//	burst:count=10,every=100ms
+//	ramp:from=10,to=1000,over=30s
+//	step:rates=100/500/1000,every=5s
+//	sine:mean=500,amplitude=400,period=10s
+//	poisson:rate=1000,seed=1
+//
+// Rates are in packets per second and durations use time.ParseDuration.
+func ParseProfile(spec string) (Profile, error) {
+	name, params := spec, ""
+	if i := strings.Index(spec, ":"); i >= 0 {
+		name, params = spec[:i], spec[i+1:]
+	}
+	args := map[string]string{}
+	if params != "" {
+		for _, param := range strings.Split(params, ",") {
+			kv := strings.SplitN(param, "=", 2)
+			if len(kv) != 2 {
+				return nil, fmt.Errorf("profile parameter %q must be in key=value form", param)
+			}
+			args[strings.TrimSpace(kv[0])] = strings.TrimSpace(kv[1])
+		}
+	}
+	a := profileArgs{name: name, args: args, used: map[string]bool{}}
+
+	var p Profile
+	switch name {
+	case "constant":
+		p = &ConstantProfile{Rate: a.rate("rate")}
+	case "burst":
+		p = &BurstProfile{Count: a.count("count"), Every: a.duration("every")}
+	case "ramp":
+		p = &RampProfile{From: a.rate("from"), To: a.rate("to"), Over: a.duration("over")}
+	case "step":
+		p = &StepProfile{Rates: a.rates("rates"), Every: a.duration("every")}
+	case "sine":
+		s := &SineProfile{Mean: a.rate("mean"), Amplitude: a.float("amplitude"), Period: a.duration("period")}
+		if a.err == nil && (s.Amplitude < 0 || s.Amplitude >= s.Mean) {
+			a.err = fmt.Errorf("sine profile amplitude must be at least 0 and less than the mean")
+		}
+		p = s
+	case "poisson":
+		p = &PoissonProfile{Rate: a.rate("rate"), Seed: a.seed("seed")}
+	default:
+		return nil, fmt.Errorf("unknown profile %q", name)
+	}
+	if a.err == nil {
+		for key := range args {
+			if !a.used[key] {
+				a.err = fmt.Errorf("unknown parameter %q for %v profile", key, name)
+				break
+			}
+		}
+	}
+	if a.err != nil {
+		return nil, a.err
+	}
+	return p, nil
+}
+
+// profileArgs pulls typed parameters out of a parsed profile specification,
+// remembering the first problem it runs into.
+type profileArgs struct {
+	name string
+	args map[string]string
+	used map[string]bool
+	err  error
+}
+
+func (a *profileArgs) get(key string) (string, bool) {
+	a.used[key] = true
+	value, ok := a.args[key]
+	if !ok && a.err == nil {
+		a.err = fmt.Errorf("%v profile requires the %q parameter", a.name, key)
+	}
+	return value, ok && a.err == nil
+}
+
+func (a *profileArgs) float(key string) float64 {
+	value, ok := a.get(key)
+	if !ok {
+		return 0
+	}
+	f, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		a.err = fmt.Errorf("%v profile parameter %q: %v", a.name, key, err)
+	}
+	return f
+}
+
+func (a *profileArgs) rate(key string) float64 {
+	f := a.float(key)
+	if a.err == nil && f <= 0 {
+		a.err = fmt.Errorf("%v profile parameter %q must be greater than 0", a.name, key)
+	}
+	return f
+}
+
+func (a *profileArgs) rates(key string) []float64 {
+	value, ok := a.get(key)
+	if !ok {
+		return nil
+	}
+	var rates []float64
+	for _, r := range strings.Split(value, "/") {
+		f, err := strconv.ParseFloat(r, 64)
+		if err != nil || f <= 0 {
+			a.err = fmt.Errorf("%v profile parameter %q must be a '/' separated list of rates greater than 0", a.name, key)
+			return nil
+		}
+		rates = append(rates, f)
+	}
+	return rates
+}
+
+func (a *profileArgs) count(key string) int {
+	value, ok := a.get(key)
+	if !ok {
+		return 0
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n <= 0 {
+		a.err = fmt.Errorf("%v profile parameter %q must be a whole number greater than 0", a.name, key)
+	}
+	return n
+}
+
+func (a *profileArgs) seed(key string) int64 {
+	if _, ok := a.args[key]; !ok {
+		a.used[key] = true
+		return time.Now().UnixNano()
+	}
+	value, ok := a.get(key)
+	if !ok {
+		return 0
+	}
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		a.err = fmt.Errorf("%v profile parameter %q: %v", a.name, key, err)
+	}
+	return n
+}
+
+func (a *profileArgs) duration(key string) time.Duration {
+	value, ok := a.get(key)
+	if !ok {
+		return 0
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		a.err = fmt.Errorf("%v profile parameter %q: %v", a.name, key, err)
+	} else if d <= 0 {
+		a.err = fmt.Errorf("%v profile parameter %q must be greater than 0", a.name, key)
+	}
+	return d
+}
+
+// profileLogWindow is how often the achieved profile is logged.
+const profileLogWindow = time.Second
+
+// profileLogger logs how many packets were actually sent in each window so
+// the achieved profile can be compared with what a receiver saw.
+type profileLogger struct {
+	name        string
+	start       time.Time
+	windowStart time.Duration
+	count       int
+}
+
+func newProfileLogger(name string, start time.Time) *profileLogger {
+	return &profileLogger{name: name, start: start}
+}
+
+func (l *profileLogger) sent(at time.Time) {
+	offset := at.Sub(l.start)
+	for offset >= l.windowStart+profileLogWindow {
+		l.flush(l.windowStart + profileLogWindow)
+	}
+	l.count++
+}
+
+func (l *profileLogger) flush(end time.Duration) {
+	log.Printf("profile %v: %v-%v sent %d packets (%.1f pps)\n",
+		l.name, l.windowStart.Round(time.Millisecond), end.Round(time.Millisecond),
+		l.count, float64(l.count)/(end-l.windowStart).Seconds())
+	l.windowStart = end
+	l.count = 0
+}
+
+func (l *profileLogger) done(at time.Time) {
+	if end := at.Sub(l.start); l.count > 0 && end > l.windowStart {
+		l.flush(end)
+	}
+}
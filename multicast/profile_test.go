@@ -0,0 +1,100 @@
+/*
+*    mcast - Command line tool and library for testing multicast traffic
+*    flows and stress testing networks and devices.
+*    Copyright (C) 2018 Will Smith
+*
+*    This program is free software: you can redistribute it and/or modify
+*    it under the terms of the GNU General Public License as published by
+*    the Free Software Foundation, either version 3 of the License, or
+*    (at your option) any later version.
+*
+*    This program is distributed in the hope that it will be useful,
+*    but WITHOUT ANY WARRANTY; without even the implied warranty of
+*    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+*    GNU General Public License for more details.
+*
+*    You should have received a copy of the GNU General Public License
+*    along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package multicast
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseProfileBurst(t *testing.T) {
+	p, err := ParseProfile("burst:count=3,every=100ms")
+	if err != nil {
+		t.Fatal("Received error for valid burst profile", err)
+	}
+	expected := []time.Duration{0, 0, 0, 100 * time.Millisecond, 100 * time.Millisecond, 100 * time.Millisecond, 200 * time.Millisecond}
+	var at time.Duration
+	for i, e := range expected {
+		if at != e {
+			t.Errorf("Packet %d scheduled at %v, expected %v", i, at, e)
+		}
+		at = p.Next(i+1, at)
+	}
+}
+
+func TestParseProfileRamp(t *testing.T) {
+	p, err := ParseProfile("ramp:from=10,to=100,over=1s")
+	if err != nil {
+		t.Fatal("Received error for valid ramp profile", err)
+	}
+	if gap := p.Next(1, 0); gap != 100*time.Millisecond {
+		t.Errorf("Expected first gap of 100ms at 10pps, instead got %v", gap)
+	}
+	if gap := p.Next(1, 2*time.Second) - 2*time.Second; gap != 10*time.Millisecond {
+		t.Errorf("Expected gap of 10ms once ramp completed, instead got %v", gap)
+	}
+}
+
+func TestParseProfileStep(t *testing.T) {
+	p, err := ParseProfile("step:rates=10/100,every=1s")
+	if err != nil {
+		t.Fatal("Received error for valid step profile", err)
+	}
+	if gap := p.Next(1, 500*time.Millisecond) - 500*time.Millisecond; gap != 100*time.Millisecond {
+		t.Errorf("Expected gap of 100ms in first step, instead got %v", gap)
+	}
+	if gap := p.Next(1, 5*time.Second) - 5*time.Second; gap != 10*time.Millisecond {
+		t.Errorf("Expected last step to be held, instead got gap of %v", gap)
+	}
+}
+
+func TestParseProfilePoissonMean(t *testing.T) {
+	p, err := ParseProfile("poisson:rate=1000,seed=7")
+	if err != nil {
+		t.Fatal("Received error for valid poisson profile", err)
+	}
+	var at time.Duration
+	for i := 0; i < 10000; i++ {
+		at = p.Next(i+1, at)
+	}
+	if at < 9*time.Second || at > 11*time.Second {
+		t.Errorf("Expected 10000 packets at 1000pps to take about 10s, instead took %v", at)
+	}
+}
+
+func TestParseProfileErrors(t *testing.T) {
+	bad := []string{
+		"",
+		"zigzag:rate=1",
+		"constant",
+		"constant:rate=0",
+		"constant:rate=10,every=1s",
+		"burst:count=0,every=1s",
+		"ramp:from=1,to=2,over=soon",
+		"step:rates=10/x,every=1s",
+		"sine:mean=10,amplitude=10,period=1s",
+		"poisson:rate",
+	}
+	for _, spec := range bad {
+		if _, err := ParseProfile(spec); err == nil {
+			t.Errorf("Expected error for profile %q", spec)
+		}
+	}
+}
@@ -20,9 +20,13 @@
 package multicast
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"log"
 	"net"
-	"strings"
+	"sort"
+	"sync"
 
 	"golang.org/x/net/ipv4"
 )
@@ -41,28 +45,164 @@ func getUDPConnection(address string, port int, localInterface *net.Interface) (
 }
 
 type message struct {
-	Data []byte
-	CM   *ipv4.ControlMessage
-	Src  net.Addr
+	Data    []byte
+	CM      *ipv4.ControlMessage
+	Src     net.Addr
+	TOS     int         // TOS byte the message arrived with, -1 if unknown
+	Header  *TestHeader // nil unless the message has a test header
+	Problem error       // result of verifying a message with a test header
 }
 
 func messagePrinter(messageCh <-chan message, showData bool) {
 	for message := range messageCh {
-		if message.CM != nil {
+		if message.CM != nil && message.TOS >= 0 {
+			fmt.Printf("*Received %d bytes on %v with ttl: %v tos: %v from %v*\n",
+				len(message.Data), message.CM.Dst, message.CM.TTL, FormatTOS(message.TOS), message.Src)
+		} else if message.CM != nil {
 			fmt.Printf("*Received %d bytes on %v with ttl: %v from %v*\n",
 				len(message.Data), message.CM.Dst, message.CM.TTL, message.Src)
 		} else {
 			fmt.Printf("*Received %d bytes from %v*\n", len(message.Data), message.Src)
 		}
+		if message.Header != nil {
+			if message.Problem != nil {
+				fmt.Printf("*Test packet sequence %d corrupted: %v*\n", message.Header.Sequence, message.Problem)
+			} else {
+				fmt.Printf("*Test packet sequence %d verified*\n", message.Header.Sequence)
+			}
+			if Remarked(message.Header, message.TOS) {
+				fmt.Printf("*DSCP remarked from %v to %v*\n",
+					DSCPName(int(message.Header.TOS)>>2), DSCPName(message.TOS>>2))
+			}
+		}
 		if showData {
-			fmt.Printf("%s\n", message.Data)
+			fmt.Printf("%s\n", messageText(&message))
 			fmt.Println()
 		}
 	}
 }
 
-func receive(address string, port int, interfaceName string, showData bool, messageCh chan message) error {
-	localInterface, err := GetInterface(interfaceName)
+// messageText returns the text of a message: for a test packet only the part
+// between the header and the pattern or CRC trailer. The offsets come from
+// the packet itself, so a corrupted one can't point outside it.
+func messageText(m *message) []byte {
+	data := m.Data
+	if m.Header == nil || len(data) < TestHeaderLen {
+		return data
+	}
+	end := int(m.Header.PatternOffset)
+	if m.Header.Pattern == 0 || end < TestHeaderLen || end > len(data) {
+		end = len(data)
+		if m.Header.Flags&HeaderFlagCRC != 0 && end-crcTrailerLen >= TestHeaderLen {
+			end -= crcTrailerLen
+		}
+	}
+	return data[TestHeaderLen:end]
+}
+
+// DefaultMaxReceiveSockets is the most addresses and ports a Receiver
+// listens on unless its MaxSockets is set. Each one needs its own socket.
+const DefaultMaxReceiveSockets = 4096
+
+// Receiver listens for UDP messages on one or more addresses, printing each
+// message as it arrives and counting what it has received.
+type Receiver struct {
+	Address       string
+	Port          int
+	Ports         []int       // ports listened on in place of Port when set
+	Groups        *AddressSet // addresses listened on in place of Address when set
+	MaxSockets    int         // most addresses and ports listened on, DefaultMaxReceiveSockets if not set
+	InterfaceName string
+	ShowData      bool
+	Quiet         bool // counts messages without printing them
+	mu            sync.Mutex
+	packets       uint64
+	bytes         uint64
+	streams       map[string]*stream
+}
+
+// NewReceiver returns a Receiver for the address, which can be a list of
+// addresses in any of the forms ParseGroups takes, such as a network in CIDR
+// notation.
+func NewReceiver(address string, port int, interfaceName string, showData bool) *Receiver {
+	return &Receiver{Address: address, Port: port, InterfaceName: interfaceName, ShowData: showData}
+}
+
+// SetPorts listens on each of the ports for every address, in place of the
+// Port. nil restores the Port.
+func (r *Receiver) SetPorts(ports []int) {
+	r.Ports = ports
+}
+
+// SetGroups listens on the groups in place of the Address. nil restores the
+// Address.
+func (r *Receiver) SetGroups(groups *AddressSet) {
+	r.Groups = groups
+}
+
+// Received returns the number of messages and bytes received so far. It is
+// safe to call while the receiver is running.
+func (r *Receiver) Received() (packets uint64, bytes uint64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.packets, r.bytes
+}
+
+// Streams returns the loss and corruption seen for each stream of test
+// packets, sorted by source and group. It is safe to call while the receiver
+// is running.
+func (r *Receiver) Streams() []StreamStats {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	streams := make([]StreamStats, 0, len(r.streams))
+	for _, s := range r.streams {
+		streams = append(streams, s.snapshot())
+	}
+	sort.Slice(streams, func(i, j int) bool {
+		if streams[i].Source != streams[j].Source {
+			return streams[i].Source < streams[j].Source
+		}
+		return streams[i].Group < streams[j].Group
+	})
+	return streams
+}
+
+// count records a received message, verifying it if it has a test header.
+func (r *Receiver) count(m *message, group string) {
+	header, err := ParseTestHeader(m.Data)
+	if err == nil {
+		m.Header = header
+		m.Problem = header.Verify(m.Data)
+	}
+	if m.CM != nil && m.CM.Dst != nil {
+		group = m.CM.Dst.String()
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.packets++
+	r.bytes += uint64(len(m.Data))
+	if m.Header == nil {
+		return
+	}
+	source := ""
+	if src, ok := m.Src.(*net.UDPAddr); ok {
+		source = src.String()
+	}
+	key := source + " " + group
+	if r.streams == nil {
+		r.streams = map[string]*stream{}
+	}
+	s, ok := r.streams[key]
+	if !ok {
+		s = newStream(source, group)
+		r.streams[key] = s
+	}
+	s.received(m)
+}
+
+func (r *Receiver) receive(ctx context.Context, address string, port int, messageCh chan message) error {
+	localInterface, err := GetInterface(r.InterfaceName)
 	if err != nil {
 		return err
 	}
@@ -73,47 +213,147 @@ func receive(address string, port int, interfaceName string, showData bool, mess
 	}
 	defer udpConn.Close()
 
+	// closing the socket is the only way to interrupt a blocked read
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			udpConn.Close()
+		case <-done:
+		}
+	}()
+
 	packetConn := ipv4.NewPacketConn(udpConn)
 	defer packetConn.Close()
 	packetConn.SetControlMessage(ipv4.FlagTTL|ipv4.FlagSrc|ipv4.FlagDst|ipv4.FlagInterface, true)
-	buf := make([]byte, 2048)
+	if err := enableReceiveTOS(udpConn); err != nil {
+		log.Println("Unable to receive the TOS of messages:", err)
+	}
+	buf := make([]byte, maxUDPPayload)
+	oob := make([]byte, oobSize)
+	// the socket is bound to the port on every address, so it also gets
+	// what is sent to other groups joined on the same port
+	group := net.ParseIP(address)
+	if group != nil && !group.IsMulticast() {
+		group = nil
+	}
 
 	for {
-		n, cm, src, err := packetConn.ReadFrom(buf)
+		n, cm, tos, src, err := readMessage(udpConn, packetConn, buf, oob)
 		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
 			return err
 		}
+		if group != nil && cm != nil && cm.Dst != nil && !cm.Dst.Equal(group) {
+			continue
+		}
 		data := make([]byte, n)
 		copy(data, buf)
-		messageCh <- message{Data: data, CM: cm, Src: src}
+		m := message{Data: data, CM: cm, Src: src, TOS: tos}
+		r.count(&m, address)
+		messageCh <- m
 	}
 }
 
-// Receive will listen on the port and addresses provided for incoming UDP messages.
-// Uponn receipt of a UDP message, a message will be printed to the console.
-// If showData is true, the data contained in that message will also be printed
-// with the assumption that the data is a string.
-// address can be in CIDR notation, in which case all of the addresses falling
-// within that network will be listened on
-func Receive(address string, port int, interfaceName string, showData bool) error {
-	messageCh := make(chan message, 1000)
-	go messagePrinter(messageCh, showData)
-
-	if strings.Contains(address, "/") {
-		network, mask, err := SplitCIDR(address)
-		if err != nil {
+// Start listens until the context is done, returning nil once every socket
+// has been closed. For a single address and port a listening error is
+// returned straight away. For more, problems with individual addresses are
+// logged and the rest keep listening; an error is only returned if every
+// address failed.
+func (r *Receiver) Start(ctx context.Context) error {
+	ports := r.Ports
+	if len(ports) == 0 {
+		ports = []int{r.Port}
+	}
+	addresses := []string{r.Address}
+	groups := r.Groups
+	if groups == nil {
+		var err error
+		if groups, err = groupSet(r.Address); err != nil {
 			return err
 		}
-		ips, err := IPList(network, mask)
-		if err != nil {
-			return err
+	}
+	if groups != nil {
+		max := r.MaxSockets
+		if max <= 0 {
+			max = DefaultMaxReceiveSockets
+		}
+		if count := groups.Count() * uint64(len(ports)); count > uint64(max) {
+			return fmt.Errorf("listening on %d addresses and ports needs a socket for each, more than the limit of %d", count, max)
+		}
+		addresses = addresses[:0]
+		for it := groups.Iter(); ; {
+			ip, ok := it.Next()
+			if !ok {
+				break
+			}
+			addresses = append(addresses, ip.String())
 		}
-		for _, ip := range ips {
-			go receive(ip.String(), port, interfaceName, showData, messageCh)
+	}
+	if len(addresses) == 0 {
+		return errors.New("no addresses to listen on")
+	}
+
+	messageCh := make(chan message, 1000)
+	printerDone := make(chan struct{})
+	go func() {
+		if r.Quiet {
+			for range messageCh {
+			}
+		} else {
+			messagePrinter(messageCh, r.ShowData)
 		}
-		for { // block this method call
+		close(printerDone)
+	}()
+
+	var mu sync.Mutex
+	var lastErr error
+	failed := 0
+	wg := new(sync.WaitGroup)
+	for _, address := range addresses {
+		for _, port := range ports {
+			wg.Add(1)
+			go func(address string, port int) {
+				defer wg.Done()
+				err := r.receive(ctx, address, port, messageCh)
+				if err == nil {
+					return
+				}
+				if len(addresses)*len(ports) > 1 {
+					log.Printf("Problem receiving on %v:%d\n", address, port)
+					log.Println(err)
+				}
+				mu.Lock()
+				failed++
+				lastErr = err
+				mu.Unlock()
+			}(address, port)
 		}
 	}
+	wg.Wait()
+	close(messageCh)
+	<-printerDone
+
+	if failed == len(addresses)*len(ports) {
+		return lastErr
+	}
+	return nil
+}
+
+// Receive will listen on the port and addresses provided for incoming UDP messages.
+// Uponn receipt of a UDP message, a message will be printed to the console.
+// If showData is true, the data contained in that message will also be printed
+// with the assumption that the data is a string.
+// address can be in CIDR notation, or any of the forms ParseGroups takes, in
+// which case all of the addresses given will be listened on
+func Receive(address string, port int, interfaceName string, showData bool) error {
+	return ReceiveContext(context.Background(), address, port, interfaceName, showData)
+}
 
-	return receive(address, port, interfaceName, showData, messageCh)
+// ReceiveContext is Receive, returning once the context is done.
+func ReceiveContext(ctx context.Context, address string, port int, interfaceName string, showData bool) error {
+	return NewReceiver(address, port, interfaceName, showData).Start(ctx)
 }
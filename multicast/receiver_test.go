@@ -0,0 +1,84 @@
+/*
+*    mcast - Command line tool and library for testing multicast traffic
+*    flows and stress testing networks and devices.
+*    Copyright (C) 2018 Will Smith
+*
+*    This program is free software: you can redistribute it and/or modify
+*    it under the terms of the GNU General Public License as published by
+*    the Free Software Foundation, either version 3 of the License, or
+*    (at your option) any later version.
+*
+*    This program is distributed in the hope that it will be useful,
+*    but WITHOUT ANY WARRANTY; without even the implied warranty of
+*    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+*    GNU General Public License for more details.
+*
+*    You should have received a copy of the GNU General Public License
+*    along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package multicast
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestReceiverStopsOnCancel(t *testing.T) {
+	// find a free port for the receiver to listen on
+	probe, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal("Unable to listen on loopback", err)
+	}
+	port := probe.LocalAddr().(*net.UDPAddr).Port
+	probe.Close()
+
+	r := NewReceiver("127.0.0.1", port, "", false)
+	ctx, cancel := context.WithCancel(context.Background())
+	result := make(chan error)
+	go func() { result <- r.Start(ctx) }()
+
+	s := NewSender("127.0.0.1", port, 1)
+	defer s.Close()
+	deadline := time.Now().Add(time.Second)
+	for packets, _ := r.Received(); packets == 0; packets, _ = r.Received() {
+		if time.Now().After(deadline) {
+			t.Fatal("Receiver never received a message")
+		}
+		s.One("hello")
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	cancel()
+	select {
+	case err := <-result:
+		if err != nil {
+			t.Error("Expected no error after cancelling, instead got", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Receiver did not stop after cancelling")
+	}
+}
+
+func TestMessageTextBadPatternOffset(t *testing.T) {
+	data := make([]byte, TestHeaderLen+10)
+	h := &TestHeader{Pattern: 1, PatternOffset: 5, Length: uint32(len(data))}
+	h.Marshal(data)
+	copy(data[TestHeaderLen:], "0123456789")
+	header, err := ParseTestHeader(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := message{Data: data, Header: header, Problem: header.Verify(data), TOS: -1}
+	if text := string(messageText(&m)); text != "0123456789" {
+		t.Errorf("Expected the whole message after the header, instead got %q", text)
+	}
+
+	// the printer must not panic on it either
+	ch := make(chan message, 1)
+	ch <- m
+	close(ch)
+	messagePrinter(ch, true)
+}
@@ -0,0 +1,512 @@
+/*
+*    mcast - Command line tool and library for testing multicast traffic
+*    flows and stress testing networks and devices.
+*    Copyright (C) 2018 Will Smith
+*
+*    This program is free software: you can redistribute it and/or modify
+*    it under the terms of the GNU General Public License as published by
+*    the Free Software Foundation, either version 3 of the License, or
+*    (at your option) any later version.
+*
+*    This program is distributed in the hope that it will be useful,
+*    but WITHOUT ANY WARRANTY; without even the implied warranty of
+*    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+*    GNU General Public License for more details.
+*
+*    You should have received a copy of the GNU General Public License
+*    along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package multicast
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"sigs.k8s.io/yaml"
+)
+
+// The defaults of send steps, the same as the send command's.
+const (
+	DefaultScenarioTTL      = 50
+	DefaultScenarioInterval = 1000
+	DefaultScenarioText     = "This is test number: {c}"
+)
+
+// Duration is a time.Duration written in a scenario as a string such as
+// "1.5s", or a number of seconds.
+type Duration time.Duration
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var seconds float64
+	if err := json.Unmarshal(data, &seconds); err == nil {
+		*d = Duration(seconds * float64(time.Second))
+		return nil
+	}
+	var text string
+	if err := json.Unmarshal(data, &text); err != nil {
+		return fmt.Errorf("duration %s must be a string such as \"1.5s\" or a number of seconds", data)
+	}
+	parsed, err := time.ParseDuration(text)
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d Duration) String() string {
+	return time.Duration(d).String()
+}
+
+// Scenario is a test made of senders, receivers and joins run together,
+// each starting at its own offset, with expectations on what each one sees.
+type Scenario struct {
+	Name     string         `json:"name"`
+	Duration Duration       `json:"duration"` // steps without a duration of their own run until this is up
+	Steps    []ScenarioStep `json:"steps"`
+}
+
+// ScenarioStep is one sender, receiver, join or leave of a scenario.
+type ScenarioStep struct {
+	Name      string   `json:"name"`
+	Action    string   `json:"action"`   // send, receive, join or leave
+	Start     Duration `json:"start"`    // offset from the start of the scenario
+	Duration  Duration `json:"duration"` // how long the step runs, 0 for the scenario's duration
+	Group     string   `json:"group"`    // any of the forms ParseAddressSet takes
+	Port      string   `json:"port"`     // any of the forms ParsePorts takes
+	Interface string   `json:"interface"`
+	Join      string   `json:"join"` // the join step a leave step ends
+	// send steps
+	TTL      int         `json:"ttl"`
+	TOS      int         `json:"tos"`
+	Interval int         `json:"interval"` // milliseconds between messages to each group
+	Max      int         `json:"max"`      // messages to each group, 0 to send until the step ends
+	Text     string      `json:"text"`
+	Padding  int         `json:"padding"`
+	Size     string      `json:"size"`    // any of the forms ParseSizes takes
+	Profile  string      `json:"profile"` // any of the forms ParseProfile takes
+	Rate     float64     `json:"rate"`    // cap on packets per second across all groups
+	Header   bool        `json:"header"`
+	CRC      bool        `json:"crc"`
+	Expect   Expectation `json:"expect"`
+	groups   *AddressSet
+	ports    []int
+	sizes    *Sizes
+	profile  Profile
+}
+
+// Expectation is what a step has to see to pass. Only the fields that are
+// set are checked.
+type Expectation struct {
+	MinPackets *uint64  `json:"min_packets,omitempty"` // packets sent or received
+	MaxPackets *uint64  `json:"max_packets,omitempty"` // 0 checks a group isn't received at all
+	MaxLoss    *float64 `json:"max_loss,omitempty"`    // percent of test packets lost, for receive steps
+	MaxErrors  *uint64  `json:"max_errors,omitempty"`  // send errors, or corrupted test packets received
+}
+
+func (e Expectation) isSet() bool {
+	return e.MinPackets != nil || e.MaxPackets != nil || e.MaxLoss != nil || e.MaxErrors != nil
+}
+
+// ReadScenario reads a scenario from a file, in YAML if its extension is
+// .yaml or .yml and in JSON otherwise.
+func ReadScenario(path string) (*Scenario, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		return ParseScenarioYAML(data)
+	}
+	return ParseScenario(data)
+}
+
+// ParseScenarioYAML parses and checks a scenario written in YAML. The fields
+// have the same names as in JSON.
+func ParseScenarioYAML(data []byte) (*Scenario, error) {
+	converted, err := yaml.YAMLToJSON(data)
+	if err != nil {
+		return nil, fmt.Errorf("problem reading the YAML scenario: %v", err)
+	}
+	return ParseScenario(converted)
+}
+
+// ParseScenario parses and checks a scenario written in JSON. Unknown
+// fields are refused, so a misspelt option isn't silently ignored.
+func ParseScenario(data []byte) (*Scenario, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	s := &Scenario{}
+	if err := decoder.Decode(s); err != nil {
+		return nil, fmt.Errorf("problem reading the scenario: %v", err)
+	}
+	if err := s.check(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// check fills in the defaults of the steps and returns the first problem
+// with any of them.
+func (s *Scenario) check() error {
+	if len(s.Steps) == 0 {
+		return errors.New("the scenario has no steps")
+	}
+	if s.Duration < 0 {
+		return errors.New("the scenario duration can't be negative")
+	}
+	names := map[string]string{}
+	for i := range s.Steps {
+		step := &s.Steps[i]
+		if step.Name == "" {
+			step.Name = fmt.Sprintf("%v-%d", step.Action, i+1)
+		}
+		if _, ok := names[step.Name]; ok {
+			return fmt.Errorf("there is more than one step named %q", step.Name)
+		}
+		names[step.Name] = step.Action
+		if err := step.check(s.Duration); err != nil {
+			return fmt.Errorf("step %q: %v", step.Name, err)
+		}
+	}
+	left := map[string]bool{}
+	for _, step := range s.Steps {
+		if step.Action == "leave" {
+			if names[step.Join] != "join" {
+				return fmt.Errorf("step %q: %q isn't a join step", step.Name, step.Join)
+			}
+			left[step.Join] = true
+		}
+	}
+	for _, step := range s.Steps {
+		if step.Action == "join" && step.Duration == 0 && s.Duration == 0 && !left[step.Name] {
+			return fmt.Errorf("step %q: a join step needs a duration, the scenario one or a leave step", step.Name)
+		}
+	}
+	return nil
+}
+
+func (step *ScenarioStep) check(scenarioDuration Duration) error {
+	if step.Start < 0 || step.Duration < 0 {
+		return errors.New("start and duration can't be negative")
+	}
+	switch step.Action {
+	case "send", "receive", "join":
+	case "leave":
+		if step.Join == "" {
+			return errors.New("a leave step needs the join step it ends")
+		}
+		if step.Expect.isSet() {
+			return errors.New("a leave step has nothing to expect")
+		}
+		return nil
+	case "query":
+		return errors.New("query steps aren't supported, as queries can't be sent yet")
+	default:
+		return fmt.Errorf("unknown action %q, it must be send, receive, join or leave", step.Action)
+	}
+	var err error
+	if step.groups, err = ParseAddressSet(step.Group); err != nil {
+		return err
+	}
+	if step.groups.Count() == 0 {
+		return fmt.Errorf("%q gives no groups", step.Group)
+	}
+	if step.ports, err = ParsePorts(step.Port); err != nil {
+		return err
+	}
+	ends := step.Duration > 0 || scenarioDuration > 0
+	switch step.Action {
+	case "send":
+		if step.Expect.MaxLoss != nil {
+			return errors.New("max_loss can only be expected of receive steps")
+		}
+		if !ends && step.Max == 0 {
+			return errors.New("a send step without max needs a duration, or the scenario one")
+		}
+		if step.TTL == 0 {
+			step.TTL = DefaultScenarioTTL
+		}
+		if step.Interval == 0 {
+			step.Interval = DefaultScenarioInterval
+		}
+		if step.Text == "" {
+			step.Text = DefaultScenarioText
+		}
+		if step.Size != "" {
+			if step.sizes, err = ParseSizes(step.Size); err != nil {
+				return err
+			}
+		}
+		if step.Profile != "" {
+			if step.profile, err = ParseProfile(step.Profile); err != nil {
+				return err
+			}
+		}
+	case "receive":
+		if !ends {
+			return errors.New("a receive step needs a duration, or the scenario one")
+		}
+	case "join":
+		if step.Expect.isSet() {
+			return errors.New("a join step has nothing to expect")
+		}
+		if step.groups.Count() != 1 || len(step.ports) != 1 {
+			return errors.New("a join step takes a single group and port")
+		}
+	}
+	return nil
+}
+
+// ScenarioReport is the consolidated result of running a scenario.
+type ScenarioReport struct {
+	Name    string       `json:"name"`
+	Passed  bool         `json:"passed"`
+	Elapsed Duration     `json:"elapsed"`
+	Steps   []StepReport `json:"steps"`
+}
+
+// StepReport is the result of one step of a scenario.
+type StepReport struct {
+	Name     string          `json:"name"`
+	Action   string          `json:"action"`
+	Started  Duration        `json:"started"` // offset from the start of the scenario
+	Elapsed  Duration        `json:"elapsed"`
+	Passed   bool            `json:"passed"`
+	Error    string          `json:"error,omitempty"`
+	Failures []string        `json:"failures,omitempty"` // expectations that weren't met
+	Sent     *SendStats      `json:"sent,omitempty"`
+	Received *ReceiveSummary `json:"received,omitempty"`
+	Streams  []StreamStats   `json:"streams,omitempty"`
+}
+
+// ReceiveSummary is what a receive step saw across all of its groups.
+type ReceiveSummary struct {
+	Packets   uint64  `json:"packets"`
+	Bytes     uint64  `json:"bytes"`
+	Lost      uint64  `json:"lost"`      // test packets never received
+	Corrupted uint64  `json:"corrupted"` // test packets that failed verification
+	Loss      float64 `json:"loss"`      // percent of test packets lost
+}
+
+// Run runs every step of the scenario at its start offset, all at once,
+// and returns the report once they have all finished. Steps without a
+// duration of their own stop when the scenario's duration is up or the
+// context is done.
+func (s *Scenario) Run(ctx context.Context) *ScenarioReport {
+	if s.Duration > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(s.Duration))
+		defer cancel()
+	}
+	// a leave step ends its join step by cancelling it
+	stops := make([]context.CancelFunc, len(s.Steps))
+	contexts := make([]context.Context, len(s.Steps))
+	joins := map[string]int{}
+	for i, step := range s.Steps {
+		contexts[i], stops[i] = context.WithCancel(ctx)
+		defer stops[i]()
+		joins[step.Name] = i
+	}
+
+	start := time.Now()
+	report := &ScenarioReport{Name: s.Name, Passed: true, Steps: make([]StepReport, len(s.Steps))}
+	wg := new(sync.WaitGroup)
+	for i := range s.Steps {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			step := &s.Steps[i]
+			result := &report.Steps[i]
+			result.Name = step.Name
+			result.Action = step.Action
+			if err := sleepContext(ctx, time.Until(start.Add(time.Duration(step.Start)))); err != nil {
+				result.Error = "the scenario ended before the step started"
+				return
+			}
+			result.Started = Duration(time.Since(start))
+			stepCtx := contexts[i]
+			if step.Duration > 0 {
+				var cancel context.CancelFunc
+				stepCtx, cancel = context.WithTimeout(stepCtx, time.Duration(step.Duration))
+				defer cancel()
+			}
+			if step.Action == "leave" {
+				stops[joins[step.Join]]()
+			} else if err := step.run(stepCtx, result); err != nil && stepCtx.Err() == nil {
+				result.Error = err.Error()
+			}
+			result.Elapsed = Duration(time.Since(start)) - result.Started
+			result.Failures = step.Expect.failures(result)
+			result.Passed = result.Error == "" && len(result.Failures) == 0
+		}(i)
+	}
+	wg.Wait()
+	report.Elapsed = Duration(time.Since(start))
+	for _, step := range report.Steps {
+		report.Passed = report.Passed && step.Passed
+	}
+	return report
+}
+
+// run runs a send, receive or join step until it ends, recording what it
+// saw in the report.
+func (step *ScenarioStep) run(ctx context.Context, result *StepReport) error {
+	switch step.Action {
+	case "send":
+		return step.send(ctx, result)
+	case "receive":
+		r := NewReceiver(step.Group, step.ports[0], step.Interface, false)
+		r.SetGroups(step.groups)
+		r.SetPorts(step.ports)
+		r.Quiet = true
+		err := r.Start(ctx)
+		summary := &ReceiveSummary{}
+		summary.Packets, summary.Bytes = r.Received()
+		result.Streams = r.Streams()
+		var received uint64
+		for _, stream := range result.Streams {
+			received += stream.Received
+			summary.Lost += stream.Lost
+			summary.Corrupted += stream.Corrupted
+		}
+		if received+summary.Lost > 0 {
+			summary.Loss = float64(summary.Lost) * 100 / float64(received+summary.Lost)
+		}
+		result.Received = summary
+		return err
+	case "join":
+		return JoinContext(ctx, step.groups.At(0).String(), step.ports[0], step.Interface)
+	}
+	return nil
+}
+
+// send sends as the send command would, from a Sender for a single group
+// and port or a ManySender for more.
+func (step *ScenarioStep) send(ctx context.Context, result *StepReport) error {
+	var err error
+	if step.groups.Count()*uint64(len(step.ports)) == 1 {
+		s := NewSender(step.groups.At(0).String(), step.ports[0], step.TTL)
+		s.SetTOS(step.TOS)
+		s.SetMessagePadding(step.Padding)
+		if step.sizes != nil {
+			s.SetSizes(step.sizes)
+		}
+		s.SetProfile(step.profile)
+		s.SetTestHeader(step.Header)
+		s.SetCRC(step.CRC)
+		if step.Rate > 0 {
+			// a single sender has no rate of its own, so hold its ceiling to it
+			limits := DefaultLimits
+			if limits.MaxPPS == 0 || step.Rate < limits.MaxPPS {
+				limits.MaxPPS = step.Rate
+			}
+			s.SetLimits(limits)
+		}
+		if err := s.SetInterface(step.Interface); err != nil {
+			return err
+		}
+		err = s.MaxContext(ctx, step.Text, step.Interval, 1, step.Max)
+		s.Close()
+		stats := s.Stats()
+		result.Sent = &stats
+	} else {
+		m := NewManySenderSet(step.groups, step.ports[0], step.TTL)
+		if len(step.ports) > 1 {
+			m.SetPorts(step.ports)
+		}
+		m.SetTOS(step.TOS)
+		m.SetMessagePadding(step.Padding)
+		if step.sizes != nil {
+			m.SetSizes(step.sizes)
+		}
+		m.SetProfile(step.profile)
+		m.SetRate(step.Rate)
+		m.SetTestHeader(step.Header)
+		m.SetCRC(step.CRC)
+		if err := m.SetInterface(step.Interface); err != nil {
+			return err
+		}
+		err = m.StartContext(ctx, step.Text, step.Interval, 1, step.Max)
+		stats := m.Stats()
+		result.Sent = &stats
+	}
+	return err
+}
+
+// failures returns the expectations the step's result doesn't meet.
+func (e Expectation) failures(result *StepReport) []string {
+	var packets, errs uint64
+	switch {
+	case result.Sent != nil:
+		packets, errs = result.Sent.Packets, result.Sent.Errors
+	case result.Received != nil:
+		packets, errs = result.Received.Packets, result.Received.Corrupted
+	default:
+		return nil
+	}
+	var failures []string
+	if e.MinPackets != nil && packets < *e.MinPackets {
+		failures = append(failures, fmt.Sprintf("expected at least %d packets, instead got %d", *e.MinPackets, packets))
+	}
+	if e.MaxPackets != nil && packets > *e.MaxPackets {
+		failures = append(failures, fmt.Sprintf("expected at most %d packets, instead got %d", *e.MaxPackets, packets))
+	}
+	if e.MaxErrors != nil && errs > *e.MaxErrors {
+		failures = append(failures, fmt.Sprintf("expected at most %d errors, instead got %d", *e.MaxErrors, errs))
+	}
+	if e.MaxLoss != nil && result.Received != nil {
+		if len(result.Streams) == 0 {
+			failures = append(failures, "expected a loss figure, but no test packets were received; send with header")
+		} else if result.Received.Loss > *e.MaxLoss {
+			failures = append(failures, fmt.Sprintf("expected at most %.2f%% loss, instead got %.2f%%", *e.MaxLoss, result.Received.Loss))
+		}
+	}
+	return failures
+}
+
+func passOrFail(passed bool) string {
+	if passed {
+		return "PASS"
+	}
+	return "FAIL"
+}
+
+func (r *ScenarioReport) String() string {
+	lines := []string{fmt.Sprintf("%v %v (%v)", passOrFail(r.Passed), r.Name, time.Duration(r.Elapsed).Round(time.Millisecond))}
+	for _, step := range r.Steps {
+		lines = append(lines, fmt.Sprintf("  %v %v %v at %v for %v", passOrFail(step.Passed), step.Action, step.Name,
+			time.Duration(step.Started).Round(time.Millisecond), time.Duration(step.Elapsed).Round(time.Millisecond)))
+		if step.Sent != nil {
+			lines = append(lines, fmt.Sprintf("      sent: %v", step.Sent))
+		}
+		if r := step.Received; r != nil {
+			lines = append(lines, fmt.Sprintf("      received: packets=%d bytes=%d lost=%d (%.2f%%) corrupted=%d",
+				r.Packets, r.Bytes, r.Lost, r.Loss, r.Corrupted))
+		}
+		for _, stream := range step.Streams {
+			lines = append(lines, fmt.Sprintf("      %v", stream))
+		}
+		if step.Error != "" {
+			lines = append(lines, fmt.Sprintf("      error: %v", step.Error))
+		}
+		for _, failure := range step.Failures {
+			lines = append(lines, fmt.Sprintf("      %v", failure))
+		}
+	}
+	return strings.Join(lines, "\n")
+}
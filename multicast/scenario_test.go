@@ -0,0 +1,182 @@
+/*
+*    mcast - Command line tool and library for testing multicast traffic
+*    flows and stress testing networks and devices.
+*    Copyright (C) 2018 Will Smith
+*
+*    This program is free software: you can redistribute it and/or modify
+*    it under the terms of the GNU General Public License as published by
+*    the Free Software Foundation, either version 3 of the License, or
+*    (at your option) any later version.
+*
+*    This program is distributed in the hope that it will be useful,
+*    but WITHOUT ANY WARRANTY; without even the implied warranty of
+*    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+*    GNU General Public License for more details.
+*
+*    You should have received a copy of the GNU General Public License
+*    along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package multicast
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseScenario(t *testing.T) {
+	s, err := ParseScenario([]byte(`{"duration": "2s", "steps": [
+		{"action": "receive", "group": "239.1.1.1", "port": "5000"},
+		{"action": "send", "group": "239.1.1.1", "port": "5000", "start": 0.5}
+	]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	send := s.Steps[1]
+	if send.Name != "send-2" || time.Duration(send.Start) != 500*time.Millisecond {
+		t.Errorf("Expected send-2 starting at 500ms, instead got %v at %v", send.Name, send.Start)
+	}
+	if send.TTL != DefaultScenarioTTL || send.Interval != DefaultScenarioInterval || send.Text != DefaultScenarioText {
+		t.Errorf("Expected the send defaults, instead got ttl=%d interval=%d text=%q", send.TTL, send.Interval, send.Text)
+	}
+
+	if _, err := ParseScenario([]byte(`{"steps": [
+		{"name": "member", "action": "join", "group": "239.1.1.1", "port": "5000"},
+		{"action": "leave", "join": "member", "start": 1}
+	]}`)); err != nil {
+		t.Errorf("Expected a join ended by a leave step to be accepted, instead got %v", err)
+	}
+}
+
+func TestReadScenarioYAML(t *testing.T) {
+	dir, err := ioutil.TempDir("", "scenario")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	name := filepath.Join(dir, "scenario.yml")
+	yaml := `duration: 2s
+steps:
+  - name: rx
+    action: receive
+    group: 239.1.1.1-10
+    port: "5000"
+    expect:
+      min_packets: 20
+  - action: send
+    group: 239.1.1.1-10
+    port: "5000"
+    start: 0.5
+    header: true
+`
+	if err := ioutil.WriteFile(name, []byte(yaml), 0644); err != nil {
+		t.Fatal(err)
+	}
+	s, err := ReadScenario(name)
+	if err != nil {
+		t.Fatal("Received error reading a YAML scenario", err)
+	}
+	rx, send := s.Steps[0], s.Steps[1]
+	if rx.Expect.MinPackets == nil || *rx.Expect.MinPackets != 20 || rx.groups.Count() != 10 {
+		t.Errorf("Expected rx to expect 20 packets on 10 groups, instead got %v", rx)
+	}
+	if send.Name != "send-2" || time.Duration(send.Start) != 500*time.Millisecond || !send.Header {
+		t.Errorf("Expected send-2 starting at 500ms with a header, instead got %v at %v", send.Name, send.Start)
+	}
+
+	if _, err := ParseScenarioYAML([]byte("steps:\n  - action: send\n    intervall: 5\n")); err == nil || !strings.Contains(err.Error(), "unknown field") {
+		t.Errorf("Expected an error about an unknown field, instead got %v", err)
+	}
+	if err := ioutil.WriteFile(name, []byte("steps: [\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ReadScenario(name); err == nil || !strings.Contains(err.Error(), "YAML") {
+		t.Errorf("Expected an error about the YAML, instead got %v", err)
+	}
+}
+
+func TestParseScenarioErrors(t *testing.T) {
+	for scenario, problem := range map[string]string{
+		`{"steps": []}`:                    "no steps",
+		`{"steps": [{"action": "query"}]}`: "query steps",
+		`{"steps": [{"action": "send", "group": "239.1.1.1", "port": "5000"}]}`:                                              "needs a duration",
+		`{"steps": [{"action": "receive", "group": "239.1.1.1", "port": "5000"}]}`:                                           "needs a duration",
+		`{"steps": [{"action": "leave", "join": "nope"}]}`:                                                                   "isn't a join step",
+		`{"steps": [{"action": "join", "group": "239.1.1.1", "port": "5000"}]}`:                                              "a leave step",
+		`{"steps": [{"action": "send", "group": "239.1.1.1", "port": "5000", "max": 1, "intervall": 5}]}`:                    "unknown field",
+		`{"duration": "1s", "steps": [{"action": "send", "group": "239.1.1.1", "port": "5000", "expect": {"max_loss": 1}}]}`: "max_loss",
+	} {
+		_, err := ParseScenario([]byte(scenario))
+		if err == nil || !strings.Contains(err.Error(), problem) {
+			t.Errorf("Expected an error about %q for %v, instead got %v", problem, scenario, err)
+		}
+	}
+}
+
+func TestExpectationFailures(t *testing.T) {
+	min, max, loss := uint64(10), uint64(0), 1.0
+	e := Expectation{MinPackets: &min, MaxErrors: &max, MaxLoss: &loss}
+	result := &StepReport{
+		Received: &ReceiveSummary{Packets: 5, Corrupted: 1, Lost: 5, Loss: 50},
+		Streams:  []StreamStats{{Received: 5, Lost: 5}},
+	}
+	if failures := e.failures(result); len(failures) != 3 {
+		t.Errorf("Expected 3 failures, instead got %v", failures)
+	}
+	result.Sent = &SendStats{Packets: 10}
+	result.Received = nil
+	if failures := e.failures(result); len(failures) != 0 {
+		t.Errorf("Expected no failures, instead got %v", failures)
+	}
+}
+
+func TestScenarioRun(t *testing.T) {
+	probe, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal("Unable to listen on loopback", err)
+	}
+	port := probe.LocalAddr().(*net.UDPAddr).Port
+	probe.Close()
+
+	s, err := ParseScenario([]byte(fmt.Sprintf(`{"name": "loopback", "duration": "500ms", "steps": [
+		{"name": "rx", "action": "receive", "group": "127.0.0.1", "port": "%d", "expect": {"min_packets": 3, "max_loss": 0}},
+		{"name": "tx", "action": "send", "group": "127.0.0.1", "port": "%d", "start": "100ms", "interval": 10, "max": 3, "header": true}
+	]}`, port, port)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	report := s.Run(context.Background())
+	if !report.Passed {
+		t.Errorf("Expected the scenario to pass, instead got\n%v", report)
+	}
+	if sent := report.Steps[1].Sent; sent == nil || sent.Packets != 3 {
+		t.Errorf("Expected 3 packets sent, instead got %v", sent)
+	}
+}
+
+func TestScenarioSendRate(t *testing.T) {
+	probe, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal("Unable to listen on loopback", err)
+	}
+	defer probe.Close()
+	port := probe.LocalAddr().(*net.UDPAddr).Port
+
+	s, err := ParseScenario([]byte(fmt.Sprintf(`{"duration": "500ms", "steps": [
+		{"name": "tx", "action": "send", "group": "127.0.0.1", "port": "%d", "interval": 1, "rate": 20}
+	]}`, port)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	report := s.Run(context.Background())
+	if sent := report.Steps[0].Sent; sent == nil || sent.Packets == 0 || sent.Packets > 15 {
+		t.Errorf("Expected about 10 packets sent at 20 a second, instead got %v", sent)
+	}
+}
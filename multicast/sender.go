@@ -20,15 +20,29 @@
 package multicast
 
 import (
-	"errors"
-	"fmt"
+	"context"
+	"math"
 	"net"
-	"strings"
 	"time"
 )
 
 type Sender struct {
 	Packet
+	Profile   Profile
+	BatchSize int
+	Payload   []byte      // sent instead of the message text when set
+	Pattern   *Pattern    // fills padding beyond the message when set
+	Sizes     *Sizes      // chooses the size of each message instead of the padding
+	TTLSweep  []int       // TTLs cycled through, one per message, instead of TTL
+	Sources   *AddressSet // forged source addresses every message is sent from, as raw UDP
+	Header    bool        // start every message with a TestHeader
+	CRC       bool        // end every message with a CRC32 trailer, implies Header
+	Limits    *Limits     // guardrails on what is sent, DefaultLimits if not set
+	stats     sendCounter
+	pace      *pacer // holds sending to the limits while Max runs
+	onePace   *pacer // holds One calls to the limits between them
+	checked   net.IP // the address last passed by CheckGroup
+	checkedBy Limits // the limits that passed it
 }
 
 func NewSender(address string, port int, ttl int) *Sender {
@@ -41,13 +55,31 @@ func NewSender(address string, port int, ttl int) *Sender {
 
 func (s *Sender) SetMessagePadding(paddingSize int) {
 	s.padding = make([]byte, paddingSize)
+	s.batchPadding = nil
+	s.Sizes = nil
 }
 
+// SetSizes makes Max and Forever choose the size of each message from sizes,
+// truncating or filling the message as the padding does. It replaces any
+// message padding; nil sends messages at their own length.
+func (s *Sender) SetSizes(sizes *Sizes) {
+	s.Sizes = sizes
+	s.padding = nil
+	s.batchPadding = nil
+}
+
+// SetBatchSize makes Max and Forever hand messages to the kernel in batches
+// of the given size using SendUDPBatch. The interval is then slept between
+// batches rather than between messages. Batching is not used for raw
+// packets or when a profile is set.
+func (s *Sender) SetBatchSize(batchSize int) {
+	s.BatchSize = batchSize
+}
+
+// SetLocalAddress binds the sender to a local IP address, with an optional
+// source port as ip:port.
 func (s *Sender) SetLocalAddress(address string) error {
-	if !strings.Contains(address, ":") {
-		return errors.New("Local address must contain a ':' with source port")
-	}
-	addr, err := net.ResolveUDPAddr("udp", address)
+	addr, err := ResolveLocalAddress(address)
 	if err != nil {
 		return err
 	}
@@ -55,39 +87,414 @@ func (s *Sender) SetLocalAddress(address string) error {
 	return nil
 }
 
+// SetInterface sends multicast out of the named interface, from its address
+// unless a local address is set. An empty name leaves the choice to the
+// routing table.
+func (s *Sender) SetInterface(name string) error {
+	ifi, err := GetInterface(name)
+	if err != nil {
+		return err
+	}
+	s.Interface = ifi
+	return nil
+}
+
+// SetLoopback sets whether multicast sent is also delivered to listeners on
+// this host. It is on by default.
+func (s *Sender) SetLoopback(loopback bool) {
+	s.NoLoopback = !loopback
+}
+
 func (s *Sender) SetTOS(tos int) {
 	s.TOS = tos
 }
 
+// SetTTLSweep makes Max and Forever send each message with the next TTL from
+// ttls, starting again once they have all been used, in place of the TTL.
+// Batching isn't used during a sweep. nil restores the fixed TTL.
+func (s *Sender) SetTTLSweep(ttls []int) {
+	s.TTLSweep = ttls
+}
+
+// SetSources sends every message as raw UDP, built here with each of the
+// source addresses in turn, so one host can stand in for many (S,G) sources.
+// The source port is the destination port if 0. Raw sockets need root or
+// CAP_NET_RAW. Batching isn't used. The sources are walked as each message
+// is sent rather than listed, so the set can be of any size. nil or empty
+// sources go back to a normal UDP socket.
+func (s *Sender) SetSources(sources *AddressSet, port int) {
+	s.Close()
+	s.SourcePort = port
+	if sources == nil || sources.Count() == 0 {
+		s.Sources = nil
+		s.Protocol = "udp"
+		s.SourceAddress = nil
+		return
+	}
+	s.Sources = sources
+	s.Protocol = "ip4:17"
+	s.SourceAddress = sources.At(0)
+}
+
+// sourceCount returns the number of forged sources, 0 if there are none.
+func (s *Sender) sourceCount() uint64 {
+	if s.Sources == nil {
+		return 0
+	}
+	return s.Sources.Count()
+}
+
+// SetFragmentation sets or clears DF on the datagrams sent, or leaves it to
+// the kernel's path MTU discovery with FragmentDefault. Only supported on
+// Linux.
+func (s *Sender) SetFragmentation(f Fragmentation) {
+	s.Fragmentation = f
+}
+
+// SetPayload makes Max and Forever send the given bytes, which may be binary,
+// instead of the message text. A nil payload restores the message text.
+func (s *Sender) SetPayload(payload []byte) {
+	s.Payload = payload
+}
+
+// SetPattern fills the space between the end of the message and the padding
+// size with the pattern, generated from each message's counter, instead of
+// zeros. It has no effect without padding.
+func (s *Sender) SetPattern(pattern *Pattern) {
+	s.Pattern = pattern
+}
+
+// SetTestHeader starts every message sent by Max and Forever with a
+// TestHeader, so the receiver can track loss and verify the contents.
+func (s *Sender) SetTestHeader(header bool) {
+	s.Header = header
+}
+
+// SetCRC ends every message sent by Max and Forever with a CRC32 trailer
+// covering the rest of the message. It also turns on the test header.
+func (s *Sender) SetCRC(crc bool) {
+	s.CRC = crc
+}
+
+// SetProfile makes Max and Forever schedule packets using the given profile
+// instead of sleeping a fixed interval between them. A nil profile restores
+// the fixed interval.
+func (s *Sender) SetProfile(profile Profile) {
+	s.Profile = profile
+}
+
+// SetLimits sets the guardrails the sender is held to in place of
+// DefaultLimits.
+func (s *Sender) SetLimits(limits Limits) {
+	s.Limits = &limits
+}
+
+// One sends a single message, held to the limits along with the messages
+// sent by earlier calls.
 func (s *Sender) One(message string) error {
-	s.SetMessageText(message)
-	return s.Send()
+	limits := limitsOr(s.Limits)
+	if err := s.checkGroup(limits); err != nil {
+		return err
+	}
+	now := time.Now()
+	if p := s.onePace; p == nil || p.limits != limits || now.After(p.start.Add(p.at)) {
+		// a sender that has been idle has no burst saved up
+		s.onePace = newPacer(context.Background(), limits, now)
+	}
+	s.pace = s.onePace
+	defer func() { s.pace = nil }()
+	return s.send([]byte(message))
 }
 
-func (s *Sender) Max(message string, interval int, startValue int, numberOfMessages int) error {
-	var text func(int) string
-	if strings.Contains(message, "{c}") {
-		subStr := strings.Replace(message, "{c}", "%d", 1)
-		text = func(x int) string {
-			return fmt.Sprintf(subStr, x)
+// checkGroup is the limits' CheckGroup of the address, remembering a pass so
+// the local broadcast addresses aren't looked up for every message.
+func (s *Sender) checkGroup(limits Limits) error {
+	if s.checked != nil && s.checked.Equal(s.Address) && s.checkedBy == limits {
+		return nil
+	}
+	if err := limits.CheckGroup(s.Address); err != nil {
+		return err
+	}
+	s.checked, s.checkedBy = s.Address, limits
+	return nil
+}
+
+// send sends the message bytes, recording the result in the statistics. The
+// message is sent once from each of the Sources if they are set.
+func (s *Sender) send(message []byte) error {
+	if s.isRawUDP() && s.sourceCount() > 1 {
+		for it := s.Sources.Iter(); ; {
+			source, ok := it.Next()
+			if !ok {
+				return nil
+			}
+			s.SourceAddress = source
+			if err := s.sendOne(message); err != nil {
+				return err
+			}
+		}
+	}
+	return s.sendOne(message)
+}
+
+func (s *Sender) sendOne(message []byte) error {
+	if s.pace != nil {
+		if err := s.pace.wait(); err != nil {
+			return err
 		}
+	}
+	s.Message = message
+	start := time.Now()
+	err := s.Send()
+	latency := time.Since(start)
+	if s.pace != nil {
+		s.pace.sent(1, s.datagramLen())
+	}
+	if err != nil {
+		s.stats.record(0, 0, latency, err)
 	} else {
-		text = func(x int) string { return message }
+		s.stats.record(1, s.datagramLen(), latency, nil)
 	}
+	return err
+}
+
+// Stats returns the packets, bytes and errors counted since Max or Forever
+// was last started, along with the achieved rates and send call latency. It
+// is safe to call while the sender is running.
+func (s *Sender) Stats() SendStats {
+	return s.stats.stats()
+}
+
+// Max sends numberOfMessages messages, or keeps sending if it is 0, sleeping
+// interval milliseconds between them. message is a Template that is filled in
+// for every message, with the counter starting at startValue.
+func (s *Sender) Max(message string, interval int, startValue int, numberOfMessages int) error {
+	return s.MaxContext(context.Background(), message, interval, startValue, numberOfMessages)
+}
 
+// MaxContext is Max, stopping early with the context's error if the context
+// is done before all of the messages have been sent.
+func (s *Sender) MaxContext(ctx context.Context, message string, interval int, startValue int, numberOfMessages int) error {
+	tmpl, err := ParseTemplate(message)
+	if err != nil {
+		return err
+	}
+	limits := limitsOr(s.Limits)
+	if err := s.checkGroup(limits); err != nil {
+		return err
+	}
+	build, err := s.messageBuilder(tmpl, startValue)
+	if err != nil {
+		return err
+	}
+	s.stats.begin()
+	defer s.stats.finish()
+	s.pace = newPacer(ctx, limits, time.Now())
+	defer func() { s.pace = nil }()
+	if s.TTLSweep != nil {
+		defer func(ttl int) { s.TTL = ttl }(s.TTL)
+	}
+
+	if s.Profile != nil {
+		return s.profiled(ctx, build, startValue, numberOfMessages)
+	}
+	if s.BatchSize > 1 && !s.isRaw() && s.TTLSweep == nil {
+		return s.batched(ctx, build, interval, startValue, numberOfMessages)
+	}
+
+	d := time.Duration(interval) * time.Millisecond
+	var buf []byte
+
+	for i := 0; numberOfMessages == 0 || i < numberOfMessages; i++ {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		buf = build(startValue, buf[:0])
+		err := s.send(buf)
+		if err != nil {
+			return err
+		}
+		startValue++
+		if numberOfMessages != 0 && i+1 == numberOfMessages {
+			break
+		}
+		if err := sleepContext(ctx, d); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// batched sends messages BatchSize at a time, sleeping interval between
+// each batch.
+func (s *Sender) batched(ctx context.Context, build func(int, []byte) []byte, interval int, startValue int, numberOfMessages int) error {
 	d := time.Duration(interval) * time.Millisecond
+	messages := make([][]byte, 0, s.BatchSize)
+	buffers := make([][]byte, s.BatchSize)
+
+	for i := 0; numberOfMessages == 0 || i < numberOfMessages; {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		messages = messages[:0]
+		for ; len(messages) < s.BatchSize && (numberOfMessages == 0 || i < numberOfMessages); i++ {
+			buffers[len(messages)] = build(startValue, buffers[len(messages)][:0])
+			messages = append(messages, buffers[len(messages)])
+			startValue++
+		}
+		if err := s.pace.wait(); err != nil {
+			return err
+		}
+		start := time.Now()
+		n, err := s.SendUDPBatch(messages)
+		bytes := 0
+		for _, message := range messages[:n] {
+			if len(s.padding) > 0 {
+				bytes += len(s.padding)
+			} else {
+				bytes += len(message)
+			}
+		}
+		s.stats.record(n, bytes, time.Since(start), err)
+		s.pace.sent(n, bytes)
+		if err != nil {
+			return err
+		}
+		if numberOfMessages != 0 && i >= numberOfMessages {
+			break
+		}
+		if err := sleepContext(ctx, d); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// profiled sends messages at the offsets chosen by the sender's profile and
+// logs the profile that was actually achieved.
+func (s *Sender) profiled(ctx context.Context, build func(int, []byte) []byte, startValue int, numberOfMessages int) error {
+	start := time.Now()
+	achieved := newProfileLogger(s.AddressAndPort(), start)
+	defer func() { achieved.done(time.Now()) }()
 
+	var at time.Duration
+	var buf []byte
 	for i := 0; numberOfMessages == 0 || i < numberOfMessages; i++ {
-		err := s.One(text(startValue))
+		if err := sleepContext(ctx, time.Until(start.Add(at))); err != nil {
+			return err
+		}
+		buf = build(startValue, buf[:0])
+		err := s.send(buf)
 		if err != nil {
 			return err
 		}
+		achieved.sent(time.Now())
 		startValue++
-		time.Sleep(d)
+		at = s.Profile.Next(i+1, at)
 	}
 	return nil
 }
 
+// Plan returns the traffic MaxContext would send with the same arguments,
+// held to the sender's limits, without sending anything.
+func (s *Sender) Plan(message string, interval int, startValue int, numberOfMessages int) (*SendPlan, error) {
+	tmpl, err := ParseTemplate(message)
+	if err != nil {
+		return nil, err
+	}
+	builder := &payloadBuilder{template: tmpl, payload: s.Payload, pattern: s.Pattern, size: len(s.padding),
+		sizes: s.Sizes, first: startValue, header: s.Header, crc: s.CRC, tos: s.TOS}
+	if err := builder.check(); err != nil {
+		return nil, err
+	}
+	plan := &SendPlan{
+		Streams:    []StreamPlan{{Group: s.Address, Port: s.Port}},
+		Count:      1,
+		PacketSize: builder.largest(TemplateValues{Counter: startValue, Group: s.Address.String(), Port: s.Port, TTL: s.TTL}),
+	}
+	copies := uint64(1)
+	if s.isRawUDP() {
+		plan.Sources = s.sourceCount()
+		copies = plan.Sources
+	}
+	plan.Packets = uint64(numberOfMessages) * copies
+	switch {
+	case s.Profile != nil:
+		plan.RequestedPPS = peakRate(s.Profile)
+	case interval > 0 && s.BatchSize > 1 && !s.isRaw() && s.TTLSweep == nil:
+		plan.RequestedPPS = 1000 / float64(interval) * float64(s.BatchSize)
+	case interval > 0:
+		plan.RequestedPPS = 1000 / float64(interval)
+	default:
+		plan.RequestedPPS = math.Inf(1)
+	}
+	plan.RequestedPPS *= float64(copies)
+	plan.limit(limitsOr(s.Limits), addressSetOf([]net.IP{s.Address}))
+	return plan, nil
+}
+
+// messageBuilder returns a function that builds the message for a given
+// counter value by appending it to a buffer, which may be nil. The message is
+// the sender's Payload if set, or otherwise the template, and is sized and
+// filled from the padding or Sizes and the Pattern.
+func (s *Sender) messageBuilder(tmpl *Template, startValue int) (func(int, []byte) []byte, error) {
+	values := TemplateValues{Group: s.Address.String(), Port: s.Port}
+	if s.isRawUDP() && s.sourceCount() == 1 {
+		port := s.SourcePort
+		if port == 0 {
+			port = s.Port
+		}
+		values.Source = (&net.UDPAddr{IP: s.SourceAddress, Port: port}).String()
+	}
+	if tmpl.uses(fieldSource) && !s.isRaw() {
+		if s.udpConn == nil {
+			if err := s.ConnectUDP(); err != nil {
+				return nil, err
+			}
+		}
+		values.Source = s.udpConn.LocalAddr().String()
+	}
+	builder := &payloadBuilder{template: tmpl, payload: s.Payload, pattern: s.Pattern, size: len(s.padding),
+		sizes: s.Sizes, first: startValue, header: s.Header, crc: s.CRC, tos: s.TOS, sweepTTL: lowestTTL(s.TTLSweep)}
+	if err := builder.check(); err != nil {
+		return nil, err
+	}
+	sweep := s.TTLSweep
+	return func(x int, buf []byte) []byte {
+		if len(sweep) > 0 {
+			// the message is sent straight after it is built, so the TTL
+			// set here is the one it goes out with
+			s.TTL = sweep[(x-startValue)%len(sweep)]
+		}
+		values.Counter = x
+		values.TTL = s.TTL
+		values.Time = time.Time{}
+		return builder.build(buf, values)
+	}, nil
+}
+
+// sleepContext sleeps for d, returning the context's error early if the
+// context is done first.
+func sleepContext(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
+
 func (s *Sender) Forever(message string, interval int, startValue int) error {
 	return s.Max(message, interval, startValue, 0)
 }
+
+// ForeverContext is Forever, stopping with the context's error once the
+// context is done.
+func (s *Sender) ForeverContext(ctx context.Context, message string, interval int, startValue int) error {
+	return s.MaxContext(ctx, message, interval, startValue, 0)
+}
@@ -0,0 +1,192 @@
+/*
+*    mcast - Command line tool and library for testing multicast traffic
+*    flows and stress testing networks and devices.
+*    Copyright (C) 2018 Will Smith
+*
+*    This program is free software: you can redistribute it and/or modify
+*    it under the terms of the GNU General Public License as published by
+*    the Free Software Foundation, either version 3 of the License, or
+*    (at your option) any later version.
+*
+*    This program is distributed in the hope that it will be useful,
+*    but WITHOUT ANY WARRANTY; without even the implied warranty of
+*    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+*    GNU General Public License for more details.
+*
+*    You should have received a copy of the GNU General Public License
+*    along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package multicast
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Sizes chooses the length of each packet. Packets either cycle through Sizes
+// in order, as for a sweep or a list, or, when Weights is set, pick a size at
+// random with the given relative weights, as for an IMIX distribution. The
+// choice only depends on the packet's index, so a run can be repeated
+// exactly.
+type Sizes struct {
+	Sizes   []int
+	Weights []int // weight of each of Sizes, nil to cycle through them
+	spec    string
+	total   int
+}
+
+// simpleIMIX is the classic simple IMIX: 7 small, 4 medium and 1 large packet.
+const simpleIMIX = "7:64,4:576,1:1500"
+
+// ParseSizes parses a specification of packet sizes, in bytes of UDP
+// payload. The supported forms are:
+//
+//	1500                 every packet the same size
+//	64..9000             a sweep through every size in the range
+//	64..9000 step 64     a sweep in steps
+//	64,512,1500          cycle through a list
+//	7:64,4:576,1:1500    a weighted distribution (weight:size)
+//	imix                 the simple IMIX distribution 7:64,4:576,1:1500
+func ParseSizes(spec string) (*Sizes, error) {
+	s := &Sizes{spec: spec}
+	text := strings.TrimSpace(spec)
+	if text == "imix" {
+		text = simpleIMIX
+	}
+	if strings.Contains(text, "..") {
+		sizes, err := parseRange(text, "size", parseSize)
+		if err != nil {
+			return nil, err
+		}
+		s.Sizes = sizes
+		return s, nil
+	}
+	for _, item := range strings.Split(text, ",") {
+		weight := 0
+		if i := strings.Index(item, ":"); i >= 0 {
+			w, err := strconv.Atoi(strings.TrimSpace(item[:i]))
+			if err != nil || w <= 0 {
+				return nil, fmt.Errorf("size weight %q must be a whole number greater than 0", item[:i])
+			}
+			weight, item = w, item[i+1:]
+		}
+		if (weight > 0) != (len(s.Weights) > 0) && len(s.Sizes) > 0 {
+			return nil, fmt.Errorf("either every size or no size must have a weight")
+		}
+		size, err := parseSize(item)
+		if err != nil {
+			return nil, err
+		}
+		s.Sizes = append(s.Sizes, size)
+		if weight > 0 {
+			s.Weights = append(s.Weights, weight)
+		}
+	}
+	s.total = sumWeights(s.Weights)
+	return s, nil
+}
+
+func sumWeights(weights []int) int {
+	total := 0
+	for _, w := range weights {
+		total += w
+	}
+	return total
+}
+
+// parseRange expands a range of the form from..to or from..to step n, with
+// the ends parsed by parse. what names the values in errors.
+func parseRange(text string, what string, parse func(string) (int, error)) ([]int, error) {
+	i := strings.Index(text, "..")
+	from, err := parse(text[:i])
+	if err != nil {
+		return nil, err
+	}
+	rest, step := text[i+2:], 1
+	if j := strings.Index(rest, "step"); j >= 0 {
+		step, err = strconv.Atoi(strings.TrimSpace(rest[j+len("step"):]))
+		if err != nil || step <= 0 {
+			return nil, fmt.Errorf("%v step must be a whole number greater than 0", what)
+		}
+		rest = rest[:j]
+	}
+	to, err := parse(rest)
+	if err != nil {
+		return nil, err
+	}
+	if to < from {
+		return nil, fmt.Errorf("%v range %d..%d must go from the smallest to the largest %v", what, from, to, what)
+	}
+	var values []int
+	for v := from; v <= to; v += step {
+		values = append(values, v)
+	}
+	return values, nil
+}
+
+func parseSize(text string) (int, error) {
+	size, err := strconv.Atoi(strings.TrimSpace(text))
+	if err != nil || size <= 0 || size > maxUDPPayload {
+		return 0, fmt.Errorf("packet size %q must be a whole number from 1 to %d", strings.TrimSpace(text), maxUDPPayload)
+	}
+	return size, nil
+}
+
+// Size returns the size of the packet with the index, counting from 0 for
+// the first packet sent.
+func (s *Sizes) Size(index int) int {
+	if len(s.Weights) == 0 {
+		return s.Sizes[index%len(s.Sizes)]
+	}
+	total := s.total
+	if total == 0 {
+		total = sumWeights(s.Weights)
+	}
+	pick := int(mix64(uint64(index)) % uint64(total))
+	for i, w := range s.Weights {
+		if pick < w {
+			return s.Sizes[i]
+		}
+		pick -= w
+	}
+	return s.Sizes[len(s.Sizes)-1]
+}
+
+// Min returns the smallest size that can be chosen.
+func (s *Sizes) Min() int {
+	least := s.Sizes[0]
+	for _, size := range s.Sizes {
+		if size < least {
+			least = size
+		}
+	}
+	return least
+}
+
+// Max returns the largest size that can be chosen.
+func (s *Sizes) Max() int {
+	most := s.Sizes[0]
+	for _, size := range s.Sizes {
+		if size > most {
+			most = size
+		}
+	}
+	return most
+}
+
+func (s *Sizes) String() string {
+	if s.spec != "" {
+		return s.spec
+	}
+	parts := make([]string, len(s.Sizes))
+	for i, size := range s.Sizes {
+		if len(s.Weights) > 0 {
+			parts[i] = fmt.Sprintf("%d:%d", s.Weights[i], size)
+		} else {
+			parts[i] = strconv.Itoa(size)
+		}
+	}
+	return strings.Join(parts, ",")
+}
@@ -0,0 +1,93 @@
+/*
+*    mcast - Command line tool and library for testing multicast traffic
+*    flows and stress testing networks and devices.
+*    Copyright (C) 2018 Will Smith
+*
+*    This program is free software: you can redistribute it and/or modify
+*    it under the terms of the GNU General Public License as published by
+*    the Free Software Foundation, either version 3 of the License, or
+*    (at your option) any later version.
+*
+*    This program is distributed in the hope that it will be useful,
+*    but WITHOUT ANY WARRANTY; without even the implied warranty of
+*    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+*    GNU General Public License for more details.
+*
+*    You should have received a copy of the GNU General Public License
+*    along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package multicast
+
+import (
+	"testing"
+)
+
+func TestParseSizesSweepAndList(t *testing.T) {
+	s, err := ParseSizes("64..256 step 64")
+	if err != nil {
+		t.Fatal("Received error for valid sweep", err)
+	}
+	for i, expected := range []int{64, 128, 192, 256, 64} {
+		if size := s.Size(i); size != expected {
+			t.Errorf("Expected size %d for packet %d, instead got %d", expected, i, size)
+		}
+	}
+	s, err = ParseSizes("1500, 64,512")
+	if err != nil {
+		t.Fatal("Received error for valid list", err)
+	}
+	if s.Size(1) != 64 || s.Min() != 64 || s.Max() != 1500 {
+		t.Errorf("Unexpected sizes from list %v", s.Sizes)
+	}
+	for _, spec := range []string{"", "0", "70000", "100..64", "64..100 step 0", "1:64,100", "x:64"} {
+		if _, err := ParseSizes(spec); err == nil {
+			t.Errorf("Expected error for size %q", spec)
+		}
+	}
+}
+
+func TestSizesIMIX(t *testing.T) {
+	s, err := ParseSizes("imix")
+	if err != nil {
+		t.Fatal("Received error for imix", err)
+	}
+	counts := map[int]int{}
+	for i := 0; i < 12000; i++ {
+		counts[s.Size(i)]++
+	}
+	for size, expected := range map[int]int{64: 7000, 576: 4000, 1500: 1000} {
+		if counts[size] < expected*9/10 || counts[size] > expected*11/10 {
+			t.Errorf("Expected about %d packets of %d bytes, instead got %d", expected, size, counts[size])
+		}
+	}
+	if s.Size(5) != s.Size(5) {
+		t.Error("Expected the same size for the same packet")
+	}
+}
+
+func TestSizesKeepHeaderIntact(t *testing.T) {
+	tmpl, _ := ParseTemplate("a message longer than the smallest packet size")
+	sizes, _ := ParseSizes("50,300")
+	b := &payloadBuilder{template: tmpl, sizes: sizes, first: 10, header: true, crc: true}
+	if err := b.check(); err != nil {
+		t.Fatal("Received error for sizes that hold the header", err)
+	}
+	for counter, expected := range map[int]int{10: 50, 11: 300} {
+		packet := b.build(nil, TemplateValues{Counter: counter})
+		if len(packet) != expected {
+			t.Errorf("Expected packet of %d bytes, instead got %d", expected, len(packet))
+		}
+		h, err := ParseTestHeader(packet)
+		if err != nil {
+			t.Fatal("Received error parsing header", err)
+		}
+		if err := h.Verify(packet); err != nil {
+			t.Errorf("Expected %d byte packet to verify, instead got %v", expected, err)
+		}
+	}
+	b.sizes, _ = ParseSizes("40..100")
+	if err := b.check(); err == nil {
+		t.Error("Expected error for sizes too small for the header and trailer")
+	}
+}
@@ -0,0 +1,391 @@
+/*
+*    mcast - Command line tool and library for testing multicast traffic
+*    flows and stress testing networks and devices.
+*    Copyright (C) 2018 Will Smith
+*
+*    This program is free software: you can redistribute it and/or modify
+*    it under the terms of the GNU General Public License as published by
+*    the Free Software Foundation, either version 3 of the License, or
+*    (at your option) any later version.
+*
+*    This program is distributed in the hope that it will be useful,
+*    but WITHOUT ANY WARRANTY; without even the implied warranty of
+*    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+*    GNU General Public License for more details.
+*
+*    You should have received a copy of the GNU General Public License
+*    along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package multicast
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"unsafe"
+)
+
+// ProcNetDir is where Linux reports the multicast state of the host.
+const ProcNetDir = "/proc/net"
+
+// MulticastState is the multicast state of the host's interfaces, as the
+// kernel sees it: which groups have been joined, with what source filters,
+// and which link layer multicast addresses the interfaces accept.
+type MulticastState struct {
+	Interfaces []*InterfaceState
+}
+
+// InterfaceState is the multicast state of one interface.
+type InterfaceState struct {
+	Index         int
+	Name          string
+	IGMPVersion   int // version used on the interface, 0 if unknown
+	Groups        []*GroupState
+	LinkAddresses []LinkAddress
+}
+
+// GroupState is a group joined on an interface.
+type GroupState struct {
+	Group        net.IP
+	Users        int  // sockets and kernel users that joined the group
+	TimerRunning bool // a report is due
+	// Timer is the time until the report is sent, in hundredths of a second
+	// (USER_HZ clock ticks) for both IPv4 and IPv6.
+	Timer    int
+	Reporter bool // this host sent the last report for the group
+	Sources  []SourceFilter
+}
+
+// SourceFilter is a source filter on a joined group. Include and Exclude
+// count the sockets including and excluding the source.
+type SourceFilter struct {
+	Source  net.IP
+	Include int
+	Exclude int
+}
+
+// LinkAddress is a link layer multicast address an interface accepts.
+type LinkAddress struct {
+	Address net.HardwareAddr
+	Users   int
+	Global  int // users of the address from outside the interface's own groups
+}
+
+// IPv6 group flags in /proc/net/igmp6.
+const (
+	mldTimerRunning = 0x01
+	mldLastReporter = 0x02
+)
+
+// hostOrder is the byte order /proc/net/igmp prints group addresses in.
+var hostOrder binary.ByteOrder = binary.LittleEndian
+
+func init() {
+	x := uint16(1)
+	if *(*byte)(unsafe.Pointer(&x)) == 0 {
+		hostOrder = binary.BigEndian
+	}
+}
+
+// ReadMulticastState reads the multicast state of the host from ProcNetDir.
+// It is only available on Linux.
+func ReadMulticastState() (*MulticastState, error) {
+	return readMulticastState(ProcNetDir, hostOrder)
+}
+
+// readMulticastState reads the igmp, igmp6, mcfilter, mcfilter6 and
+// dev_mcast files in dir. Only igmp has to exist, as the IPv6 files are
+// missing when IPv6 is disabled.
+func readMulticastState(dir string, order binary.ByteOrder) (*MulticastState, error) {
+	s := &MulticastState{}
+	files := []struct {
+		name     string
+		optional bool
+		parse    func(io.Reader) error
+	}{
+		{"igmp", false, func(r io.Reader) error { return s.parseIGMP(r, order) }},
+		{"igmp6", true, s.parseIGMP6},
+		{"mcfilter", true, s.parseMCFilter},
+		{"mcfilter6", true, s.parseMCFilter},
+		{"dev_mcast", true, s.parseDevMcast},
+	}
+	for _, file := range files {
+		f, err := os.Open(filepath.Join(dir, file.name))
+		if err != nil {
+			if file.optional && os.IsNotExist(err) {
+				continue
+			}
+			return nil, err
+		}
+		err = file.parse(f)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("%v: %v", file.name, err)
+		}
+	}
+	sort.Slice(s.Interfaces, func(i, j int) bool { return s.Interfaces[i].Index < s.Interfaces[j].Index })
+	return s, nil
+}
+
+// Interface returns the state of the named interface, or nil if it has
+// none.
+func (s *MulticastState) Interface(name string) *InterfaceState {
+	for _, ifs := range s.Interfaces {
+		if ifs.Name == name {
+			return ifs
+		}
+	}
+	return nil
+}
+
+// Joined returns the interfaces the group is joined on.
+func (s *MulticastState) Joined(group net.IP) []*InterfaceState {
+	var joined []*InterfaceState
+	for _, ifs := range s.Interfaces {
+		if ifs.Group(group) != nil {
+			joined = append(joined, ifs)
+		}
+	}
+	return joined
+}
+
+// Group returns the state of the group on the interface, or nil if it isn't
+// joined.
+func (ifs *InterfaceState) Group(group net.IP) *GroupState {
+	for _, g := range ifs.Groups {
+		if g.Group.Equal(group) {
+			return g
+		}
+	}
+	return nil
+}
+
+// iface returns the state for the interface index, adding it if needed.
+// mcfilter truncates names, so interfaces are matched by index.
+func (s *MulticastState) iface(index int, name string) *InterfaceState {
+	for _, ifs := range s.Interfaces {
+		if ifs.Index == index {
+			return ifs
+		}
+	}
+	ifs := &InterfaceState{Index: index, Name: name}
+	s.Interfaces = append(s.Interfaces, ifs)
+	return ifs
+}
+
+func (ifs *InterfaceState) group(group net.IP) *GroupState {
+	if g := ifs.Group(group); g != nil {
+		return g
+	}
+	g := &GroupState{Group: group}
+	ifs.Groups = append(ifs.Groups, g)
+	return g
+}
+
+// parseIGMP parses /proc/net/igmp, where a line for each interface is
+// followed by a line for each of its groups:
+//
+//	Idx	Device    : Count Querier	Group    Users Timer	Reporter
+//	2	eth0      :     3      V2
+//					320101EF     2 1:000001F4		1
+//
+// The group is printed as a number in host byte order.
+func (s *MulticastState) parseIGMP(r io.Reader, order binary.ByteOrder) error {
+	var ifs *InterfaceState
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := scanner.Text()
+		fields := strings.Fields(text)
+		if line == 1 || len(fields) == 0 {
+			continue
+		}
+		if !strings.HasPrefix(text, "\t") {
+			// Idx Device : Count Querier, where the device is padded to 10
+			// characters so a longer name runs into the colon
+			parts := strings.SplitN(text, ":", 2)
+			if len(parts) != 2 {
+				return fmt.Errorf("line %d: unexpected interface line %q", line, text)
+			}
+			device, counts := strings.Fields(parts[0]), strings.Fields(parts[1])
+			if len(device) != 2 || len(counts) < 2 {
+				return fmt.Errorf("line %d: unexpected interface line %q", line, text)
+			}
+			index, err := strconv.Atoi(device[0])
+			if err != nil {
+				return fmt.Errorf("line %d: %v", line, err)
+			}
+			ifs = s.iface(index, device[1])
+			if v := strings.TrimPrefix(counts[1], "V"); v != counts[1] {
+				ifs.IGMPVersion, _ = strconv.Atoi(v)
+			}
+			continue
+		}
+		if ifs == nil || len(fields) < 4 {
+			return fmt.Errorf("line %d: unexpected group line %q", line, text)
+		}
+		addr, err := strconv.ParseUint(fields[0], 16, 32)
+		if err != nil {
+			return fmt.Errorf("line %d: %v", line, err)
+		}
+		ip := make(net.IP, 4)
+		order.PutUint32(ip, uint32(addr))
+		g := ifs.group(ip)
+		if g.Users, err = strconv.Atoi(fields[1]); err != nil {
+			return fmt.Errorf("line %d: %v", line, err)
+		}
+		timer := strings.SplitN(fields[2], ":", 2)
+		if len(timer) != 2 {
+			return fmt.Errorf("line %d: unexpected timer %q", line, fields[2])
+		}
+		g.TimerRunning = timer[0] != "0"
+		ticks, err := strconv.ParseUint(timer[1], 16, 64)
+		if err != nil {
+			return fmt.Errorf("line %d: %v", line, err)
+		}
+		g.Timer = int(int32(ticks))
+		g.Reporter = fields[3] != "0"
+	}
+	return scanner.Err()
+}
+
+// parseIGMP6 parses /proc/net/igmp6, which has a line for each group:
+//
+//	2    eth0            ff3e0000000000000000000000001234     1 00000007 250
+//
+// giving the users, the group's flags and the timer.
+func (s *MulticastState) parseIGMP6(r io.Reader) error {
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) < 6 {
+			return fmt.Errorf("line %d: unexpected line %q", line, scanner.Text())
+		}
+		index, err := strconv.Atoi(fields[0])
+		if err != nil {
+			return fmt.Errorf("line %d: %v", line, err)
+		}
+		ip, err := parseHexIP(fields[2])
+		if err != nil {
+			return fmt.Errorf("line %d: %v", line, err)
+		}
+		g := s.iface(index, fields[1]).group(ip)
+		if g.Users, err = strconv.Atoi(fields[3]); err != nil {
+			return fmt.Errorf("line %d: %v", line, err)
+		}
+		flags, err := strconv.ParseUint(fields[4], 16, 32)
+		if err != nil {
+			return fmt.Errorf("line %d: %v", line, err)
+		}
+		g.TimerRunning = flags&mldTimerRunning != 0
+		g.Reporter = flags&mldLastReporter != 0
+		if g.Timer, err = strconv.Atoi(fields[5]); err != nil {
+			return fmt.Errorf("line %d: %v", line, err)
+		}
+	}
+	return scanner.Err()
+}
+
+// parseMCFilter parses /proc/net/mcfilter or mcfilter6, which have a header
+// and then a line for each source filter:
+//
+//	Idx Device        MCA        SRC    INC    EXC
+//	  3   eth1 0xef010133 0xc000020a      1      0
+//
+// IPv4 addresses are printed as numbers and IPv6 ones in hex.
+func (s *MulticastState) parseMCFilter(r io.Reader) error {
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		fields := strings.Fields(scanner.Text())
+		if line == 1 || len(fields) == 0 {
+			continue
+		}
+		if len(fields) < 6 {
+			return fmt.Errorf("line %d: unexpected line %q", line, scanner.Text())
+		}
+		index, err := strconv.Atoi(fields[0])
+		if err != nil {
+			return fmt.Errorf("line %d: %v", line, err)
+		}
+		group, err := parseHexIP(fields[2])
+		if err != nil {
+			return fmt.Errorf("line %d: %v", line, err)
+		}
+		source, err := parseHexIP(fields[3])
+		if err != nil {
+			return fmt.Errorf("line %d: %v", line, err)
+		}
+		f := SourceFilter{Source: source}
+		if f.Include, err = strconv.Atoi(fields[4]); err != nil {
+			return fmt.Errorf("line %d: %v", line, err)
+		}
+		if f.Exclude, err = strconv.Atoi(fields[5]); err != nil {
+			return fmt.Errorf("line %d: %v", line, err)
+		}
+		g := s.iface(index, fields[1]).group(group)
+		g.Sources = append(g.Sources, f)
+	}
+	return scanner.Err()
+}
+
+// parseDevMcast parses /proc/net/dev_mcast, which has a line for each link
+// layer address:
+//
+//	2    eth0            1     0     01005e010132
+func (s *MulticastState) parseDevMcast(r io.Reader) error {
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) < 5 {
+			return fmt.Errorf("line %d: unexpected line %q", line, scanner.Text())
+		}
+		index, err := strconv.Atoi(fields[0])
+		if err != nil {
+			return fmt.Errorf("line %d: %v", line, err)
+		}
+		var a LinkAddress
+		if a.Users, err = strconv.Atoi(fields[2]); err != nil {
+			return fmt.Errorf("line %d: %v", line, err)
+		}
+		if a.Global, err = strconv.Atoi(fields[3]); err != nil {
+			return fmt.Errorf("line %d: %v", line, err)
+		}
+		if a.Address, err = hex.DecodeString(fields[4]); err != nil {
+			return fmt.Errorf("line %d: %v", line, err)
+		}
+		ifs := s.iface(index, fields[1])
+		ifs.LinkAddresses = append(ifs.LinkAddresses, a)
+	}
+	return scanner.Err()
+}
+
+// parseHexIP parses an address printed as hex, either an IPv4 address as a
+// 0x prefixed number or an IPv6 address as 32 digits.
+func parseHexIP(text string) (net.IP, error) {
+	if strings.HasPrefix(text, "0x") {
+		addr, err := strconv.ParseUint(text[2:], 16, 32)
+		if err != nil {
+			return nil, err
+		}
+		return IntToIP4(uint32(addr)).To4(), nil
+	}
+	b, err := hex.DecodeString(text)
+	if err != nil || len(b) != net.IPv6len {
+		return nil, fmt.Errorf("%q is not an IPv6 address", text)
+	}
+	return net.IP(b), nil
+}
@@ -0,0 +1,93 @@
+/*
+*    mcast - Command line tool and library for testing multicast traffic
+*    flows and stress testing networks and devices.
+*    Copyright (C) 2018 Will Smith
+*
+*    This program is free software: you can redistribute it and/or modify
+*    it under the terms of the GNU General Public License as published by
+*    the Free Software Foundation, either version 3 of the License, or
+*    (at your option) any later version.
+*
+*    This program is distributed in the hope that it will be useful,
+*    but WITHOUT ANY WARRANTY; without even the implied warranty of
+*    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+*    GNU General Public License for more details.
+*
+*    You should have received a copy of the GNU General Public License
+*    along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package multicast
+
+import (
+	"encoding/binary"
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestReadMulticastState(t *testing.T) {
+	s, err := readMulticastState("testdata/proc", binary.LittleEndian)
+	if err != nil {
+		t.Fatal("Received error reading state", err)
+	}
+	if len(s.Interfaces) != 4 {
+		t.Fatalf("Expected 4 interfaces, instead got %d", len(s.Interfaces))
+	}
+
+	// names of 10 or more characters run into the colon
+	bridge := s.Interface("br-8e2a3c2b1f4d")
+	if bridge == nil || bridge.Index != 5 || bridge.IGMPVersion != 3 || bridge.Group(net.ParseIP("239.1.1.52")) == nil {
+		t.Errorf("Expected br-8e2a3c2b1f4d with index 5, IGMPv3 and 239.1.1.52, instead got %+v", bridge)
+	}
+
+	eth0 := s.Interface("eth0")
+	if eth0 == nil || eth0.Index != 2 || eth0.IGMPVersion != 2 {
+		t.Fatalf("Expected eth0 with index 2 and IGMPv2, instead got %+v", eth0)
+	}
+	g := eth0.Group(net.ParseIP("239.1.1.50"))
+	if g == nil {
+		t.Fatal("Expected 239.1.1.50 to be joined on eth0")
+	}
+	if g.Users != 2 || !g.TimerRunning || g.Timer != 0x1f4 || !g.Reporter {
+		t.Errorf("Expected 2 users, a running timer of 500 and reporter, instead got %+v", g)
+	}
+	if len(eth0.Groups) != 6 {
+		t.Errorf("Expected 3 IPv4 and 3 IPv6 groups on eth0, instead got %d", len(eth0.Groups))
+	}
+	g6 := eth0.Group(net.ParseIP("ff3e::1234"))
+	if g6 == nil || !g6.TimerRunning || !g6.Reporter || g6.Timer != 250 {
+		t.Errorf("Expected ff3e::1234 with a running timer of 250, instead got %+v", g6)
+	} else if len(g6.Sources) != 1 || !g6.Sources[0].Source.Equal(net.ParseIP("2001:db8::1")) {
+		t.Errorf("Expected source 2001:db8::1 for ff3e::1234, instead got %+v", g6.Sources)
+	}
+	if len(eth0.LinkAddresses) != 4 || eth0.LinkAddresses[1].Address.String() != "01:00:5e:01:01:32" {
+		t.Errorf("Expected 4 link addresses with 01:00:5e:01:01:32 second, instead got %v", eth0.LinkAddresses)
+	}
+
+	// mcfilter truncates the name, so sources are matched by index
+	eth1 := s.Interface("eth1")
+	if eth1 == nil || eth1.IGMPVersion != 3 {
+		t.Fatalf("Expected eth1 with IGMPv3, instead got %+v", eth1)
+	}
+	ssm := eth1.Group(net.ParseIP("239.1.1.51"))
+	if ssm == nil || len(ssm.Sources) != 2 || !ssm.Sources[1].Source.Equal(net.ParseIP("192.0.2.11")) || ssm.Sources[1].Include != 1 {
+		t.Errorf("Expected 2 included sources for 239.1.1.51, instead got %+v", ssm)
+	}
+
+	joined := s.Joined(net.ParseIP("224.0.0.1"))
+	if len(joined) != 2 || joined[0].Name != "lo" || joined[1].Name != "eth0" {
+		t.Errorf("Expected 224.0.0.1 joined on lo and eth0, instead got %v", joined)
+	}
+	if joined := s.Joined(net.ParseIP("239.9.9.9")); len(joined) != 0 {
+		t.Errorf("Expected 239.9.9.9 not to be joined, instead got %v", joined)
+	}
+}
+
+func TestParseIGMPBadLine(t *testing.T) {
+	s := &MulticastState{}
+	err := s.parseIGMP(strings.NewReader("Idx\tDevice\n2\teth0      :     3      V2\n\t\t\t\tnothex     2 1:000001F4\t\t1\n"), binary.LittleEndian)
+	if err == nil || !strings.Contains(err.Error(), "line 3") {
+		t.Errorf("Expected error on line 3, instead got %v", err)
+	}
+}
@@ -0,0 +1,129 @@
+/*
+*    mcast - Command line tool and library for testing multicast traffic
+*    flows and stress testing networks and devices.
+*    Copyright (C) 2018 Will Smith
+*
+*    This program is free software: you can redistribute it and/or modify
+*    it under the terms of the GNU General Public License as published by
+*    the Free Software Foundation, either version 3 of the License, or
+*    (at your option) any later version.
+*
+*    This program is distributed in the hope that it will be useful,
+*    but WITHOUT ANY WARRANTY; without even the implied warranty of
+*    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+*    GNU General Public License for more details.
+*
+*    You should have received a copy of the GNU General Public License
+*    along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package multicast
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// SendStats is a snapshot of what a sender has done so far. Latency is the
+// time spent in each send call, which covers a whole batch when batching.
+type SendStats struct {
+	Packets    uint64        `json:"packets"`
+	Bytes      uint64        `json:"bytes"`
+	Errors     uint64        `json:"errors"`
+	Elapsed    time.Duration `json:"elapsed_ns"`
+	PPS        float64       `json:"pps"`
+	BPS        float64       `json:"bps"` // bits per second of UDP payload
+	Calls      uint64        `json:"send_calls"`
+	MinLatency time.Duration `json:"min_latency_ns"`
+	AvgLatency time.Duration `json:"avg_latency_ns"`
+	MaxLatency time.Duration `json:"max_latency_ns"`
+}
+
+func (s SendStats) String() string {
+	return fmt.Sprintf("packets=%d bytes=%d errors=%d elapsed=%v pps=%.1f bps=%.0f latency min/avg/max=%v/%v/%v",
+		s.Packets, s.Bytes, s.Errors, s.Elapsed.Round(time.Millisecond), s.PPS, s.BPS,
+		s.MinLatency, s.AvgLatency, s.MaxLatency)
+}
+
+// sendCounter accumulates SendStats. It is safe for concurrent use.
+type sendCounter struct {
+	mu           sync.Mutex
+	start        time.Time
+	end          time.Time
+	packets      uint64
+	bytes        uint64
+	errors       uint64
+	calls        uint64
+	totalLatency time.Duration
+	minLatency   time.Duration
+	maxLatency   time.Duration
+}
+
+// begin resets the counter at the start of a run.
+func (c *sendCounter) begin() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.start, c.end = time.Now(), time.Time{}
+	c.packets, c.bytes, c.errors, c.calls = 0, 0, 0, 0
+	c.totalLatency, c.minLatency, c.maxLatency = 0, 0, 0
+}
+
+// finish stops the elapsed time used for rates at the end of a run.
+func (c *sendCounter) finish() {
+	c.mu.Lock()
+	c.end = time.Now()
+	c.mu.Unlock()
+}
+
+// record adds the result of one send call that sent packets datagrams
+// totalling bytes. A failed call counts one error.
+func (c *sendCounter) record(packets int, bytes int, latency time.Duration, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.start.IsZero() {
+		c.start = time.Now().Add(-latency)
+	}
+	c.end = time.Time{}
+	c.packets += uint64(packets)
+	c.bytes += uint64(bytes)
+	if err != nil {
+		c.errors++
+	}
+	c.calls++
+	c.totalLatency += latency
+	if c.calls == 1 || latency < c.minLatency {
+		c.minLatency = latency
+	}
+	if latency > c.maxLatency {
+		c.maxLatency = latency
+	}
+}
+
+func (c *sendCounter) stats() SendStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	s := SendStats{
+		Packets:    c.packets,
+		Bytes:      c.bytes,
+		Errors:     c.errors,
+		Calls:      c.calls,
+		MinLatency: c.minLatency,
+		MaxLatency: c.maxLatency,
+	}
+	if c.calls > 0 {
+		s.AvgLatency = c.totalLatency / time.Duration(c.calls)
+	}
+	if !c.start.IsZero() {
+		end := c.end
+		if end.IsZero() || end.Before(c.start) {
+			end = time.Now()
+		}
+		s.Elapsed = end.Sub(c.start)
+	}
+	if seconds := s.Elapsed.Seconds(); seconds > 0 {
+		s.PPS = float64(s.Packets) / seconds
+		s.BPS = float64(s.Bytes) * 8 / seconds
+	}
+	return s
+}
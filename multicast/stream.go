@@ -0,0 +1,268 @@
+/*
+*    mcast - Command line tool and library for testing multicast traffic
+*    flows and stress testing networks and devices.
+*    Copyright (C) 2018 Will Smith
+*
+*    This program is free software: you can redistribute it and/or modify
+*    it under the terms of the GNU General Public License as published by
+*    the Free Software Foundation, either version 3 of the License, or
+*    (at your option) any later version.
+*
+*    This program is distributed in the hope that it will be useful,
+*    but WITHOUT ANY WARRANTY; without even the implied warranty of
+*    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+*    GNU General Public License for more details.
+*
+*    You should have received a copy of the GNU General Public License
+*    along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package multicast
+
+import (
+	"fmt"
+	"sort"
+)
+
+// StreamStats summarises the test packets received from one source address
+// for one group. Only packets with a TestHeader are counted.
+type StreamStats struct {
+	Source        string `json:"source"`
+	Group         string `json:"group"`
+	Received      uint64 `json:"received"`
+	Lost          uint64 `json:"lost"`       // sequence numbers never received
+	Duplicates    uint64 `json:"duplicates"` // sequence numbers received more than once
+	Reordered     uint64 `json:"reordered"`  // received after a later sequence number
+	Corrupted     uint64 `json:"corrupted"`
+	LengthErrors  uint64 `json:"length_errors"`
+	CRCErrors     uint64 `json:"crc_errors"`
+	PatternErrors uint64 `json:"pattern_errors"`
+	FirstSequence uint64 `json:"first_sequence"`
+	LastSequence  uint64 `json:"last_sequence"` // highest sequence number received
+	// SentTOS is the TOS byte the sender wrote into the test header
+	SentTOS int `json:"sent_tos"`
+	// ReceivedTOS counts the packets that arrived with each TOS byte, when
+	// the platform reports it
+	ReceivedTOS map[int]uint64 `json:"received_tos,omitempty"`
+	// Remarked counts packets whose DSCP was changed along the path
+	Remarked uint64 `json:"remarked"`
+	// TTLs counts the packets received by the TTL they were sent with
+	TTLs map[int]uint64 `json:"ttls,omitempty"`
+	// LowestTTL is the lowest TTL a received packet was sent with
+	LowestTTL int `json:"lowest_ttl"`
+	// SweepTTL is the lowest TTL of the sender's TTL sweep, or 0 if it
+	// wasn't sweeping
+	SweepTTL int `json:"sweep_ttl,omitempty"`
+	// Hops is the number of routers between the sender and receiver, from
+	// how much the TTL fell on the way, or -1 if it isn't known
+	Hops int `json:"hops"`
+	// Sizes counts the packets received of each length, as sent
+	Sizes map[int]uint64 `json:"sizes,omitempty"`
+	// Missing lists the sequence numbers still missing, oldest first
+	Missing []MissingRange `json:"missing,omitempty"`
+}
+
+// MissingRange is a run of sequence numbers that were never received, with
+// the lengths of the packets received either side of it. When datagrams of
+// a certain size are lost, for example because their fragments are dropped
+// and they can't be reassembled, the neighbouring sizes show where the
+// boundary is.
+type MissingRange struct {
+	First      uint64 `json:"first"`
+	Last       uint64 `json:"last"`
+	SizeBefore int    `json:"size_before"`
+	SizeAfter  int    `json:"size_after"`
+}
+
+func (r MissingRange) String() string {
+	return fmt.Sprintf("missing %d-%d (%d packets) between packets of %d and %d bytes",
+		r.First, r.Last, r.Last-r.First+1, r.SizeBefore, r.SizeAfter)
+}
+
+func (s StreamStats) String() string {
+	return fmt.Sprintf("%v -> %v: received=%d lost=%d corrupted=%d (length=%d crc=%d pattern=%d) duplicates=%d reordered=%d remarked=%d sequence=%d-%d",
+		s.Source, s.Group, s.Received, s.Lost, s.Corrupted, s.LengthErrors, s.CRCErrors, s.PatternErrors,
+		s.Duplicates, s.Reordered, s.Remarked, s.FirstSequence, s.LastSequence)
+}
+
+// TTLThreshold reports whether packets that the sweep sent with enough TTL
+// to cover the hops to the receiver didn't arrive, meaning a router on the
+// way drops packets below a TTL threshold, such as at a scope boundary. The
+// lowest TTL that got through is the threshold.
+func (s StreamStats) TTLThreshold() bool {
+	return s.Hops >= 0 && s.SweepTTL > 0 && s.LowestTTL > s.Hops+1 && s.LowestTTL > s.SweepTTL
+}
+
+// ThresholdTTLs returns the TTLs that were sent with enough TTL for the hops
+// but didn't arrive, when there is a TTLThreshold.
+func (s StreamStats) ThresholdTTLs() (from, to int) {
+	from = s.Hops + 1
+	if s.SweepTTL > from {
+		from = s.SweepTTL
+	}
+	return from, s.LowestTTL - 1
+}
+
+// Remarked reports whether the TOS byte the packet arrived with carries a
+// different DSCP from the one the sender wrote into the header. ECN isn't
+// compared, as routers are allowed to mark congestion.
+func Remarked(h *TestHeader, tos int) bool {
+	return tos >= 0 && tos>>2 != int(h.TOS)>>2
+}
+
+// maxMissingRanges limits how many gaps in the sequence numbers are
+// remembered for each stream. Once there are more, the oldest gaps are
+// forgotten and packets filling them are counted as duplicates rather than
+// reordered.
+const maxMissingRanges = 10000
+
+// seqRange is a gap in the sequence numbers along with the sizes of the
+// packets received either side of it.
+type seqRange struct {
+	from, to      uint64
+	before, after int
+}
+
+func newStream(source string, group string) *stream {
+	return &stream{stats: StreamStats{Source: source, Group: group, Hops: -1}}
+}
+
+// stream tracks the sequence numbers and verification results of one
+// stream.
+type stream struct {
+	stats    StreamStats
+	started  bool
+	next     uint64     // one past the highest sequence number received
+	lastSize int        // length of the packet with the highest sequence number
+	missing  []seqRange // gaps in the sequence numbers, oldest first
+}
+
+// snapshot returns a copy of the stream's statistics.
+func (s *stream) snapshot() StreamStats {
+	stats := s.stats
+	stats.Sizes = make(map[int]uint64, len(s.stats.Sizes))
+	for size, count := range s.stats.Sizes {
+		stats.Sizes[size] = count
+	}
+	if s.stats.ReceivedTOS != nil {
+		stats.ReceivedTOS = make(map[int]uint64, len(s.stats.ReceivedTOS))
+		for tos, count := range s.stats.ReceivedTOS {
+			stats.ReceivedTOS[tos] = count
+		}
+	}
+	if s.stats.TTLs != nil {
+		stats.TTLs = make(map[int]uint64, len(s.stats.TTLs))
+		for ttl, count := range s.stats.TTLs {
+			stats.TTLs[ttl] = count
+		}
+	}
+	stats.Missing = make([]MissingRange, len(s.missing))
+	for i, r := range s.missing {
+		stats.Missing[i] = MissingRange{First: r.from, Last: r.to, SizeBefore: r.before, SizeAfter: r.after}
+	}
+	return stats
+}
+
+// received records a test packet, with the result of verifying it and the
+// TOS byte and TTL it arrived with.
+func (s *stream) received(m *message) {
+	h, problem, tos := m.Header, m.Problem, m.TOS
+	s.stats.Received++
+	s.stats.SentTOS = int(h.TOS)
+	if s.stats.TTLs == nil {
+		s.stats.TTLs = map[int]uint64{}
+		s.stats.LowestTTL = int(h.TTL)
+	}
+	s.stats.TTLs[int(h.TTL)]++
+	if int(h.TTL) < s.stats.LowestTTL {
+		s.stats.LowestTTL = int(h.TTL)
+	}
+	s.stats.SweepTTL = int(h.SweepTTL)
+	if m.CM != nil && m.CM.TTL > 0 && m.CM.TTL <= int(h.TTL) {
+		s.stats.Hops = int(h.TTL) - m.CM.TTL
+	}
+	if tos >= 0 {
+		if s.stats.ReceivedTOS == nil {
+			s.stats.ReceivedTOS = map[int]uint64{}
+		}
+		s.stats.ReceivedTOS[tos]++
+		if Remarked(h, tos) {
+			s.stats.Remarked++
+		}
+	}
+	if corruption, ok := problem.(*CorruptionError); ok {
+		s.stats.Corrupted++
+		switch corruption.Kind {
+		case ErrLengthMismatch:
+			s.stats.LengthErrors++
+		case ErrCRCMismatch:
+			s.stats.CRCErrors++
+		case ErrPatternMismatch:
+			s.stats.PatternErrors++
+		}
+	}
+	if s.stats.Sizes == nil {
+		s.stats.Sizes = map[int]uint64{}
+	}
+	s.stats.Sizes[int(h.Length)]++
+	s.sequence(h.Sequence, int(h.Length))
+}
+
+// sequence records the sequence number of a packet of the given size.
+func (s *stream) sequence(seq uint64, size int) {
+	switch {
+	case !s.started:
+		s.started = true
+		s.stats.FirstSequence = seq
+		s.stats.LastSequence = seq
+		s.next = seq + 1
+		s.lastSize = size
+	case seq == s.next:
+		s.stats.LastSequence = seq
+		s.next++
+		s.lastSize = size
+	case seq > s.next:
+		s.missing = append(s.missing, seqRange{s.next, seq - 1, s.lastSize, size})
+		if len(s.missing) > maxMissingRanges {
+			s.missing = s.missing[1:]
+		}
+		s.stats.Lost += seq - s.next
+		s.stats.LastSequence = seq
+		s.next = seq + 1
+		s.lastSize = size
+	default:
+		if s.fill(seq, size) {
+			s.stats.Reordered++
+			s.stats.Lost--
+		} else {
+			s.stats.Duplicates++
+		}
+	}
+}
+
+// fill removes seq from the missing ranges, reporting whether it was
+// missing. The size becomes the neighbouring size of what is left of the
+// range.
+func (s *stream) fill(seq uint64, size int) bool {
+	i := sort.Search(len(s.missing), func(i int) bool { return s.missing[i].to >= seq })
+	if i == len(s.missing) || s.missing[i].from > seq {
+		return false
+	}
+	r := s.missing[i]
+	switch {
+	case r.from == r.to:
+		s.missing = append(s.missing[:i], s.missing[i+1:]...)
+	case seq == r.from:
+		s.missing[i].from++
+		s.missing[i].before = size
+	case seq == r.to:
+		s.missing[i].to--
+		s.missing[i].after = size
+	default:
+		s.missing = append(s.missing, seqRange{})
+		copy(s.missing[i+1:], s.missing[i:])
+		s.missing[i] = seqRange{r.from, seq - 1, r.before, size}
+		s.missing[i+1] = seqRange{seq + 1, r.to, size, r.after}
+	}
+	return true
+}
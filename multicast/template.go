@@ -0,0 +1,220 @@
+/*
+*    mcast - Command line tool and library for testing multicast traffic
+*    flows and stress testing networks and devices.
+*    Copyright (C) 2018 Will Smith
+*
+*    This program is free software: you can redistribute it and/or modify
+*    it under the terms of the GNU General Public License as published by
+*    the Free Software Foundation, either version 3 of the License, or
+*    (at your option) any later version.
+*
+*    This program is distributed in the hope that it will be useful,
+*    but WITHOUT ANY WARRANTY; without even the implied warranty of
+*    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+*    GNU General Public License for more details.
+*
+*    You should have received a copy of the GNU General Public License
+*    along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package multicast
+
+import (
+	"fmt"
+	"math/rand"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Template is a message text containing placeholders that are filled in for
+// every packet sent. The placeholders are:
+//
+//	{c}        the message counter
+//	{seq}      the message counter, same as {c}
+//	{seq:hex}  the message counter in hexadecimal, as the 64 bit sequence
+//	           of the test header, so a negative counter wraps around
+//	{ts}       the send time as nanoseconds since the Unix epoch
+//	{iso}      the send time in RFC 3339 format, UTC
+//	{host}     the sending host's name
+//	{group}    the destination address
+//	{port}     the destination port
+//	{src}      the local address the packet is sent from
+//	{ttl}      the TTL the packet is sent with
+//	{rand:N}   N random letters and digits
+//
+// Placeholders can be used any number of times. Any other text, including
+// braces that don't form a placeholder and '%' characters, is sent as is.
+type Template struct {
+	parts []templatePart
+	mu    sync.Mutex
+	rnd   *rand.Rand
+}
+
+type templateField int
+
+const (
+	fieldLiteral templateField = iota
+	fieldCounter
+	fieldCounterHex
+	fieldTimestamp
+	fieldISO
+	fieldHost
+	fieldGroup
+	fieldPort
+	fieldSource
+	fieldTTL
+	fieldRandom
+)
+
+type templatePart struct {
+	field   templateField
+	literal string // text for fieldLiteral, host name for fieldHost
+	length  int    // number of characters for fieldRandom
+}
+
+// TemplateValues are the per packet values a Template is filled in with.
+type TemplateValues struct {
+	Counter int
+	Group   string
+	Port    int
+	Source  string
+	TTL     int
+	Time    time.Time // time.Now() is used if zero
+}
+
+const randomCharacters = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+
+// maxUDPPayload is the largest payload an IPv4 UDP datagram can carry.
+const maxUDPPayload = 65507
+
+// ParseTemplate compiles the message text into a Template.
+func ParseTemplate(text string) (*Template, error) {
+	t := &Template{rnd: rand.New(rand.NewSource(time.Now().UnixNano()))}
+	literal := ""
+	for len(text) > 0 {
+		open := strings.Index(text, "{")
+		if open < 0 {
+			literal += text
+			break
+		}
+		end := strings.Index(text[open:], "}")
+		if end < 0 {
+			literal += text
+			break
+		}
+		end += open
+		part, ok, err := parsePlaceholder(text[open+1 : end])
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			// not a placeholder, keep the brace and carry on after it
+			literal += text[:open+1]
+			text = text[open+1:]
+			continue
+		}
+		literal += text[:open]
+		if literal != "" {
+			t.parts = append(t.parts, templatePart{field: fieldLiteral, literal: literal})
+			literal = ""
+		}
+		t.parts = append(t.parts, part)
+		text = text[end+1:]
+	}
+	if literal != "" {
+		t.parts = append(t.parts, templatePart{field: fieldLiteral, literal: literal})
+	}
+	return t, nil
+}
+
+// parsePlaceholder parses the name between a pair of braces, reporting false
+// if it isn't a placeholder.
+func parsePlaceholder(name string) (templatePart, bool, error) {
+	switch name {
+	case "c", "seq":
+		return templatePart{field: fieldCounter}, true, nil
+	case "seq:hex":
+		return templatePart{field: fieldCounterHex}, true, nil
+	case "ts":
+		return templatePart{field: fieldTimestamp}, true, nil
+	case "iso":
+		return templatePart{field: fieldISO}, true, nil
+	case "host":
+		host, err := os.Hostname()
+		if err != nil {
+			return templatePart{}, false, err
+		}
+		return templatePart{field: fieldHost, literal: host}, true, nil
+	case "group":
+		return templatePart{field: fieldGroup}, true, nil
+	case "port":
+		return templatePart{field: fieldPort}, true, nil
+	case "src":
+		return templatePart{field: fieldSource}, true, nil
+	case "ttl":
+		return templatePart{field: fieldTTL}, true, nil
+	}
+	if strings.HasPrefix(name, "rand:") {
+		n, err := strconv.Atoi(name[len("rand:"):])
+		if err != nil || n < 1 || n > maxUDPPayload {
+			return templatePart{}, false, fmt.Errorf("{%v} needs a length from 1 to %d", name, maxUDPPayload)
+		}
+		return templatePart{field: fieldRandom, length: n}, true, nil
+	}
+	return templatePart{}, false, nil
+}
+
+// uses reports whether the template contains the placeholder field.
+func (t *Template) uses(field templateField) bool {
+	for _, part := range t.parts {
+		if part.field == field {
+			return true
+		}
+	}
+	return false
+}
+
+// Render returns the template filled in with the values.
+func (t *Template) Render(v TemplateValues) string {
+	return string(t.AppendRender(nil, v))
+}
+
+// AppendRender appends the template filled in with the values to buf and
+// returns the extended buffer.
+func (t *Template) AppendRender(buf []byte, v TemplateValues) []byte {
+	if v.Time.IsZero() {
+		v.Time = time.Now()
+	}
+	for _, part := range t.parts {
+		switch part.field {
+		case fieldLiteral, fieldHost:
+			buf = append(buf, part.literal...)
+		case fieldCounter:
+			buf = strconv.AppendInt(buf, int64(v.Counter), 10)
+		case fieldCounterHex:
+			buf = strconv.AppendUint(buf, uint64(v.Counter), 16)
+		case fieldTimestamp:
+			buf = strconv.AppendInt(buf, v.Time.UnixNano(), 10)
+		case fieldISO:
+			buf = v.Time.UTC().AppendFormat(buf, time.RFC3339Nano)
+		case fieldGroup:
+			buf = append(buf, v.Group...)
+		case fieldPort:
+			buf = strconv.AppendInt(buf, int64(v.Port), 10)
+		case fieldSource:
+			buf = append(buf, v.Source...)
+		case fieldTTL:
+			buf = strconv.AppendInt(buf, int64(v.TTL), 10)
+		case fieldRandom:
+			t.mu.Lock()
+			for i := 0; i < part.length; i++ {
+				buf = append(buf, randomCharacters[t.rnd.Intn(len(randomCharacters))])
+			}
+			t.mu.Unlock()
+		}
+	}
+	return buf
+}
@@ -0,0 +1,81 @@
+/*
+*    mcast - Command line tool and library for testing multicast traffic
+*    flows and stress testing networks and devices.
+*    Copyright (C) 2018 Will Smith
+*
+*    This program is free software: you can redistribute it and/or modify
+*    it under the terms of the GNU General Public License as published by
+*    the Free Software Foundation, either version 3 of the License, or
+*    (at your option) any later version.
+*
+*    This program is distributed in the hope that it will be useful,
+*    but WITHOUT ANY WARRANTY; without even the implied warranty of
+*    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+*    GNU General Public License for more details.
+*
+*    You should have received a copy of the GNU General Public License
+*    along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package multicast
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTemplateRender(t *testing.T) {
+	tmpl, err := ParseTemplate("{c} of 100% to {group}:{port} from {src} seq {seq:hex} again {c}")
+	if err != nil {
+		t.Fatal("Received error for valid template", err)
+	}
+	out := tmpl.Render(TemplateValues{Counter: 255, Group: "239.1.1.1", Port: 5050, Source: "10.0.0.1:4000"})
+	expected := "255 of 100% to 239.1.1.1:5050 from 10.0.0.1:4000 seq ff again 255"
+	if out != expected {
+		t.Errorf("Expected %q, instead got %q", expected, out)
+	}
+	out = tmpl.Render(TemplateValues{Counter: -10})
+	expected = "-10 of 100% to :0 from  seq fffffffffffffff6 again -10"
+	if out != expected {
+		t.Errorf("Expected %q, instead got %q", expected, out)
+	}
+}
+
+func TestTemplateTime(t *testing.T) {
+	tmpl, err := ParseTemplate("{ts} {iso}")
+	if err != nil {
+		t.Fatal("Received error for valid template", err)
+	}
+	at := time.Date(2018, 3, 18, 1, 2, 3, 4, time.UTC)
+	out := tmpl.Render(TemplateValues{Time: at})
+	expected := "1521334923000000004 2018-03-18T01:02:03.000000004Z"
+	if out != expected {
+		t.Errorf("Expected %q, instead got %q", expected, out)
+	}
+}
+
+func TestTemplateRandom(t *testing.T) {
+	tmpl, err := ParseTemplate("<{rand:12}>")
+	if err != nil {
+		t.Fatal("Received error for valid template", err)
+	}
+	out := tmpl.Render(TemplateValues{})
+	if len(out) != 14 || out[0] != '<' || out[13] != '>' {
+		t.Errorf("Expected 12 random characters between brackets, instead got %q", out)
+	}
+	if _, err := ParseTemplate("{rand:0}"); err == nil {
+		t.Error("Expected error for random placeholder of length 0")
+	}
+}
+
+func TestTemplateUnknownBracesKept(t *testing.T) {
+	tmpl, err := ParseTemplate(`{"id": {c}} {unknown} {`)
+	if err != nil {
+		t.Fatal("Received error for valid template", err)
+	}
+	out := tmpl.Render(TemplateValues{Counter: 7})
+	expected := `{"id": 7} {unknown} {`
+	if out != expected {
+		t.Errorf("Expected %q, instead got %q", expected, out)
+	}
+}
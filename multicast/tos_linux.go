@@ -0,0 +1,69 @@
+/*
+*    mcast - Command line tool and library for testing multicast traffic
+*    flows and stress testing networks and devices.
+*    Copyright (C) 2018 Will Smith
+*
+*    This program is free software: you can redistribute it and/or modify
+*    it under the terms of the GNU General Public License as published by
+*    the Free Software Foundation, either version 3 of the License, or
+*    (at your option) any later version.
+*
+*    This program is distributed in the hope that it will be useful,
+*    but WITHOUT ANY WARRANTY; without even the implied warranty of
+*    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+*    GNU General Public License for more details.
+*
+*    You should have received a copy of the GNU General Public License
+*    along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package multicast
+
+import (
+	"net"
+	"syscall"
+
+	"golang.org/x/net/ipv4"
+)
+
+// enableReceiveTOS asks the kernel to pass the TOS byte of every received
+// datagram along with it.
+func enableReceiveTOS(conn *net.UDPConn) error {
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var serr error
+	err = raw.Control(func(fd uintptr) {
+		serr = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IP, syscall.IP_RECVTOS, 1)
+	})
+	if err != nil {
+		return err
+	}
+	return serr
+}
+
+// oobSize is enough room for the TTL, packet info and TOS control messages.
+const oobSize = 128
+
+// readMessage reads a datagram along with its control message and TOS byte.
+// The TOS is -1 if the kernel didn't pass it.
+func readMessage(conn *net.UDPConn, packetConn *ipv4.PacketConn, buf []byte, oob []byte) (int, *ipv4.ControlMessage, int, net.Addr, error) {
+	n, oobn, _, src, err := conn.ReadMsgUDP(buf, oob)
+	if err != nil {
+		return 0, nil, -1, nil, err
+	}
+	cm := &ipv4.ControlMessage{}
+	if err := cm.Parse(oob[:oobn]); err != nil {
+		cm = nil
+	}
+	tos := -1
+	if msgs, err := syscall.ParseSocketControlMessage(oob[:oobn]); err == nil {
+		for _, m := range msgs {
+			if m.Header.Level == syscall.IPPROTO_IP && m.Header.Type == syscall.IP_TOS && len(m.Data) > 0 {
+				tos = int(m.Data[0])
+			}
+		}
+	}
+	return n, cm, tos, src, nil
+}
@@ -0,0 +1,44 @@
+//go:build !linux
+// +build !linux
+
+/*
+*    mcast - Command line tool and library for testing multicast traffic
+*    flows and stress testing networks and devices.
+*    Copyright (C) 2018 Will Smith
+*
+*    This program is free software: you can redistribute it and/or modify
+*    it under the terms of the GNU General Public License as published by
+*    the Free Software Foundation, either version 3 of the License, or
+*    (at your option) any later version.
+*
+*    This program is distributed in the hope that it will be useful,
+*    but WITHOUT ANY WARRANTY; without even the implied warranty of
+*    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+*    GNU General Public License for more details.
+*
+*    You should have received a copy of the GNU General Public License
+*    along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package multicast
+
+import (
+	"net"
+
+	"golang.org/x/net/ipv4"
+)
+
+// enableReceiveTOS does nothing, as receiving the TOS byte is only supported
+// on Linux.
+func enableReceiveTOS(conn *net.UDPConn) error {
+	return nil
+}
+
+const oobSize = 0
+
+// readMessage reads a datagram along with its control message. The TOS
+// isn't available, so is always -1.
+func readMessage(conn *net.UDPConn, packetConn *ipv4.PacketConn, buf []byte, oob []byte) (int, *ipv4.ControlMessage, int, net.Addr, error) {
+	n, cm, src, err := packetConn.ReadFrom(buf)
+	return n, cm, -1, src, err
+}
@@ -0,0 +1,68 @@
+/*
+*    mcast - Command line tool and library for testing multicast traffic
+*    flows and stress testing networks and devices.
+*    Copyright (C) 2018 Will Smith
+*
+*    This program is free software: you can redistribute it and/or modify
+*    it under the terms of the GNU General Public License as published by
+*    the Free Software Foundation, either version 3 of the License, or
+*    (at your option) any later version.
+*
+*    This program is distributed in the hope that it will be useful,
+*    but WITHOUT ANY WARRANTY; without even the implied warranty of
+*    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+*    GNU General Public License for more details.
+*
+*    You should have received a copy of the GNU General Public License
+*    along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package multicast
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ParseTTLSweep parses the TTLs to send a sweep with, either as a range,
+// 1..64 or 1..64 step 4, or as a list, 1,2,4,8.
+func ParseTTLSweep(spec string) ([]int, error) {
+	text := strings.TrimSpace(spec)
+	if strings.Contains(text, "..") {
+		return parseRange(text, "TTL", parseTTL)
+	}
+	var ttls []int
+	for _, item := range strings.Split(text, ",") {
+		ttl, err := parseTTL(item)
+		if err != nil {
+			return nil, err
+		}
+		ttls = append(ttls, ttl)
+	}
+	return ttls, nil
+}
+
+func parseTTL(text string) (int, error) {
+	ttl, err := strconv.Atoi(strings.TrimSpace(text))
+	if err != nil || ttl < 0 || ttl > 255 {
+		return 0, fmt.Errorf("TTL %q must be a whole number from 0 to 255", strings.TrimSpace(text))
+	}
+	return ttl, nil
+}
+
+// lowestTTL returns the lowest TTL of a sweep for the test header, or 0
+// without a sweep. A sweep starting at 0 is recorded as 1, which crosses no
+// more routers, so that 0 keeps meaning there is no sweep.
+func lowestTTL(sweep []int) int {
+	lowest := 0
+	for i, ttl := range sweep {
+		if i == 0 || ttl < lowest {
+			lowest = ttl
+		}
+	}
+	if len(sweep) > 0 && lowest < 1 {
+		lowest = 1
+	}
+	return lowest
+}
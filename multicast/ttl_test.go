@@ -0,0 +1,58 @@
+/*
+*    mcast - Command line tool and library for testing multicast traffic
+*    flows and stress testing networks and devices.
+*    Copyright (C) 2018 Will Smith
+*
+*    This program is free software: you can redistribute it and/or modify
+*    it under the terms of the GNU General Public License as published by
+*    the Free Software Foundation, either version 3 of the License, or
+*    (at your option) any later version.
+*
+*    This program is distributed in the hope that it will be useful,
+*    but WITHOUT ANY WARRANTY; without even the implied warranty of
+*    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+*    GNU General Public License for more details.
+*
+*    You should have received a copy of the GNU General Public License
+*    along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package multicast
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseTTLSweep(t *testing.T) {
+	for spec, expected := range map[string][]int{
+		"1..4":          {1, 2, 3, 4},
+		"1..64 step 32": {1, 33},
+		"1, 8,255":      {1, 8, 255},
+	} {
+		ttls, err := ParseTTLSweep(spec)
+		if err != nil || !reflect.DeepEqual(ttls, expected) {
+			t.Errorf("Expected %v for %q, instead got %v (%v)", expected, spec, ttls, err)
+		}
+	}
+	for _, spec := range []string{"", "0..256", "5..1", "1,x"} {
+		if _, err := ParseTTLSweep(spec); err == nil {
+			t.Errorf("Expected error for TTL sweep %q", spec)
+		}
+	}
+}
+
+func TestLowestTTL(t *testing.T) {
+	for _, test := range []struct {
+		sweep    []int
+		expected int
+	}{
+		{nil, 0},
+		{[]int{8, 4, 16}, 4},
+		{[]int{0, 1, 2}, 1},
+	} {
+		if lowest := lowestTTL(test.sweep); lowest != test.expected {
+			t.Errorf("Expected %d for %v, instead got %d", test.expected, test.sweep, lowest)
+		}
+	}
+}
@@ -21,7 +21,6 @@ package multicast
 
 import (
 	"fmt"
-	"math"
 	"net"
 	"strconv"
 	"strings"
@@ -54,6 +53,33 @@ func ComputeChecksum(buf []byte) uint16 {
 	return csum
 }
 
+// UDPChecksum returns the UDP checksum of a UDP header and payload, with
+// the checksum field zero, sent from src to dst. It covers the IPv4
+// pseudo-header as well as the datagram, as RFC 768 describes.
+func UDPChecksum(src net.IP, dst net.IP, udp []byte) uint16 {
+	pseudo := make([]byte, 12, 12+len(udp))
+	copy(pseudo[0:4], src.To4())
+	copy(pseudo[4:8], dst.To4())
+	pseudo[9] = 17
+	pseudo[10] = byte(len(udp) >> 8)
+	pseudo[11] = byte(len(udp))
+	return ComputeChecksum(append(pseudo, udp...))
+}
+
+// AppendUDP appends a UDP header and the payload to buf, with the checksum
+// filled in for a datagram sent from src to dst.
+func AppendUDP(buf []byte, src net.IP, srcPort int, dst net.IP, dstPort int, payload []byte) []byte {
+	start := len(buf)
+	length := 8 + len(payload)
+	buf = append(buf, byte(srcPort>>8), byte(srcPort), byte(dstPort>>8), byte(dstPort),
+		byte(length>>8), byte(length), 0, 0)
+	buf = append(buf, payload...)
+	checksum := UDPChecksum(src, dst, buf[start:])
+	buf[start+6] = byte(checksum >> 8)
+	buf[start+7] = byte(checksum)
+	return buf
+}
+
 // ComputeChecksumBytes returns the 16bit checksum split into high and low bytes for a given byte slice.
 func ComputeChecksumBytes(buf []byte) (byte, byte) {
 	checksum := ComputeChecksum(buf)
@@ -82,19 +108,25 @@ func IntToIP4(ipInt uint32) net.IP {
 // The returned slice will be based on the network that the provided ip falls
 // in and not necessarily the exact ip given. The returned slice will also
 // include both the network address and the broadcast address as the first
-// and last items of the slice.
+// and last items of the slice. Networks of more than MaxListAddresses are
+// refused; ParseAddressSet walks them without building a list.
 func IPList(network string, mask int) ([]net.IP, error) {
 	_, ipnet, err := net.ParseCIDR(fmt.Sprintf("%v/%d", network, mask))
 	if err != nil {
 		return nil, err
 	}
+	if ipnet.IP.To4() == nil {
+		return nil, fmt.Errorf("%v is not an IPv4 network", ipnet)
+	}
 	networkBits, totalBits := ipnet.Mask.Size()
-	hostBits := totalBits - networkBits
-	numberOfHosts := uint32(math.Pow(float64(2), float64(hostBits)))
+	numberOfHosts := uint64(1) << uint(totalBits-networkBits)
+	if numberOfHosts > MaxListAddresses {
+		return nil, fmt.Errorf("network %v has %d addresses, more than the limit of %d", ipnet, numberOfHosts, MaxListAddresses)
+	}
 
 	hostAddresses := make([]net.IP, numberOfHosts)
 	networkInt := IP4ToInt(ipnet.IP)
-	for i := uint32(0); i < numberOfHosts; i++ {
+	for i := uint32(0); i < uint32(numberOfHosts); i++ {
 		hostAddresses[i] = IntToIP4(networkInt | i)
 	}
 
@@ -137,3 +169,29 @@ func GetInterface(interfaceName string) (*net.Interface, error) {
 	}
 	return localInterface, err
 }
+
+// ResolveLocalAddress resolves a local address to send from, given as an IP
+// address with an optional source port. Without a port one is picked by the
+// system.
+func ResolveLocalAddress(address string) (*net.UDPAddr, error) {
+	if !strings.Contains(address, ":") {
+		address += ":0"
+	}
+	return net.ResolveUDPAddr("udp4", address)
+}
+
+// InterfaceIPv4 returns the first IPv4 address of the interface.
+func InterfaceIPv4(ifi *net.Interface) (net.IP, error) {
+	addrs, err := ifi.Addrs()
+	if err != nil {
+		return nil, err
+	}
+	for _, addr := range addrs {
+		if ipNet, ok := addr.(*net.IPNet); ok {
+			if ip := ipNet.IP.To4(); ip != nil {
+				return ip, nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("interface %v has no IPv4 address", ifi.Name)
+}
@@ -20,6 +20,7 @@
 package multicast
 
 import (
+	"net"
 	"testing"
 )
 
@@ -100,3 +101,51 @@ func TestIPListCIDRWrongNetwork(t *testing.T) {
 		t.Errorf("Expected %d addresses, instead got %d", 2048, len(a))
 	}
 }
+
+func TestResolveLocalAddress(t *testing.T) {
+	a, err := ResolveLocalAddress("10.1.1.1")
+	if err != nil {
+		t.Error("Received error for address without port", err)
+	} else if a.Port != 0 || !a.IP.Equal(net.IPv4(10, 1, 1, 1)) {
+		t.Errorf("Expected 10.1.1.1:0, instead got %v", a)
+	}
+	a, err = ResolveLocalAddress("10.1.1.1:5000")
+	if err != nil || a.Port != 5000 {
+		t.Errorf("Expected port 5000, instead got %v (%v)", a, err)
+	}
+}
+
+func TestInterfaceIPv4(t *testing.T) {
+	ifi, err := net.InterfaceByName("lo")
+	if err != nil {
+		t.Skip("No loopback interface named lo")
+	}
+	ip, err := InterfaceIPv4(ifi)
+	if err != nil || !ip.IsLoopback() {
+		t.Errorf("Expected a loopback address, instead got %v (%v)", ip, err)
+	}
+}
+
+func TestAppendUDPChecksum(t *testing.T) {
+	src, dst := net.IPv4(10, 9, 9, 1), net.IPv4(239, 1, 1, 50)
+	for _, payload := range []string{"", "odd", "even"} {
+		udp := AppendUDP(nil, src, 4000, dst, 5050, []byte(payload))
+		if len(udp) != 8+len(payload) || udp[0] != 4000>>8 || udp[3] != 5050&0xff {
+			t.Errorf("Unexpected UDP header % x", udp[:8])
+		}
+		// summing a datagram that includes its checksum gives all ones,
+		// which ComputeChecksum returns as 0xffff
+		pseudo := append([]byte{10, 9, 9, 1, 239, 1, 1, 50, 0, 17, 0, byte(len(udp))}, udp...)
+		if sum := ComputeChecksum(pseudo); sum != 0xffff {
+			t.Errorf("Expected checksum to verify for %q, instead got %#04x", payload, sum)
+		}
+	}
+}
+
+func TestIPListTooLarge(t *testing.T) {
+	for _, address := range []string{"0.0.0.0/0", "10.0.0.0/8"} {
+		if _, err := IPListCIDR(address); err == nil {
+			t.Errorf("Expected listing %v to be refused", address)
+		}
+	}
+}